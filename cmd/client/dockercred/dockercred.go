@@ -0,0 +1,12 @@
+// Package dockercred exposes the docker credential helper of the client to the
+// docker-credential-gophkeeper command, which lives outside of the client tree
+// and cannot import its internal packages.
+package dockercred
+
+import "github.com/rawen554/goph-keeper/cmd/client/internal/cmd"
+
+// Execute runs the helper with the process arguments. It shares the login state and
+// config of gclient.
+func Execute() error {
+	return cmd.ExecuteDockerCredential()
+}
@@ -0,0 +1,495 @@
+// Package cache keeps the offline copy of user data in a single bbolt database.
+// Every value is sealed with AES-GCM, record keys are HMACs of their paths, so neither
+// secrets nor record names are readable from the file without the cache key.
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	appDir      = "gophkeeper"
+	keyFile     = "cache.key"
+	keySize     = 32
+	openTimeout = 5 * time.Second
+)
+
+var (
+	bucketRecords = []byte("records")
+	bucketIDs     = []byte("ids")
+	bucketPending = []byte("pending")
+	bucketMeta    = []byte("meta")
+
+	metaSyncCursor = []byte("sync_cursor")
+	metaFolders    = []byte("folders")
+)
+
+var (
+	ErrNotFound   = errors.New("not found in cache")
+	ErrCorrupted  = errors.New("cache entry cannot be decrypted")
+	ErrEmptyLogin = errors.New("cache needs a login")
+)
+
+// Cache is an open database of a single user. It holds an exclusive file lock,
+// so it should be closed as soon as possible.
+type Cache struct {
+	db     *bolt.DB
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// DataDir is $XDG_DATA_HOME/gophkeeper, ~/.local/share/gophkeeper by default.
+func DataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, appDir), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding home dir: %w", err)
+	}
+
+	return filepath.Join(home, ".local", "share", appDir), nil
+}
+
+// KeyPath is the cache key file in the user config dir, $XDG_CONFIG_HOME/gophkeeper/cache.key on Linux.
+// It holds the key itself only where there is no system keyring.
+func KeyPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding config dir: %w", err)
+	}
+
+	return filepath.Join(dir, appDir, keyFile), nil
+}
+
+// Path is the database file of login.
+func Path(login string) (string, error) {
+	if login == "" {
+		return "", ErrEmptyLogin
+	}
+
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, url.PathEscape(login)+".db"), nil
+}
+
+// Open opens or creates the cache of login. The cache key is generated on first use.
+func Open(login string) (*Cache, error) {
+	path, err := Path(login)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating cache dir: %w", err)
+	}
+
+	master, err := loadKey()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cache{}
+	if err := c.deriveKeys(master, login); err != nil {
+		return nil, err
+	}
+
+	if c.db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout}); err != nil {
+		return nil, fmt.Errorf("error opening cache: %w", err)
+	}
+
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketRecords, bucketIDs, bucketPending, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.db.Close()
+		return nil, fmt.Errorf("error initializing cache: %w", err)
+	}
+
+	return c, nil
+}
+
+// Remove deletes the cache of login.
+func Remove(login string) error {
+	path, err := Path(login)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing cache: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// keyringMarker replaces the key in the key file once the key is kept in the system keyring.
+// A keyring that is unavailable for a while is then reported instead of a new key being created.
+const keyringMarker = "keyring\n"
+
+// loadKey reads the cache key from the system keyring or, where there is none, from the key file
+// with owner only permissions. The key is created on first use, a key file left by older
+// versions is moved into the keyring.
+func loadKey() ([]byte, error) {
+	path, err := KeyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := os.ReadFile(path)
+	switch {
+	case err == nil && string(key) == keyringMarker:
+		key, err := keyringGet()
+		if err != nil {
+			return nil, fmt.Errorf("cache key is kept in the system keyring: %w", err)
+		}
+		return key, nil
+	case err == nil:
+		if len(key) != keySize {
+			return nil, fmt.Errorf("cache key %s is damaged", path)
+		}
+		if err := keyringSet(key); err == nil {
+			if err := replaceKeyFile(path, []byte(keyringMarker)); err != nil {
+				return nil, err
+			}
+		}
+		return key, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("error reading cache key: %w", err)
+	}
+
+	key = make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("error generating cache key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating config dir: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Another gclient process created the key first.
+		return loadKey()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating cache key: %w", err)
+	}
+	defer f.Close()
+
+	content := key
+	if err := keyringSet(key); err == nil {
+		content = []byte(keyringMarker)
+	}
+	if _, err := f.Write(content); err != nil {
+		return nil, fmt.Errorf("error writing cache key: %w", err)
+	}
+
+	return key, nil
+}
+
+// replaceKeyFile writes the key file through a temporary file, so it is never seen half written.
+func replaceKeyFile(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), keyFile+".*")
+	if err != nil {
+		return fmt.Errorf("error writing cache key: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing cache key: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing cache key: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing cache key: %w", err)
+	}
+
+	return nil
+}
+
+// deriveKeys derives separate encryption and HMAC keys of login from the master key.
+func (c *Cache) deriveKeys(master []byte, login string) error {
+	kdf := hkdf.New(sha256.New, master, []byte(login), []byte("gophkeeper cache"))
+
+	encKey := make([]byte, keySize)
+	c.macKey = make([]byte, keySize)
+	if _, err := io.ReadFull(kdf, encKey); err != nil {
+		return fmt.Errorf("error deriving cache key: %w", err)
+	}
+	if _, err := io.ReadFull(kdf, c.macKey); err != nil {
+		return fmt.Errorf("error deriving cache key: %w", err)
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return fmt.Errorf("error creating cipher: %w", err)
+	}
+	if c.aead, err = cipher.NewGCM(block); err != nil {
+		return fmt.Errorf("error creating cipher: %w", err)
+	}
+
+	return nil
+}
+
+// seal encodes v as json and encrypts it. The bucket key is authenticated, so an entry
+// cannot be moved under another key.
+func (c *Cache) seal(key []byte, v interface{}) ([]byte, error) {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding cache entry: %w", err)
+	}
+
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	return c.aead.Seal(nonce, nonce, plain, key), nil
+}
+
+func (c *Cache) open(key []byte, sealed []byte, v interface{}) error {
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return ErrCorrupted
+	}
+
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], key)
+	if err != nil {
+		return ErrCorrupted
+	}
+
+	if err := json.Unmarshal(plain, v); err != nil {
+		return fmt.Errorf("error decoding cache entry: %w", err)
+	}
+
+	return nil
+}
+
+// recordKey hides the record path behind an HMAC.
+func (c *Cache) recordKey(folderID uint64, name string) []byte {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(strconv.FormatUint(folderID, 10) + models.PathSeparator + name))
+	return mac.Sum(nil)
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// PutRecord stores the record replacing the previous copy. A record renamed or moved
+// on the server replaces its copy under the old path.
+func (c *Cache) PutRecord(record *models.DataRecord) error {
+	key := c.recordKey(record.FolderID, record.Name)
+	sealed, err := c.seal(key, record)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		records, ids := tx.Bucket(bucketRecords), tx.Bucket(bucketIDs)
+		if record.ID != 0 {
+			if old := ids.Get(itob(record.ID)); old != nil && !hmac.Equal(old, key) {
+				if err := records.Delete(old); err != nil {
+					return err
+				}
+			}
+			if err := ids.Put(itob(record.ID), key); err != nil {
+				return err
+			}
+		}
+
+		return records.Put(key, sealed)
+	})
+}
+
+// DeleteRecord removes the cached copy, it is not an error when there is none.
+func (c *Cache) DeleteRecord(record *models.DataRecord) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		records, ids := tx.Bucket(bucketRecords), tx.Bucket(bucketIDs)
+		if record.ID != 0 {
+			if old := ids.Get(itob(record.ID)); old != nil {
+				if err := records.Delete(old); err != nil {
+					return err
+				}
+			}
+			if err := ids.Delete(itob(record.ID)); err != nil {
+				return err
+			}
+		}
+
+		return records.Delete(c.recordKey(record.FolderID, record.Name))
+	})
+}
+
+// Record finds the record by name in the folder, or in any folder when folderID is nil.
+// Like the server, the record with the smallest folder id wins in the latter case.
+func (c *Cache) Record(name string, folderID *uint64) (*models.DataRecord, error) {
+	if folderID != nil {
+		record := &models.DataRecord{}
+		err := c.db.View(func(tx *bolt.Tx) error {
+			key := c.recordKey(*folderID, name)
+			sealed := tx.Bucket(bucketRecords).Get(key)
+			if sealed == nil {
+				return ErrNotFound
+			}
+			return c.open(key, sealed, record)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+
+	records, err := c.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *models.DataRecord
+	for i := range records {
+		if records[i].Name == name && (found == nil || records[i].FolderID < found.FolderID) {
+			found = &records[i]
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+
+	return found, nil
+}
+
+// Records returns every cached record.
+func (c *Cache) Records() ([]models.DataRecord, error) {
+	records := make([]models.DataRecord, 0)
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRecords).ForEach(func(k, v []byte) error {
+			var r models.DataRecord
+			if err := c.open(k, v, &r); err != nil {
+				return err
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// ReplaceRecords drops every cached record and stores records instead.
+func (c *Cache) ReplaceRecords(records []models.DataRecord) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketRecords, bucketIDs} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+
+		recordsBucket, ids := tx.Bucket(bucketRecords), tx.Bucket(bucketIDs)
+		for i := range records {
+			key := c.recordKey(records[i].FolderID, records[i].Name)
+			sealed, err := c.seal(key, &records[i])
+			if err != nil {
+				return err
+			}
+			if err := recordsBucket.Put(key, sealed); err != nil {
+				return err
+			}
+			if records[i].ID != 0 {
+				if err := ids.Put(itob(records[i].ID), key); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// Folders returns the cached folder tree.
+func (c *Cache) Folders() ([]models.Folder, error) {
+	folders := make([]models.Folder, 0)
+	if err := c.getMeta(metaFolders, &folders); err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+func (c *Cache) SetFolders(folders []models.Folder) error {
+	return c.putMeta(metaFolders, folders)
+}
+
+// SyncCursor is the last update time received from the server, zero before the first sync.
+func (c *Cache) SyncCursor() (time.Time, error) {
+	var cursor time.Time
+	if err := c.getMeta(metaSyncCursor, &cursor); err != nil && !errors.Is(err, ErrNotFound) {
+		return time.Time{}, err
+	}
+
+	return cursor, nil
+}
+
+func (c *Cache) SetSyncCursor(cursor time.Time) error {
+	return c.putMeta(metaSyncCursor, cursor)
+}
+
+func (c *Cache) getMeta(key []byte, v interface{}) error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		sealed := tx.Bucket(bucketMeta).Get(key)
+		if sealed == nil {
+			return ErrNotFound
+		}
+		return c.open(key, sealed, v)
+	})
+}
+
+func (c *Cache) putMeta(key []byte, v interface{}) error {
+	sealed, err := c.seal(key, v)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeta).Put(key, sealed)
+	})
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// The cache key is kept in the system keyring when there is one: the Secret Service
+// through secret-tool on Linux and BSD, the login keychain through security on macOS.
+const (
+	keyringService = "gophkeeper"
+	keyringAccount = "cache-key"
+	keyringLabel   = "gophkeeper cache key"
+)
+
+var errNoKeyring = errors.New("system keyring is not available")
+
+// keyringGet reads the cache key from the system keyring.
+func keyringGet() ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "windows":
+		return nil, errNoKeyring
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	}
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return nil, errNoKeyring
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache key from system keyring: %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil || len(key) != keySize {
+		return nil, errors.New("cache key in system keyring is damaged")
+	}
+
+	return key, nil
+}
+
+// keyringSet stores the cache key into the system keyring and reads it back, so a keyring
+// which accepts but does not keep secrets is not relied on.
+func keyringSet(key []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "add-generic-password", "-U",
+			"-s", keyringService, "-a", keyringAccount, "-l", keyringLabel, "-w", encoded)
+	case "windows":
+		return errNoKeyring
+	default:
+		cmd = exec.Command("secret-tool", "store", "--label", keyringLabel,
+			"service", keyringService, "account", keyringAccount)
+		cmd.Stdin = strings.NewReader(encoded)
+	}
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return errNoKeyring
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error storing cache key in system keyring: %w: %s", err, bytes.TrimSpace(out))
+	}
+
+	stored, err := keyringGet()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stored, key) {
+		return errors.New("system keyring has not kept the cache key")
+	}
+
+	return nil
+}
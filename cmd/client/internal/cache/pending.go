@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// PendingOp is a change made while the server was unreachable, waiting to be sent.
+type PendingOp struct {
+	QueuedAt  time.Time             `json:"queued_at"`
+	Operation models.BatchOperation `json:"operation"`
+	Seq       uint64                `json:"seq"`
+}
+
+// Enqueue appends the operation to the pending queue and returns its sequence number.
+func (c *Cache) Enqueue(op models.BatchOperation) (uint64, error) {
+	var seq uint64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketPending)
+
+		var err error
+		if seq, err = b.NextSequence(); err != nil {
+			return err
+		}
+
+		key := itob(seq)
+		sealed, err := c.seal(key, PendingOp{Seq: seq, QueuedAt: time.Now(), Operation: op})
+		if err != nil {
+			return err
+		}
+
+		return b.Put(key, sealed)
+	})
+
+	return seq, err
+}
+
+// Pending returns queued operations in the order they were made.
+func (c *Cache) Pending() ([]PendingOp, error) {
+	ops := make([]PendingOp, 0)
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPending).ForEach(func(k, v []byte) error {
+			var op PendingOp
+			if err := c.open(k, v, &op); err != nil {
+				return err
+			}
+			ops = append(ops, op)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ops, nil
+}
+
+// Ack removes sent operations from the queue.
+func (c *Cache) Ack(seqs ...uint64) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketPending)
+		for _, seq := range seqs {
+			if err := b.Delete(itob(seq)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
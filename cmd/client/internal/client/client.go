@@ -2,25 +2,28 @@ package client
 
 import (
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
 
 	"github.com/rawen554/goph-keeper/internal/logger"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
-type httpClientInstance struct {
+type HTTPClient struct {
 	*http.Client
 	APIURL string
 }
 
 var (
-	httpClient *httpClientInstance
+	httpClient *HTTPClient
 	once       sync.Once
 )
 
-func GetHTTPClient() *httpClientInstance {
+func GetHTTPClient() *HTTPClient {
 	once.Do(
 		func() {
 			logger, err := logger.NewLogger()
@@ -34,10 +37,22 @@ func GetHTTPClient() *httpClientInstance {
 				httpClient = nil
 				return
 			}
-			httpClient = &httpClientInstance{
+			v, err := newVerifier(logger, apiURL)
+			if err != nil {
+				logger.Errorln(err)
+				httpClient = nil
+				return
+			}
+
+			httpClient = &HTTPClient{
 				Client: &http.Client{
 					Transport: &http.Transport{
-						TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+						TLSClientConfig: &tls.Config{
+							// the default verification cannot trust on first use, verifyConnection replaces it
+							InsecureSkipVerify:   true,
+							VerifyConnection:     v.verifyConnection,
+							GetClientCertificate: deviceCertificate,
+						},
 					}},
 				APIURL: apiURL,
 			}
@@ -45,3 +60,69 @@ func GetHTTPClient() *httpClientInstance {
 
 	return httpClient
 }
+
+// SessionClients makes clients of the API presenting a given device certificate. The settings
+// are read from the config once on creation and servers not known by then are not trusted,
+// so the clients do not use the config afterwards. The agent makes one for every session.
+type SessionClients struct {
+	verifier *verifier
+	apiURL   string
+}
+
+func NewSessionClients(logger *zap.SugaredLogger) (*SessionClients, error) {
+	apiURL := viper.GetString("api")
+	if apiURL == "" {
+		return nil, errors.New("empty API URL")
+	}
+	v, err := newVerifier(logger, apiURL)
+	if err != nil {
+		return nil, err
+	}
+	v.detached = true
+
+	return &SessionClients{verifier: v, apiURL: apiURL}, nil
+}
+
+// Client makes a client with its own connections. It presents no certificate when certPEM is empty.
+func (c *SessionClients) Client(certPEM, keyPEM string) (*HTTPClient, error) {
+	cert := &tls.Certificate{}
+	if certPEM != "" && keyPEM != "" {
+		pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("error loading device certificate: %w", err)
+		}
+		cert = &pair
+	}
+
+	return &HTTPClient{
+		Client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					// the default verification cannot trust on first use, verifyConnection replaces it
+					InsecureSkipVerify: true,
+					VerifyConnection:   c.verifier.verifyConnection,
+					GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+						return cert, nil
+					},
+				},
+			}},
+		APIURL: c.apiURL,
+	}, nil
+}
+
+// deviceCertificate presents the device certificate enrolled on login. It is read on every
+// handshake, as login changes it after the client is created.
+// Without the certificate the server gets no certificate, and only unbound tokens work.
+func deviceCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	certPEM, keyPEM := viper.GetString("device_cert"), viper.GetString("device_key")
+	if certPEM == "" || keyPEM == "" {
+		return &tls.Certificate{}, nil
+	}
+
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("error loading device certificate: %w", err)
+	}
+
+	return &cert, nil
+}
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rawen554/goph-keeper/internal/certpin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// ConfigFile is where the client saves its settings and session when no config has been read.
+	ConfigFile = "./gophkeeper.json"
+	ConfigPerm = 0600
+)
+
+var (
+	ErrFingerprintChanged = errors.New("server certificate fingerprint has changed")
+	ErrPinMismatch        = errors.New("server certificate matches no pin")
+	ErrUnknownServer      = errors.New("server is not trusted yet")
+)
+
+// ConfigPath is the config file which has been read, or ConfigFile when there is none yet.
+func ConfigPath() string {
+	if path := viper.ConfigFileUsed(); path != "" {
+		return path
+	}
+
+	return ConfigFile
+}
+
+// verifier checks the server certificate of the API host. Pins take precedence, then the chain
+// is verified by the custom CA bundle or the system roots. A server which is not verified
+// by the system roots is trusted on first use: its fingerprint is recorded in known_servers,
+// and later connections are refused when it changes. A detached verifier does not save
+// fingerprints and refuses servers not known on creation.
+type verifier struct {
+	logger   *zap.SugaredLogger
+	roots    *x509.CertPool
+	host     string
+	hostname string
+	pins     []string
+	known    []string
+	detached bool
+	mu       sync.Mutex
+}
+
+func newVerifier(logger *zap.SugaredLogger, apiURL string) (*verifier, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing API URL: %w", err)
+	}
+
+	v := &verifier{
+		logger:   logger,
+		host:     u.Host,
+		hostname: u.Hostname(),
+		pins:     viper.GetStringSlice("pins"),
+		known:    viper.GetStringSlice("known_servers"),
+	}
+
+	if path := viper.GetString("ca_cert"); path != "" {
+		bundle, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle: %w", err)
+		}
+
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s", path)
+		}
+	}
+
+	return v, nil
+}
+
+// verifyConnection is called instead of the default verification, which is turned off for TOFU.
+func (v *verifier) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	chain, chainErr := v.verifyChain(cs.PeerCertificates)
+	if len(v.pins) != 0 {
+		if v.roots != nil && chainErr != nil {
+			return chainErr
+		}
+
+		// Certificates of an unverified chain are whatever the peer has sent, only the leaf,
+		// whose key completes the handshake, is pinned then.
+		if chainErr != nil {
+			chain = cs.PeerCertificates[:1]
+		}
+		for _, cert := range chain {
+			for _, pin := range v.pins {
+				if certpin.Matches(cert, pin) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%w: %s presents %s", ErrPinMismatch, v.host, certpin.Of(cs.PeerCertificates[0]))
+	}
+
+	if chainErr == nil || v.roots != nil {
+		return chainErr
+	}
+
+	return v.trustOnFirstUse(cs.PeerCertificates[0])
+}
+
+// verifyChain returns the first chain from the leaf to a trusted root.
+func (v *verifier) verifyChain(certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		DNSName:       v.hostname,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error verifying server certificate: %w", err)
+	}
+
+	return chains[0], nil
+}
+
+func (v *verifier) trustOnFirstUse(leaf *x509.Certificate) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fingerprint := certpin.Of(leaf)
+	for _, entry := range v.known {
+		host, trusted, _ := strings.Cut(entry, " ")
+		if host != v.host {
+			continue
+		}
+		if trusted != fingerprint {
+			return fmt.Errorf("%w: %s presents %s, trusted %s; remove it from known_servers of the config to trust the new one",
+				ErrFingerprintChanged, v.host, fingerprint, trusted)
+		}
+		return nil
+	}
+
+	if v.detached {
+		return fmt.Errorf("%w: %s presents %s, connect to it without the agent first", ErrUnknownServer, v.host, fingerprint)
+	}
+
+	v.logger.Warnf("server %s is not verified by a CA, trusting it on first use, fingerprint %s", v.host, fingerprint)
+	v.known = append(v.known, v.host+" "+fingerprint)
+	viper.Set("known_servers", v.known)
+	if err := viper.WriteConfigAs(ConfigPath()); err != nil {
+		v.logger.Errorf("err saving trusted server: %v", err)
+	}
+
+	return nil
+}
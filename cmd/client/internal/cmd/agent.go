@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultAgentIdleTimeout = 15 * time.Minute
+	agentStartWait          = 5 * time.Second
+)
+
+var (
+	agentIdleTimeout time.Duration
+	agentForeground  bool
+)
+
+func init() {
+	startAgentCmd.Flags().DurationVar(&agentIdleTimeout, "idle-timeout", defaultAgentIdleTimeout,
+		"lock the agent after this time without requests")
+	startAgentCmd.Flags().BoolVar(&agentForeground, "foreground", false, "do not detach from the terminal")
+
+	agentCmd.AddCommand(startAgentCmd)
+	agentCmd.AddCommand(statusAgentCmd)
+	agentCmd.AddCommand(lockAgentCmd)
+	agentCmd.AddCommand(unlockAgentCmd)
+	agentCmd.AddCommand(stopAgentCmd)
+	rootCmd.AddCommand(agentCmd)
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent [sub]",
+	Short: "Manage the background agent keeping the session in memory",
+	Long: "The agent listens on a unix socket accessible by the current user only (agent_socket setting\n" +
+		"or $XDG_RUNTIME_DIR/gophkeeper-agent.sock) and passes API requests of other gclient commands to the server\n" +
+		"with the session it keeps in memory. While the agent runs, the config keeps no token.",
+}
+
+var startAgentCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the agent and move the session of the current config into it",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		if !agentForeground {
+			if err := startAgentProcess(ctx); err != nil {
+				logger.Errorf("error: %v", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		l, err := logic.ListenAgent(ctx)
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+
+		if err := logic.ServeAgent(ctx, logger.Named("agent"), l, agentIdleTimeout); err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// startAgentProcess runs gclient agent start --foreground detached from the terminal
+// and waits until it answers on the socket.
+func startAgentProcess(ctx context.Context) error {
+	if _, err := logic.GetAgentStatus(ctx); err == nil {
+		return logic.ErrAgentRunning
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error finding executable: %w", err)
+	}
+
+	child := exec.Command(self, append(os.Args[1:], "--foreground")...)
+	child.SysProcAttr = detachedProcAttr()
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("error starting agent: %w", err)
+	}
+	if err := child.Process.Release(); err != nil {
+		return fmt.Errorf("error starting agent: %w", err)
+	}
+
+	deadline := time.Now().Add(agentStartWait)
+	for time.Now().Before(deadline) {
+		if status, err := logic.GetAgentStatus(ctx); err == nil {
+			fmt.Printf("agent started, pid %d\n", status.PID)
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("agent did not start in %s", agentStartWait)
+}
+
+var statusAgentCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the agent runs and is unlocked",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		status, err := logic.GetAgentStatus(context.Background())
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+
+		if status.Locked {
+			fmt.Printf("agent %d is locked\n", status.PID)
+			return
+		}
+		fmt.Printf("agent %d is unlocked for %s until %s\n",
+			status.PID, status.Login, status.LockAt.Local().Format(time.DateTime))
+	},
+}
+
+var lockAgentCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Make the agent forget the session",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.LockAgent(context.Background()); err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var unlockAgentCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Move the session of the current config into the agent",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.UnlockAgent(context.Background()); err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var stopAgentCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the agent",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.StopAgent(context.Background()); err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package cmd
+
+import "syscall"
+
+const detachedProcess = 0x00000008
+
+// detachedProcAttr starts the agent without a console, so it survives the terminal.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess}
+}
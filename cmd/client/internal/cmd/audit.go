@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+const defaultAuditLimit = 50
+
+var (
+	auditFilter logic.AuditFilter
+	auditSince  string
+)
+
+func init() {
+	auditCmd.Flags().StringSliceVar(&auditFilter.Actions, "action", nil,
+		"only events of the actions: login, login.failed, record.read, records.list, record.write, "+
+			"record.delete, record.restore, trash.purge, password.reset")
+	auditCmd.Flags().StringVar(&auditSince, "since", "", "only events after the time, RFC3339 or a duration like 24h")
+	auditCmd.Flags().StringVar(&auditFilter.Target, "target", "", "only events of the record name")
+	auditCmd.Flags().IntVar(&auditFilter.Limit, "limit", defaultAuditLimit, "number of latest events to show, 0 for all")
+	rootCmd.AddCommand(auditCmd)
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show security events of the account: logins, record reads and changes",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if auditSince != "" {
+			if auditFilter.Since, err = parseSince(auditSince); err != nil {
+				logger.Errorf("error: %v", err)
+				return
+			}
+		}
+
+		events, err := logic.ListAuditEvents(context.Background(), auditFilter)
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		if len(events) == 0 {
+			logger.Infoln("no events found")
+			return
+		}
+
+		for _, e := range events {
+			action := string(e.Action)
+			if e.Count > 1 {
+				action = fmt.Sprintf("%s x%d", action, e.Count)
+			}
+			fmt.Printf("%6d  %s  %-18s %-30s %-15s %s\n",
+				e.Seq, e.CreatedAt.Local().Format(time.DateTime), action, e.Target, e.IP, e.UserAgent)
+		}
+	},
+}
+
+// parseSince reads a point in time or a duration back from now.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since must be RFC3339 time or duration: %w", err)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+const dockerCredentialUse = "docker-credential"
+
+func init() {
+	rootCmd.AddCommand(dockerCredentialCmd)
+}
+
+// ExecuteDockerCredential runs the docker credential helper with the process arguments,
+// so the docker-credential-gophkeeper binary shares login state with gclient.
+func ExecuteDockerCredential() error {
+	rootCmd.SetArgs(append([]string{dockerCredentialUse}, os.Args[1:]...))
+	return rootCmd.Execute()
+}
+
+var dockerCredentialCmd = &cobra.Command{
+	Use:       dockerCredentialUse + " get|store|erase|list",
+	Short:     "Docker credential helper backed by PASS records of the docker folder",
+	Hidden:    true,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"get", "store", "erase", "list"},
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.DockerCredentialHelper(context.Background(), logger, args[0], os.Stdin, os.Stdout); err != nil {
+			// Docker reads the error message from stdout.
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	folderCmd.AddCommand(listFoldersCmd)
+	folderCmd.AddCommand(createFolderCmd)
+	folderCmd.AddCommand(moveFolderCmd)
+	folderCmd.AddCommand(deleteFolderCmd)
+	rootCmd.AddCommand(folderCmd)
+}
+
+var folderCmd = &cobra.Command{
+	Use:              "folders [sub]",
+	Short:            "Manage folders of data records",
+	PersistentPreRun: replayPending,
+}
+
+var listFoldersCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List folders",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		folders, err := logic.ListFolders(context.Background())
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		sort.Slice(folders, func(i, j int) bool { return folders[i].Path < folders[j].Path })
+		for _, f := range folders {
+			fmt.Println(f.Path)
+		}
+	},
+}
+
+var createFolderCmd = &cobra.Command{
+	Use:   "create [path]",
+	Short: "Create folder with all missing parents, e.g. work/aws",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		folder, err := logic.CreateFolder(context.Background(), args[0])
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		logger.Infof("created folder: %s\n", folder.Path)
+	},
+}
+
+var moveFolderCmd = &cobra.Command{
+	Use:   "move [path] [new_path]",
+	Short: "Rename folder or move it under another folder",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.MoveFolder(context.Background(), args[0], args[1]); err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		logger.Infof("moved folder %s to %s\n", args[0], args[1])
+	},
+}
+
+var deleteFolderCmd = &cobra.Command{
+	Use:   "delete [path]",
+	Short: "Delete folder, its records and subfolders are moved to the parent folder",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.DeleteFolder(context.Background(), args[0]); err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		logger.Infof("deleted folder: %s\n", args[0])
+	},
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(gitCredentialCmd)
+}
+
+var gitCredentialCmd = &cobra.Command{
+	Use:   "git-credential get|store|erase",
+	Short: "Git credential helper backed by PASS records",
+	Long: "Configure git to use it with:\n" +
+		"  git config --global credential.helper '!gclient git-credential'\n" +
+		"Records are matched by the url metadata, set credential.useHttpPath to tell repositories apart.",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"get", "store", "erase"},
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.GitCredentialHelper(context.Background(), logger, args[0], os.Stdin, os.Stdout); err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+	},
+}
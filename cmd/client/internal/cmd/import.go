@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/importer"
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importFormat string
+	importDryRun bool
+)
+
+func init() {
+	formats := make([]string, 0, len(importer.Formats))
+	for _, f := range importer.Formats {
+		formats = append(formats, string(f))
+	}
+
+	importCmd.Flags().StringVarP(&importFormat, "format", "f", "", "export format: "+strings.Join(formats, "|"))
+	importCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "only print what would be imported")
+	_ = importCmd.MarkFlagRequired("format")
+	rootCmd.AddCommand(importCmd)
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import --format [format] [file]",
+	Short: "Import records from other password managers",
+	Long: "Supported formats: KeePass 2 XML (keepass), Bitwarden unencrypted JSON (bitwarden),\n" +
+		"browser (chrome), LastPass (lastpass), 1Password (1password) and generic (csv) CSV exports.",
+	Args:   cobra.ExactArgs(1),
+	PreRun: replayPending,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		res, err := logic.ParseImportFile(importer.Format(strings.ToLower(importFormat)), args[0])
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		for _, s := range res.Skipped {
+			logger.Warnf("skipped %q: %s\n", s.Name, s.Reason)
+		}
+
+		if importDryRun {
+			printImportPlan(res)
+			return
+		}
+
+		report, err := logic.ImportRecords(context.Background(), logger, res.Entries)
+		if err != nil {
+			logger.Errorf("error: %v", err)
+		}
+
+		for _, f := range report.Failed {
+			logger.Errorf("failed to import %q: %v\n", f.Name, f.Err)
+		}
+		logger.Infof("imported %d of %d records, skipped %d\n", len(report.Uploaded), len(res.Entries), len(res.Skipped))
+	},
+}
+
+func printImportPlan(res *importer.Result) {
+	byType := make(map[models.DataType]int)
+	for _, e := range res.Entries {
+		byType[e.Type]++
+		fmt.Printf("%-5s %-40s folder=%q url=%q\n", e.Type, e.Name, e.Metadata[models.MetaFolder], e.Metadata[models.MetaURL])
+	}
+
+	fmt.Printf("\ndry run: %d records would be imported (PASS: %d, TEXT: %d, CARD: %d, OTP: %d), %d skipped\n",
+		len(res.Entries), byType[models.PASS], byType[models.TEXT], byType[models.CARD], byType[models.OTP],
+		len(res.Skipped))
+}
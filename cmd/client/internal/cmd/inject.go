@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+var (
+	injectInput  string
+	injectOutput string
+)
+
+func init() {
+	injectCmd.Flags().StringVarP(&injectInput, "in", "i", "-", "template file, - for stdin")
+	injectCmd.Flags().StringVarP(&injectOutput, "out", "o", "-", "output file, - for stdout which must be a pipe or a file")
+	rootCmd.AddCommand(injectCmd)
+}
+
+var injectCmd = &cobra.Command{
+	Use:   "inject -i template -o output",
+	Short: "Render template with secrets from gophkeeper",
+	Long: "Placeholders {{ gk \"folder/record\" \"field\" }} are replaced with record fields,\n" +
+		"see gclient run --help for the list of fields. The output file is created with 0600\n" +
+		"permissions and is not written at all if any reference cannot be resolved. Without -o\n" +
+		"the output goes to stdout only if it is redirected to a pipe or a file.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if injectOutput == "-" && !isPipeOrFile(os.Stdout) {
+			logger.Errorf("error: stdout is not a pipe or a file, secrets are not printed to terminals, use -o file")
+			os.Exit(1)
+		}
+
+		var text []byte
+		if injectInput == "-" {
+			text, err = io.ReadAll(os.Stdin)
+		} else {
+			text, err = os.ReadFile(injectInput)
+		}
+		if err != nil {
+			logger.Errorf("error reading template: %v", err)
+			os.Exit(1)
+		}
+
+		out, err := logic.RenderTemplate(context.Background(), logic.NewSecretResolver(), injectInput, string(text))
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+
+		if injectOutput == "-" {
+			_, err = os.Stdout.Write(out)
+		} else {
+			err = logic.WriteSecretFile(injectOutput, out)
+		}
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// isPipeOrFile tells whether f is redirected to a pipe or a regular file rather than a terminal.
+func isPipeOrFile(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
@@ -5,12 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net"
+	"os"
 	"time"
 
+	"github.com/rawen554/goph-keeper/cmd/client/internal/client"
 	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
 	"github.com/rawen554/goph-keeper/internal/logger"
-	"github.com/rawen554/goph-keeper/internal/utils"
+	"github.com/rawen554/goph-keeper/internal/models"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -45,34 +46,51 @@ func Login(ctx context.Context, logger *zap.SugaredLogger) {
 			var password string
 			fmt.Scanln(&password)
 
-			creds, err := logic.Login(ctx, logger.Named("logic"), login, password)
+			creds, deviceKey, err := logic.Login(ctx, logger.Named("logic"), login, password)
 			if err != nil {
-				var target *net.OpError
-				if errors.As(err, &target) {
-					if err := utils.CreateUsersDir(login); err != nil {
-						logger.Errorf("err: %w", err)
-					}
-					logger.Infof("created local dir for user: %s\n", login)
-				}
-
+				logger.Errorf("error: %v", err)
 				return
 			}
 
-			viper.Set("login", login)
-			viper.Set("token", creds.Token)
-			viper.Set("expires_at", time.Now().Add(time.Duration(creds.ExpiresIn)*time.Second))
+			saveSession(ctx, logger, login, creds, deviceKey)
+			return
+		}
 
-			if err := viper.WriteConfigAs("./gophkeeper.json"); err != nil {
-				logger.Errorf("err saving config: %w", err)
-			}
+		viper.Set("token", "")
+	}
+}
 
-			if err := utils.CreateUsersDir(login); err != nil {
-				logger.Errorf("err: %w", err)
-			}
+// saveSession hands the new session to the running agent, which keeps it in memory only.
+// Without the agent the session is saved in the config.
+func saveSession(ctx context.Context, logger *zap.SugaredLogger, login string, creds *models.TokenResponse,
+	deviceKey string) {
+	viper.Set("login", login)
+	viper.Set("expires_at", time.Now().Add(time.Duration(creds.ExpiresIn)*time.Second))
 
-			return
+	err := logic.UnlockAgentSession(ctx, logic.AgentSession{
+		Login:      login,
+		Token:      creds.Token,
+		DeviceCert: creds.Certificate,
+		DeviceKey:  deviceKey,
+	})
+	if err == nil {
+		viper.Set("token", "")
+		viper.Set("device_cert", "")
+		viper.Set("device_key", "")
+	} else {
+		if !errors.Is(err, logic.ErrAgentNotRunning) {
+			logger.Errorf("err unlocking agent, the session is saved in the config: %v", err)
 		}
+		viper.Set("token", creds.Token)
+		viper.Set("device_cert", creds.Certificate)
+		viper.Set("device_key", deviceKey)
+	}
 
-		viper.Set("token", "")
+	if err := viper.WriteConfigAs(client.ConfigPath()); err != nil {
+		logger.Errorf("err saving config: %v", err)
+	}
+	// the config holds the device key since enrollment, older configs could be readable by others
+	if err := os.Chmod(client.ConfigPath(), client.ConfigPerm); err != nil {
+		logger.Errorf("err protecting config: %v", err)
 	}
 }
@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"log"
 
+	"github.com/rawen554/goph-keeper/cmd/client/internal/client"
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
 	"github.com/rawen554/goph-keeper/internal/logger"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -34,13 +37,25 @@ func Logout(ctx context.Context, logger *zap.SugaredLogger) {
 		return
 	}
 
+	if ops, err := logic.PendingOperations(); err == nil && len(ops) != 0 {
+		logger.Warnf("%d queued changes are not sent yet, the local cache is kept", len(ops))
+	} else if err := logic.ClearCache(); err != nil {
+		logger.Errorf("err clearing cache: %v", err)
+	}
+
 	viper.Set("login", "")
 	viper.Set("token", "")
 	viper.Set("expires_at", "")
+	viper.Set("device_cert", "")
+	viper.Set("device_key", "")
 
-	if err := viper.WriteConfigAs("./gophkeeper.json"); err != nil {
+	if err := viper.WriteConfigAs(client.ConfigPath()); err != nil {
 		logger.Errorf("err saving config: %w", err)
 	}
 
+	if err := logic.LockAgent(ctx); err != nil && !errors.Is(err, logic.ErrAgentNotRunning) {
+		logger.Errorf("err locking agent: %v", err)
+	}
+
 	logger.Infof("cleared session: %s\n", login)
 }
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/rawen554/goph-keeper/internal/otp"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(otpCmd)
+}
+
+var otpCmd = &cobra.Command{
+	Use:    "otp [folder/name]",
+	Short:  "Print current one-time password of OTP record",
+	Args:   cobra.ExactArgs(1),
+	PreRun: replayPending,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		code, err := logic.GenerateOTP(context.Background(), args[0])
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		if code.Kind == otp.HOTP {
+			fmt.Printf("%s (counter %d)\n", code.Code, code.Counter)
+			return
+		}
+
+		fmt.Printf("%s (%d seconds remaining)\n", code.Code, int(code.Remaining.Seconds()))
+	},
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func init() {
+	rootCmd.AddCommand(resetPasswordCmd)
+}
+
+var resetPasswordCmd = &cobra.Command{
+	Use:   "reset-password",
+	Short: "Set a new password with a reset code from the server operator",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ResetPassword(context.Background(), logger)
+	},
+}
+
+func ResetPassword(ctx context.Context, logger *zap.SugaredLogger) {
+	logger.Infoln("Login:")
+	var login string
+	fmt.Scanln(&login)
+
+	logger.Infoln("Reset code:")
+	var code string
+	fmt.Scanln(&code)
+
+	logger.Infoln("New password:")
+	var password string
+	fmt.Scanln(&password)
+
+	if err := logic.ResetPassword(ctx, logger.Named("logic"), login, code, password); err != nil {
+		logger.Errorf("error: %v", err)
+		return
+	}
+
+	logger.Infoln("Password is changed, login with the new one")
+}
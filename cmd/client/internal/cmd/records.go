@@ -3,33 +3,49 @@ package cmd
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
-	"syscall"
 
 	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
 	"github.com/rawen554/goph-keeper/internal/logger"
 	"github.com/spf13/cobra"
 )
 
+var (
+	listFilter logic.RecordsFilter
+	syncFull   bool
+)
+
 func init() {
+	listRecordsCmd.Flags().StringVar(&listFilter.Type, "type", "", "only records of type PASS|TEXT|BIN|CARD")
+	listRecordsCmd.Flags().StringVar(&listFilter.Tag, "tag", "", "only records with the tag")
+	listRecordsCmd.Flags().StringVar(&listFilter.Name, "name", "", "only records with names starting with the prefix")
+	listRecordsCmd.Flags().StringVar(&listFilter.Folder, "folder", "", "only records in the folder, e.g. work/aws")
+	syncRecordsCmd.Flags().BoolVar(&syncFull, "full", false, "replace the local cache instead of fetching changes")
+
 	putRecordCmd.AddCommand()
 	recordCmd.AddCommand(putRecordCmd)
 	recordCmd.AddCommand(getRecordCmd)
 	recordCmd.AddCommand(listRecordsCmd)
 	recordCmd.AddCommand(syncRecordsCmd)
+	recordCmd.AddCommand(searchRecordsCmd)
+	recordCmd.AddCommand(moveRecordCmd)
 	rootCmd.AddCommand(recordCmd)
 }
 
 var recordCmd = &cobra.Command{
-	Use:   "records [sub]",
-	Short: "Manage data records",
+	Use:              "records [sub]",
+	Short:            "Manage data records",
+	PersistentPreRun: replayPending,
 }
 
 var putRecordCmd = &cobra.Command{
-	Use:   "put [record_type] [path|data] [name]",
+	Use:   "put [record_type] [path|data] [folder/name]",
 	Short: "Put data record",
-	Long:  "record_type=PASS|TEXT|BIN|CARD\nFor PASS data type required following pattern %LOGIN%:%PASSWORD%\nName is required.",
-	Args:  cobra.MinimumNArgs(1),
+	Long: "record_type=PASS|TEXT|BIN|CARD|OTP|SSHKEY\nFor PASS data type required following pattern %LOGIN%:%PASSWORD%\n" +
+		"For OTP data type pass an otpauth:// URI or a base32 TOTP secret\n" +
+		"For SSHKEY data type pass a path to an unencrypted private key\nName is required.",
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		logger, err := logger.NewLogger()
 		if err != nil {
@@ -38,15 +54,13 @@ var putRecordCmd = &cobra.Command{
 
 		record, err := logic.PutRecord(context.Background(), args)
 		if err != nil {
-			if errors.Is(err, syscall.ECONNREFUSED) {
-				if err := logic.SaveOrUpdateData(logger, record); err != nil {
-					logger.Errorf("error saving locally %s: [%w]\n", record.Name, err)
-				}
-				logger.Infof("saved local data: %s\n", record.Name)
+			if errors.Is(err, logic.ErrQueued) {
+				logger.Infof("server is unreachable, upload of %s is queued\n", record.Name)
 				return
 			}
 
 			logger.Errorf("error: %v", err)
+			return
 		}
 
 		if err := logic.SaveOrUpdateData(logger, record); err != nil {
@@ -58,7 +72,7 @@ var putRecordCmd = &cobra.Command{
 }
 
 var getRecordCmd = &cobra.Command{
-	Use:   "get [name]",
+	Use:   "get [folder/name]",
 	Short: "Get data record",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -70,6 +84,11 @@ var getRecordCmd = &cobra.Command{
 		record, err := logic.GetRecord(context.Background(), args[0])
 		if err != nil {
 			logger.Errorf("error: %v", err)
+			return
+		}
+
+		if err := logic.SaveOrUpdateData(logger, record); err != nil {
+			logger.Errorf("error saving locally: %s\n", record.Name)
 		}
 
 		logger.Infof("%+v\n", record)
@@ -85,7 +104,7 @@ var listRecordsCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		records, err := logic.ListRecords(context.Background(), logger)
+		records, err := logic.ListRecords(context.Background(), logger, listFilter)
 		if err != nil {
 			logger.Errorf("error: %v", err)
 		}
@@ -105,10 +124,56 @@ var syncRecordsCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		if err := logic.SyncDataRecords(context.Background(), logger); err != nil {
+		if err := logic.SyncDataRecords(context.Background(), logger, syncFull); err != nil {
 			logger.Errorf("error: %v", err)
 		}
 
 		logger.Infoln("sync successfull")
 	},
 }
+
+var searchRecordsCmd = &cobra.Command{
+	Use:   "search [query]",
+	Short: "Search data records by name, URL, tags and notes",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		hits, err := logic.SearchRecords(context.Background(), logger, args[0])
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		if len(hits) == 0 {
+			logger.Infoln("no records found")
+			return
+		}
+
+		for _, h := range hits {
+			fmt.Printf("%-5s %-40s matched %s\n", h.Record.Type, h.Record.Name, h.Field)
+		}
+	},
+}
+
+var moveRecordCmd = &cobra.Command{
+	Use:   "move [folder/name] [folder]",
+	Short: "Move data record into another folder, use / for the root folder",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.MoveRecord(context.Background(), args[0], args[1]); err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		logger.Infof("moved %s to %s\n", args[0], args[1])
+	},
+}
@@ -2,17 +2,12 @@ package cmd
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
-	"net"
-	"time"
 
 	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
 	"github.com/rawen554/goph-keeper/internal/logger"
-	"github.com/rawen554/goph-keeper/internal/utils"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
@@ -42,28 +37,11 @@ func Register(ctx context.Context, logger *zap.SugaredLogger) {
 	var password string
 	fmt.Scanln(&password)
 
-	creds, err := logic.Register(logger, login, password)
+	creds, deviceKey, err := logic.Register(logger, login, password)
 	if err != nil {
-		var target *net.OpError
-		if errors.As(err, &target) {
-			if err := utils.CreateUsersDir(login); err != nil {
-				logger.Errorf("err: %w", err)
-			}
-			logger.Infof("created local dir for user: %s\n", login)
-		}
-
+		logger.Errorf("error: %v", err)
 		return
 	}
 
-	viper.Set("login", login)
-	viper.Set("token", creds.Token)
-	viper.Set("expires_at", time.Now().Add(time.Duration(creds.ExpiresIn)*time.Second))
-
-	if err := utils.CreateUsersDir(login); err != nil {
-		logger.Errorf("err: %w", err)
-	}
-
-	if err := viper.WriteConfigAs("./gophkeeper.json"); err != nil {
-		logger.Errorf("err saving config: %w", err)
-	}
+	saveSession(ctx, logger, login, creds, deviceKey)
 }
@@ -1,14 +1,20 @@
 package cmd
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 
+	"github.com/rawen554/goph-keeper/cmd/client/internal/client"
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
 	"github.com/rawen554/goph-keeper/internal/logger"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+const replayTimeout = 10 * time.Second
+
 var (
 	// Used for flags.
 	cfgFile string
@@ -33,9 +39,13 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.cobra.yaml)")
 	rootCmd.PersistentFlags().StringVar(&apiURL, "api", "https://localhost:8080", "API URL")
+	rootCmd.PersistentFlags().String("ca-cert", "", "CA bundle verifying the server certificate instead of the system roots")
+	rootCmd.PersistentFlags().StringSlice("pin", nil, "accepted server public key pins, sha256/<base64>")
 	rootCmd.PersistentFlags().StringP("login", "l", "", "author name for copyright attribution")
 	rootCmd.PersistentFlags().StringP("token", "t", "", "author name for copyright attribution")
 	viper.BindPFlag("api", rootCmd.PersistentFlags().Lookup("api"))
+	viper.BindPFlag("ca_cert", rootCmd.PersistentFlags().Lookup("ca-cert"))
+	viper.BindPFlag("pins", rootCmd.PersistentFlags().Lookup("pin"))
 	viper.BindPFlag("login", rootCmd.PersistentFlags().Lookup("login"))
 	viper.BindPFlag("token", rootCmd.PersistentFlags().Lookup("token"))
 	viper.BindPFlag("expires_at", rootCmd.PersistentFlags().Lookup("expires_at"))
@@ -60,8 +70,34 @@ func initConfig() {
 	}
 
 	viper.AutomaticEnv()
+	viper.SetConfigPermissions(client.ConfigPerm)
 
 	if err := viper.ReadInConfig(); err == nil {
 		logger.Info("Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+// replayPending sends changes queued while the server was unreachable before commands working
+// with records on the server, so they see the changes in order. Credential helpers, agents and
+// watch do not run it.
+func replayPending(cmd *cobra.Command, args []string) {
+	if viper.GetString("login") == "" {
+		return
+	}
+
+	logger, err := logger.NewLogger()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), replayTimeout)
+	defer cancel()
+
+	sent, err := logic.ReplayPending(ctx, logger)
+	if sent != 0 {
+		logger.Infof("sent %d queued changes", sent)
+	}
+	if err != nil {
+		logger.Debugf("queued changes are not sent: %v", err)
+	}
+}
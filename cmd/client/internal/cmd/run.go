@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/cmd/client/internal/mask"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	runEnvFile string
+	runEnv     []string
+	runNoMask  bool
+)
+
+func init() {
+	runCmd.Flags().StringVar(&runEnvFile, "env-file", "", "file with NAME=gk://folder/record/field mappings")
+	runCmd.Flags().StringArrayVarP(&runEnv, "env", "e", nil, "NAME=gk://folder/record/field mapping, can be repeated")
+	runCmd.Flags().BoolVar(&runNoMask, "no-mask", false, "do not conceal secrets in the command output")
+	runCmd.Flags().SetInterspersed(false)
+	rootCmd.AddCommand(runCmd)
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run [--env-file file] [--env NAME=ref]... -- command [args...]",
+	Short: "Run command with secrets from gophkeeper in its environment",
+	Long: "References have the gk://folder/record/field form. Available fields:\n" +
+		"  PASS: login, password\n" +
+		"  CARD: number, expiry, cvv, holder\n" +
+		"  TEXT: text\n" +
+		"  OTP: code, secret, uri\n" +
+		"  SSHKEY: private_key, public_key, comment\n" +
+		"  any record: url, notes, tags\n" +
+		"Resolved passwords, card numbers and cvv, texts, OTP and private keys of at least\n" +
+		"4 characters are concealed in the command stdout and stderr.\n" +
+		"gclient exits with the exit code of the command.",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var vars []logic.EnvVar
+		if runEnvFile != "" {
+			if vars, err = logic.ParseEnvFile(runEnvFile); err != nil {
+				logger.Errorf("error: %v", err)
+				os.Exit(1)
+			}
+		}
+		for _, e := range runEnv {
+			v, err := logic.ParseEnvVar(e)
+			if err != nil {
+				logger.Errorf("error: %v", err)
+				os.Exit(1)
+			}
+			vars = append(vars, v)
+		}
+
+		resolver := logic.NewSecretResolver()
+		env, err := logic.ResolveEnv(context.Background(), resolver, vars)
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			os.Exit(1)
+		}
+
+		os.Exit(runChild(logger, args, env, resolver.Secrets()))
+	},
+}
+
+// runChild starts the command, forwards interrupts to it and returns its exit code.
+func runChild(logger *zap.SugaredLogger, args []string, env []string, secrets []string) int {
+	child := exec.Command(args[0], args[1:]...)
+	child.Env = append(os.Environ(), env...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	if !runNoMask && len(secrets) > 0 {
+		stdout := mask.NewWriter(os.Stdout, secrets)
+		stderr := mask.NewWriter(os.Stderr, secrets)
+		defer stdout.Close()
+		defer stderr.Close()
+		child.Stdout = stdout
+		child.Stderr = stderr
+	}
+
+	if err := child.Start(); err != nil {
+		logger.Errorf("error: %v", err)
+		return 127
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			_ = child.Process.Signal(sig)
+		}
+	}()
+
+	err := child.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		// shells report a child killed by a signal as 128 plus the signal number
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return 1
+	}
+	if err != nil {
+		logger.Errorf("error: %v", err)
+		return 1
+	}
+
+	return 0
+}
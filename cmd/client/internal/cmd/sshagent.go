@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/cmd/client/internal/sshagent"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+const sshKeysTTL = time.Minute
+
+var (
+	sshAgentSocket  string
+	sshAgentConfirm bool
+)
+
+func init() {
+	sshAgentCmd.Flags().StringVar(&sshAgentSocket, "socket", "", "unix socket path (default is a new private temp dir)")
+	sshAgentCmd.Flags().BoolVar(&sshAgentConfirm, "confirm", false, "ask for confirmation before every key use")
+	rootCmd.AddCommand(sshAgentCmd)
+}
+
+var sshAgentCmd = &cobra.Command{
+	Use:   "ssh-agent",
+	Short: "Serve SSHKEY records over the ssh-agent protocol",
+	Long: "Keys are loaded from gophkeeper into memory and are never written to disk.\n" +
+		"Point SSH_AUTH_SOCK to the printed socket to use them.",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+
+		socket := sshAgentSocket
+		if socket == "" {
+			dir, err := os.MkdirTemp("", "gophkeeper-agent-")
+			if err != nil {
+				logger.Errorf("error: %v", err)
+				return
+			}
+			defer os.RemoveAll(dir)
+			socket = filepath.Join(dir, "agent.sock")
+		} else if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("error removing stale socket: %v", err)
+			return
+		}
+
+		l, err := sshagent.Listen(socket)
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+		defer os.Remove(socket)
+
+		var confirm sshagent.Confirm
+		if sshAgentConfirm {
+			confirm = confirmOnTerminal()
+		}
+
+		agent := sshagent.New(logic.NewSSHKeySource(logger.Named("ssh-agent"), sshKeysTTL), confirm)
+
+		fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", socket)
+		if err := agent.Serve(ctx, l); err != nil {
+			logger.Errorf("error: %v", err)
+		}
+	},
+}
+
+// confirmOnTerminal asks on stdin before each key use, one question at a time.
+func confirmOnTerminal() sshagent.Confirm {
+	mu := sync.Mutex{}
+	in := bufio.NewReader(os.Stdin)
+
+	return func(key sshagent.Key) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fmt.Printf("Allow use of key %s (%s)? [y/N]: ", key.Name, key.Comment)
+		answer, err := in.ReadString('\n')
+		if err != nil {
+			return false
+		}
+
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show session, agent and changes waiting to be sent to the server",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		login := viper.GetString("login")
+		if login == "" {
+			fmt.Println("not logged in")
+			return
+		}
+		fmt.Printf("logged in as %s at %s\n", login, viper.GetString("api"))
+
+		if status, err := logic.GetAgentStatus(context.Background()); err != nil {
+			fmt.Println("agent is not running")
+		} else if status.Locked {
+			fmt.Printf("agent %d is locked\n", status.PID)
+		} else {
+			fmt.Printf("agent %d is unlocked\n", status.PID)
+		}
+
+		ops, err := logic.PendingOperations()
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+		if len(ops) == 0 {
+			fmt.Println("no pending changes")
+			return
+		}
+
+		folders, _ := logic.ListFolders(context.Background())
+		fmt.Printf("%d pending changes:\n", len(ops))
+		for _, op := range ops {
+			name, folderID := op.Operation.Name, op.Operation.FolderID
+			if op.Operation.Record != nil {
+				name, folderID = op.Operation.Record.Name, op.Operation.Record.FolderID
+			}
+			if path := logic.FolderPath(folders, folderID); path != "" {
+				name = path + models.PathSeparator + name
+			}
+
+			fmt.Printf("%4d  %s  %-6s %s\n", op.Seq, op.QueuedAt.Local().Format(time.DateTime), op.Operation.Op, name)
+		}
+	},
+}
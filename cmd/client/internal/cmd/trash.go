@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/logic"
+	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	trashCmd.AddCommand(listTrashCmd)
+	trashCmd.AddCommand(restoreRecordCmd)
+	trashCmd.AddCommand(purgeTrashCmd)
+	recordCmd.AddCommand(deleteRecordCmd)
+	recordCmd.AddCommand(trashCmd)
+}
+
+var deleteRecordCmd = &cobra.Command{
+	Use:   "delete [folder/name]",
+	Short: "Move data record into trash",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.DeleteRecord(context.Background(), args[0]); err != nil {
+			if errors.Is(err, logic.ErrQueued) {
+				logger.Infof("server is unreachable, deletion of %s is queued\n", args[0])
+				return
+			}
+
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		logger.Infof("moved to trash: %s\n", args[0])
+	},
+}
+
+var trashCmd = &cobra.Command{
+	Use:   "trash [sub]",
+	Short: "Manage deleted data records",
+}
+
+var listTrashCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List deleted data records",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		records, err := logic.ListTrash(context.Background())
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		if len(records) == 0 {
+			logger.Infoln("trash is empty")
+			return
+		}
+
+		for _, r := range records {
+			fmt.Printf("%-5s %-40s deleted at %s\n", r.Type, r.Name, r.DeletedAt.Time.Format("2006-01-02 15:04:05"))
+		}
+	},
+}
+
+var restoreRecordCmd = &cobra.Command{
+	Use:   "restore [folder/name]",
+	Short: "Restore data record from trash",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		record, err := logic.RestoreRecord(context.Background(), args[0])
+		if err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		if err := logic.SaveOrUpdateData(logger, record); err != nil {
+			logger.Errorf("error saving locally: %s\n", record.Name)
+		}
+
+		logger.Infof("restored: %s\n", record.Name)
+	},
+}
+
+var purgeTrashCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently remove all records from trash",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := logger.NewLogger()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := logic.PurgeTrash(context.Background()); err != nil {
+			logger.Errorf("error: %v", err)
+			return
+		}
+
+		logger.Infoln("trash purged")
+	},
+}
@@ -0,0 +1,117 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+const (
+	bitwardenLogin = iota + 1
+	bitwardenNote
+	bitwardenCard
+	bitwardenIdentity
+)
+
+var ErrEncryptedExport = errors.New("encrypted exports are not supported, export unencrypted json")
+
+type bitwardenExport struct {
+	Folders []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []struct {
+		FolderID *string `json:"folderId"`
+		Login    *struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			TOTP     string `json:"totp"`
+			URIs     []struct {
+				URI string `json:"uri"`
+			} `json:"uris"`
+		} `json:"login"`
+		Card *struct {
+			CardholderName string `json:"cardholderName"`
+			Number         string `json:"number"`
+			ExpMonth       string `json:"expMonth"`
+			ExpYear        string `json:"expYear"`
+			Code           string `json:"code"`
+		} `json:"card"`
+		Name  string `json:"name"`
+		Notes string `json:"notes"`
+		Type  int    `json:"type"`
+	} `json:"items"`
+	Encrypted bool `json:"encrypted"`
+}
+
+// parseBitwarden reads an unencrypted Bitwarden json export.
+func parseBitwarden(r io.Reader) (*Result, error) {
+	var export bitwardenExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("error decoding bitwarden json: %w", err)
+	}
+
+	if export.Encrypted {
+		return nil, ErrEncryptedExport
+	}
+
+	folders := make(map[string]string, len(export.Folders))
+	for _, f := range export.Folders {
+		folders[f.ID] = f.Name
+	}
+
+	res := &Result{}
+	for _, item := range export.Items {
+		meta := models.Metadata{models.MetaNotes: item.Notes}
+		if item.FolderID != nil {
+			meta[models.MetaFolder] = folders[*item.FolderID]
+		}
+
+		switch item.Type {
+		case bitwardenLogin:
+			if item.Login == nil {
+				res.skip(item.Name, "login item without login data")
+				continue
+			}
+			if len(item.Login.URIs) > 0 {
+				meta[models.MetaURL] = item.Login.URIs[0].URI
+			}
+			res.add(Entry{
+				Type:     models.PASS,
+				Name:     item.Name,
+				Data:     PassData(item.Login.Username, item.Login.Password),
+				Metadata: meta,
+			})
+			if item.Login.TOTP != "" {
+				res.addOTP(item.Name, item.Login.TOTP, meta)
+			}
+		case bitwardenNote:
+			delete(meta, models.MetaNotes)
+			res.add(notesEntry(item.Name, item.Notes, meta))
+		case bitwardenCard:
+			if item.Card == nil {
+				res.skip(item.Name, "card item without card data")
+				continue
+			}
+			expiry := ""
+			if item.Card.ExpMonth != "" || item.Card.ExpYear != "" {
+				expiry = fmt.Sprintf("%s/%s", item.Card.ExpMonth, item.Card.ExpYear)
+			}
+			res.add(Entry{
+				Type:     models.CARD,
+				Name:     item.Name,
+				Data:     CardData(item.Card.Number, expiry, item.Card.Code, item.Card.CardholderName),
+				Metadata: meta,
+			})
+		case bitwardenIdentity:
+			res.skip(item.Name, "identities are not supported")
+		default:
+			res.skip(item.Name, fmt.Sprintf("unknown item type %d", item.Type))
+		}
+	}
+
+	return res, nil
+}
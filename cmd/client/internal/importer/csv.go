@@ -0,0 +1,139 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+const (
+	colName     = "name"
+	colURL      = "url"
+	colUsername = "username"
+	colPassword = "password"
+	colNotes    = "notes"
+	colFolder   = "folder"
+	colTags     = "tags"
+	colOTP      = "otp"
+
+	// lastPassSecureNoteURL marks secure notes in LastPass exports.
+	lastPassSecureNoteURL = "http://sn"
+)
+
+// csvAliases maps lowercased header names used by browsers, LastPass and 1Password to columns.
+var csvAliases = map[string]string{
+	"name":           colName,
+	"title":          colName,
+	"url":            colURL,
+	"website":        colURL,
+	"login_uri":      colURL,
+	"username":       colUsername,
+	"login":          colUsername,
+	"login_username": colUsername,
+	"password":       colPassword,
+	"login_password": colPassword,
+	"note":           colNotes,
+	"notes":          colNotes,
+	"extra":          colNotes,
+	"grouping":       colFolder,
+	"folder":         colFolder,
+	"group":          colFolder,
+	"tags":           colTags,
+	"totp":           colOTP,
+	"otpauth":        colOTP,
+}
+
+var ErrNoCSVHeader = errors.New("csv has no recognizable header")
+
+// parseCSV reads Chrome/Firefox, LastPass, 1Password and similar csv exports with a header row.
+func parseCSV(format Format, r io.Reader) (*Result, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if col, ok := csvAliases[h]; ok {
+			if _, dup := columns[col]; !dup {
+				columns[col] = i
+			}
+		}
+	}
+	if _, ok := columns[colPassword]; !ok {
+		if _, ok := columns[colNotes]; !ok {
+			return nil, ErrNoCSVHeader
+		}
+	}
+
+	res := &Result{}
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading csv line %d: %w", line, err)
+		}
+
+		get := func(col string) string {
+			i, ok := columns[col]
+			if !ok || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+
+		name := get(colName)
+		if name == "" {
+			name = hostOf(get(colURL))
+		}
+		meta := models.Metadata{
+			models.MetaURL:    get(colURL),
+			models.MetaNotes:  get(colNotes),
+			models.MetaFolder: get(colFolder),
+			models.MetaTags:   get(colTags),
+		}
+		login := get(colUsername)
+		password := get(colPassword)
+
+		secureNote := (format == LastPassCSV || format == GenericCSV) && meta[models.MetaURL] == lastPassSecureNoteURL
+		switch {
+		case secureNote:
+			notes := meta[models.MetaNotes]
+			delete(meta, models.MetaNotes)
+			delete(meta, models.MetaURL)
+			res.add(notesEntry(name, notes, meta))
+		case login != "" || password != "":
+			res.add(Entry{Type: models.PASS, Name: name, Data: PassData(login, password), Metadata: meta})
+			if secret := get(colOTP); secret != "" {
+				res.addOTP(name, secret, meta)
+			}
+		case meta[models.MetaNotes] != "":
+			notes := meta[models.MetaNotes]
+			delete(meta, models.MetaNotes)
+			res.add(notesEntry(name, notes, meta))
+		default:
+			res.skip(name, fmt.Sprintf("line %d has neither credentials nor notes", line))
+		}
+	}
+
+	return res, nil
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
@@ -0,0 +1,155 @@
+// Package importer converts exports of other password managers into gophkeeper records.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"github.com/rawen554/goph-keeper/internal/otp"
+)
+
+type Format string
+
+const (
+	KeePassXML  Format = "keepass"
+	Bitwarden   Format = "bitwarden"
+	GenericCSV  Format = "csv"
+	ChromeCSV   Format = "chrome"
+	LastPassCSV Format = "lastpass"
+	OnePassCSV  Format = "1password"
+)
+
+// Formats lists every supported import format in the order shown in help messages.
+var Formats = []Format{KeePassXML, Bitwarden, GenericCSV, ChromeCSV, LastPassCSV, OnePassCSV}
+
+// Entry is a single record ready to be uploaded.
+type Entry struct {
+	Metadata models.Metadata
+	Type     models.DataType
+	Name     string
+	Data     string
+}
+
+// Skipped describes a source entry which could not be mapped to a record.
+type Skipped struct {
+	Name   string
+	Reason string
+}
+
+// Result is the outcome of parsing an export file.
+type Result struct {
+	Entries []Entry
+	Skipped []Skipped
+}
+
+func (r *Result) add(e Entry) {
+	if e.Metadata == nil {
+		e.Metadata = models.Metadata{}
+	}
+	for k, v := range e.Metadata {
+		if strings.TrimSpace(v) == "" {
+			delete(e.Metadata, k)
+		}
+	}
+	r.Entries = append(r.Entries, e)
+}
+
+func (r *Result) skip(name string, reason string) {
+	r.Skipped = append(r.Skipped, Skipped{Name: name, Reason: reason})
+}
+
+// Parse reads an export in the given format.
+// Record names are made unique, because the server addresses records by name.
+func Parse(format Format, r io.Reader) (*Result, error) {
+	var (
+		res *Result
+		err error
+	)
+
+	switch format {
+	case KeePassXML:
+		res, err = parseKeePass(r)
+	case Bitwarden:
+		res, err = parseBitwarden(r)
+	case GenericCSV, ChromeCSV, LastPassCSV, OnePassCSV:
+		res, err = parseCSV(format, r)
+	default:
+		return nil, fmt.Errorf("unsupported import format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	uniqueNames(res.Entries)
+
+	return res, nil
+}
+
+// PassData builds the data of a PASS record in the %LOGIN%:%PASSWORD% form.
+func PassData(login string, password string) string {
+	return fmt.Sprintf("%s:%s", login, password)
+}
+
+// CardData builds the data of a CARD record in the %NUMBER%:%EXPIRY%:%CVV%:%HOLDER% form.
+func CardData(number string, expiry string, cvv string, holder string) string {
+	return strings.Join([]string{strings.ReplaceAll(number, " ", ""), expiry, cvv, holder}, ":")
+}
+
+// uniqueNames numbers repeated names like "name (2)", skipping numbered names taken by other entries.
+func uniqueNames(entries []Entry) {
+	used := make(map[string]bool, len(entries))
+	last := make(map[string]int, len(entries))
+	for i := range entries {
+		name := strings.TrimSpace(entries[i].Name)
+		if name == "" {
+			name = string(entries[i].Type)
+		}
+		name = strings.ReplaceAll(name, "/", "-")
+
+		key := strings.ToLower(name)
+		if used[key] {
+			n := last[key]
+			if n == 0 {
+				n = 1
+			}
+			candidate := name
+			for used[strings.ToLower(candidate)] {
+				n++
+				candidate = fmt.Sprintf("%s (%d)", name, n)
+			}
+			last[key] = n
+			name = candidate
+		}
+		used[strings.ToLower(name)] = true
+
+		entries[i].Name = name
+	}
+}
+
+// addOTP adds an OTP record for a login item which carries a TOTP secret or otpauth:// URI.
+func (r *Result) addOTP(name string, raw string, meta models.Metadata) {
+	key, err := otp.ParseKey(raw)
+	if err != nil {
+		r.skip(name+" OTP", err.Error())
+		return
+	}
+
+	otpMeta := make(models.Metadata, len(meta))
+	for k, v := range meta {
+		otpMeta[k] = v
+	}
+	delete(otpMeta, models.MetaNotes)
+
+	r.add(Entry{Type: models.OTP, Name: name + " OTP", Data: key.URI(), Metadata: otpMeta})
+}
+
+func notesEntry(name string, notes string, meta models.Metadata) Entry {
+	return Entry{
+		Type:     models.TEXT,
+		Name:     name,
+		Data:     notes,
+		Metadata: meta,
+	}
+}
@@ -0,0 +1,218 @@
+package importer
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+const testOTPURI = "otpauth://totp/?algorithm=SHA1&digits=6&period=30&secret=JBSWY3DPEHPK3PXP"
+
+func parseFile(t *testing.T, format Format, file string) *Result {
+	t.Helper()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("open %s: %v", file, err)
+	}
+	defer f.Close()
+
+	res, err := Parse(format, f)
+	if err != nil {
+		t.Fatalf("Parse %s: %v", file, err)
+	}
+	return res
+}
+
+func checkResult(t *testing.T, got *Result, entries []Entry, skipped []string) {
+	t.Helper()
+
+	if !reflect.DeepEqual(got.Entries, entries) {
+		t.Errorf("entries:\n got %+v\nwant %+v", got.Entries, entries)
+	}
+
+	var names []string
+	for _, s := range got.Skipped {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, skipped) {
+		t.Errorf("skipped: got %v, want %v", names, skipped)
+	}
+}
+
+func TestParseKeePass(t *testing.T) {
+	res := parseFile(t, KeePassXML, "testdata/keepass.xml")
+
+	checkResult(t, res, []Entry{
+		{
+			Type:     models.PASS,
+			Name:     "Router",
+			Data:     "admin:s3cret",
+			Metadata: models.Metadata{models.MetaURL: "http://192.168.0.1"},
+		},
+		{
+			Type: models.PASS,
+			Name: "Mail",
+			Data: "alice@example.com:pa:ss",
+			Metadata: models.Metadata{
+				models.MetaURL:    "https://mail.example.com",
+				models.MetaNotes:  "work account",
+				models.MetaFolder: "Web",
+			},
+		},
+		{
+			Type:     models.TEXT,
+			Name:     "Gift codes",
+			Data:     "XXXX-YYYY",
+			Metadata: models.Metadata{models.MetaFolder: "Web/Shops"},
+		},
+	}, []string{"Empty"})
+}
+
+func TestParseBitwarden(t *testing.T) {
+	res := parseFile(t, Bitwarden, "testdata/bitwarden.json")
+
+	checkResult(t, res, []Entry{
+		{
+			Type: models.PASS,
+			Name: "Bank",
+			Data: "alice:hunter2",
+			Metadata: models.Metadata{
+				models.MetaURL:    "https://bank.example.com",
+				models.MetaNotes:  "branch 12",
+				models.MetaFolder: "Finance",
+			},
+		},
+		{
+			Type: models.OTP,
+			Name: "Bank OTP",
+			Data: testOTPURI,
+			Metadata: models.Metadata{
+				models.MetaURL:    "https://bank.example.com",
+				models.MetaFolder: "Finance",
+			},
+		},
+		{
+			Type:     models.TEXT,
+			Name:     "Wifi",
+			Data:     "guest network",
+			Metadata: models.Metadata{},
+		},
+		{
+			Type:     models.CARD,
+			Name:     "Visa",
+			Data:     "4111111111111111:12/2030:123:Alice Smith",
+			Metadata: models.Metadata{models.MetaFolder: "Finance"},
+		},
+	}, []string{"Passport", "Broken"})
+}
+
+func TestParseBitwardenEncrypted(t *testing.T) {
+	_, err := Parse(Bitwarden, strings.NewReader(`{"encrypted": true, "items": []}`))
+	if !errors.Is(err, ErrEncryptedExport) {
+		t.Errorf("Parse: ErrEncryptedExport is expected, got %v", err)
+	}
+}
+
+func TestParseChromeCSV(t *testing.T) {
+	res := parseFile(t, ChromeCSV, "testdata/chrome.csv")
+
+	checkResult(t, res, []Entry{
+		{
+			Type:     models.PASS,
+			Name:     "Example",
+			Data:     "bob:qwerty",
+			Metadata: models.Metadata{models.MetaURL: "https://example.com/login"},
+		},
+		{
+			Type:     models.PASS,
+			Name:     "shop.example.com",
+			Data:     "bob:12345",
+			Metadata: models.Metadata{models.MetaURL: "https://shop.example.com/"},
+		},
+	}, []string{"Empty"})
+}
+
+func TestParseLastPassCSV(t *testing.T) {
+	res := parseFile(t, LastPassCSV, "testdata/lastpass.csv")
+
+	checkResult(t, res, []Entry{
+		{
+			Type:     models.PASS,
+			Name:     "Git",
+			Data:     "carol:p@ss",
+			Metadata: models.Metadata{models.MetaURL: "https://git.example.com", models.MetaFolder: "Dev"},
+		},
+		{
+			Type:     models.OTP,
+			Name:     "Git OTP",
+			Data:     testOTPURI,
+			Metadata: models.Metadata{models.MetaURL: "https://git.example.com", models.MetaFolder: "Dev"},
+		},
+		{
+			Type:     models.TEXT,
+			Name:     "Alarm",
+			Data:     "alarm code 1234",
+			Metadata: models.Metadata{models.MetaFolder: "Home"},
+		},
+	}, nil)
+}
+
+func TestParseCSVWithoutHeader(t *testing.T) {
+	_, err := Parse(GenericCSV, strings.NewReader("site,user\nexample.com,bob\n"))
+	if !errors.Is(err, ErrNoCSVHeader) {
+		t.Errorf("Parse: ErrNoCSVHeader is expected, got %v", err)
+	}
+}
+
+func TestUniqueNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{
+			name:  "repeated",
+			names: []string{"a", "a", "A"},
+			want:  []string{"a", "a (2)", "A (3)"},
+		},
+		{
+			name:  "numbered name taken before",
+			names: []string{"a (2)", "a", "a"},
+			want:  []string{"a (2)", "a", "a (3)"},
+		},
+		{
+			name:  "numbered name taken after",
+			names: []string{"a", "a", "a (2)"},
+			want:  []string{"a", "a (2)", "a (2) (2)"},
+		},
+		{
+			name:  "empty and slashes",
+			names: []string{"", " ", "x/y"},
+			want:  []string{"TEXT", "TEXT (2)", "x-y"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := make([]Entry, 0, len(tt.names))
+			for _, n := range tt.names {
+				entries = append(entries, Entry{Type: models.TEXT, Name: n})
+			}
+
+			uniqueNames(entries)
+
+			got := make([]string, 0, len(entries))
+			for _, e := range entries {
+				got = append(got, e.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("uniqueNames(%q) = %q, want %q", tt.names, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+type keePassFile struct {
+	Meta struct {
+		RecycleBinUUID string `xml:"RecycleBinUUID"`
+	} `xml:"Meta"`
+	Root struct {
+		Groups []keePassGroup `xml:"Group"`
+	} `xml:"Root"`
+}
+
+type keePassGroup struct {
+	UUID    string         `xml:"UUID"`
+	Name    string         `xml:"Name"`
+	Entries []keePassEntry `xml:"Entry"`
+	Groups  []keePassGroup `xml:"Group"`
+}
+
+type keePassEntry struct {
+	Strings []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"String"`
+}
+
+func (e keePassEntry) field(key string) string {
+	for _, s := range e.Strings {
+		if s.Key == key {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+// parseKeePass reads an unencrypted KeePass 2 XML export.
+// The top level group is the database itself and is not included into folder paths.
+func parseKeePass(r io.Reader) (*Result, error) {
+	var file keePassFile
+	if err := xml.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("error decoding keepass xml: %w", err)
+	}
+
+	res := &Result{}
+	for _, root := range file.Root.Groups {
+		walkKeePassGroup(res, root, nil, file.Meta.RecycleBinUUID)
+	}
+
+	return res, nil
+}
+
+func walkKeePassGroup(res *Result, g keePassGroup, path []string, recycleBin string) {
+	if recycleBin != "" && g.UUID == recycleBin {
+		return
+	}
+
+	folder := strings.Join(path, "/")
+	for _, e := range g.Entries {
+		title := e.field("Title")
+		login := e.field("UserName")
+		password := e.field("Password")
+		meta := models.Metadata{
+			models.MetaURL:    e.field("URL"),
+			models.MetaNotes:  e.field("Notes"),
+			models.MetaFolder: folder,
+		}
+
+		switch {
+		case login != "" || password != "":
+			res.add(Entry{Type: models.PASS, Name: title, Data: PassData(login, password), Metadata: meta})
+		case meta[models.MetaNotes] != "":
+			notes := meta[models.MetaNotes]
+			delete(meta, models.MetaNotes)
+			res.add(notesEntry(title, notes, meta))
+		default:
+			res.skip(title, "entry has neither credentials nor notes")
+		}
+	}
+
+	for _, sub := range g.Groups {
+		walkKeePassGroup(res, sub, append(path[:len(path):len(path)], sub.Name), recycleBin)
+	}
+}
@@ -0,0 +1,586 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/client"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	agentSocketName   = "gophkeeper-agent.sock"
+	agentLoginHeader  = "X-Gophkeeper-Login"
+	agentStateHeader  = "X-Gophkeeper-Agent"
+	agentProxyPath    = "/api/"
+	agentDialTimeout  = time.Second
+	agentShutdownWait = 5 * time.Second
+)
+
+// States of the agent session reported in agentStateHeader when the agent does not pass a request on.
+const (
+	agentStateLocked     = "locked"
+	agentStateOtherLogin = "other-login"
+	agentStateOffline    = "offline"
+)
+
+var (
+	ErrAgentNotRunning   = errors.New("agent is not running")
+	ErrAgentLocked       = errors.New("agent is locked")
+	ErrAgentOtherLogin   = errors.New("agent is unlocked for another login")
+	ErrAgentRunning      = errors.New("agent is already running")
+	ErrAgentUntrusted    = errors.New("agent socket is not private to the current user")
+	ErrServerUnreachable = errors.New("server is unreachable")
+)
+
+// AgentStatus is reported by agent status.
+type AgentStatus struct {
+	LockAt time.Time `json:"lock_at,omitempty"`
+	Login  string    `json:"login,omitempty"`
+	PID    int       `json:"pid"`
+	Locked bool      `json:"locked"`
+}
+
+// AgentSession is the session handed to the agent on unlock.
+type AgentSession struct {
+	Login      string `json:"login"`
+	Token      string `json:"token"`
+	DeviceCert string `json:"device_cert,omitempty"`
+	DeviceKey  string `json:"device_key,omitempty"`
+}
+
+// AgentSocketPath is the agent_socket setting or a socket in the user runtime directory.
+func AgentSocketPath() string {
+	if path := viper.GetString("agent_socket"); path != "" {
+		return path
+	}
+
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("gophkeeper-%d", os.Getuid()))
+	}
+
+	return filepath.Join(dir, agentSocketName)
+}
+
+// ListenAgent opens the agent socket accessible by the current user only. A socket left by
+// a crashed agent is removed, a live one is reported as ErrAgentRunning.
+func ListenAgent(ctx context.Context) (net.Listener, error) {
+	path := AgentSocketPath()
+	if _, err := GetAgentStatus(ctx); err == nil {
+		return nil, ErrAgentRunning
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating socket dir: %w", err)
+	}
+	if err := checkAgentDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error removing stale socket: %w", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error listening agent socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error setting socket permissions: %w", err)
+	}
+
+	return l, nil
+}
+
+// agentSession holds the unlocked session. It is kept in memory only and the config is
+// not used after start, requests of other processes are passed to the server with the session added.
+type agentSession struct {
+	lockAt  time.Time
+	timer   *time.Timer
+	logger  *zap.SugaredLogger
+	clients *client.SessionClients
+	client  *client.HTTPClient
+	// done is closed on lock, requests of the session in progress are cancelled then
+	done  chan struct{}
+	login string
+	token string
+	idle  time.Duration
+	mu    sync.Mutex
+}
+
+// unlock replaces the session, must be called with mu held.
+func (s *agentSession) unlock(session AgentSession) error {
+	httpclient, err := s.clients.Client(session.DeviceCert, session.DeviceKey)
+	if err != nil {
+		return err
+	}
+
+	s.lock()
+	s.login = session.Login
+	s.token = session.Token
+	s.client = httpclient
+	s.done = make(chan struct{})
+	s.touch()
+
+	return nil
+}
+
+// touch postpones the auto-lock, must be called with mu held.
+func (s *agentSession) touch() {
+	if s.token == "" {
+		return
+	}
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.lockAt = time.Now().Add(s.idle)
+	var timer *time.Timer
+	timer = time.AfterFunc(s.idle, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// The session could be touched while the timer was waiting for mu.
+		if s.timer != timer {
+			return
+		}
+		s.lock()
+		s.logger.Infoln("locked after idle timeout")
+	})
+	s.timer = timer
+}
+
+// lock forgets the session, must be called with mu held.
+func (s *agentSession) lock() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+	if s.client != nil {
+		s.client.CloseIdleConnections()
+		s.client = nil
+	}
+	s.login = ""
+	s.token = ""
+	s.lockAt = time.Time{}
+}
+
+// ServeAgent answers requests of other gclient processes until ctx is done or the agent is stopped.
+// The session of the current config is unlocked on start and locked after idle time without requests.
+func ServeAgent(ctx context.Context, logger *zap.SugaredLogger, l net.Listener, idle time.Duration) error {
+	s, err := newAgentSession(logger, idle)
+	if err != nil {
+		return err
+	}
+
+	return s.serve(ctx, l)
+}
+
+// newAgentSession reads everything the agent needs from the config. The session of the config
+// is moved into the agent, so it is not left on disk while the agent locks it.
+func newAgentSession(logger *zap.SugaredLogger, idle time.Duration) (*agentSession, error) {
+	clients, err := client.NewSessionClients(logger)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+
+	s := &agentSession{logger: logger, clients: clients, idle: idle}
+	token := viper.GetString("token")
+	if token == "" {
+		return s, nil
+	}
+
+	if err := s.unlock(configSession(token)); err != nil {
+		return nil, fmt.Errorf("error unlocking agent: %w", err)
+	}
+	if err := clearConfigSession(); err != nil {
+		s.lock()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *agentSession) serve(ctx context.Context, l net.Listener) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	defer func() {
+		s.mu.Lock()
+		s.lock()
+		s.mu.Unlock()
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		writeAgentJSON(w, AgentStatus{Locked: s.token == "", Login: s.login, LockAt: s.lockAt, PID: os.Getpid()})
+	})
+	mux.HandleFunc("/lock", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.lock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/unlock", func(w http.ResponseWriter, r *http.Request) {
+		var session AgentSession
+		if err := json.NewDecoder(r.Body).Decode(&session); err != nil || session.Token == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if err := s.unlock(session); err != nil {
+			s.logger.Errorf("error unlocking: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		stop()
+	})
+	mux.HandleFunc(agentProxyPath, s.proxy)
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: agentDialTimeout}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), agentShutdownWait)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			s.logger.Errorf("error stopping agent: %v", err)
+		}
+	}()
+
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving agent: %w", err)
+	}
+
+	return nil
+}
+
+// proxy passes an API request to the server with the token of the session. The request
+// is cancelled when the session is locked.
+func (s *agentSession) proxy(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if s.token == "" {
+		s.mu.Unlock()
+		writeAgentState(w, agentStateLocked, http.StatusLocked)
+		return
+	}
+	if login := r.Header.Get(agentLoginHeader); login != "" && login != s.login {
+		s.mu.Unlock()
+		writeAgentState(w, agentStateOtherLogin, http.StatusConflict)
+		return
+	}
+	s.touch()
+	httpclient, token, done := s.client, s.token, s.done
+	// the server is not waited for with the session held, status and lock stay responsive
+	s.mu.Unlock()
+
+	target, err := url.Parse(strings.TrimSuffix(httpclient.APIURL, "/") + "/" +
+		strings.TrimPrefix(r.URL.EscapedPath(), agentProxyPath))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	target.RawQuery = r.URL.RawQuery
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(out *http.Request) {
+			out.URL = target
+			out.Host = target.Host
+			out.Header.Del(agentLoginHeader)
+			out.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		},
+		Transport: httpclient.Transport,
+		// events of watch are passed as they come
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			select {
+			case <-done:
+				writeAgentState(w, agentStateLocked, http.StatusLocked)
+				return
+			default:
+			}
+			if isOffline(err) {
+				writeAgentState(w, agentStateOffline, http.StatusBadGateway)
+				return
+			}
+
+			s.logger.Errorf("error passing request: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func writeAgentState(w http.ResponseWriter, state string, status int) {
+	w.Header().Set(agentStateHeader, state)
+	w.WriteHeader(status)
+}
+
+func writeAgentJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// agentTransport passes requests to the agent over its socket. Answers of the agent itself
+// are returned as errors: ErrAgentLocked, ErrAgentOtherLogin and ErrServerUnreachable.
+// ErrAgentNotRunning is returned when nobody listens on the socket, ErrAgentUntrusted
+// when the socket may belong to another user.
+type agentTransport struct {
+	base  http.RoundTripper
+	login string
+}
+
+func (t *agentTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if t.login != "" {
+		request = request.Clone(request.Context())
+		request.Header.Set(agentLoginHeader, t.login)
+	}
+
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		if errors.Is(err, ErrAgentUntrusted) {
+			return nil, ErrAgentUntrusted
+		}
+		if request.Context().Err() != nil {
+			return nil, request.Context().Err()
+		}
+		return nil, ErrAgentNotRunning
+	}
+
+	var stateErr error
+	switch response.Header.Get(agentStateHeader) {
+	case agentStateLocked:
+		stateErr = ErrAgentLocked
+	case agentStateOtherLogin:
+		stateErr = ErrAgentOtherLogin
+	case agentStateOffline:
+		stateErr = ErrServerUnreachable
+	default:
+		return response, nil
+	}
+	response.Body.Close()
+
+	return nil, stateErr
+}
+
+// newAgentClient makes a client of the agent socket. ErrAgentNotRunning is returned when there is no socket.
+func newAgentClient() (*http.Client, error) {
+	socket := AgentSocketPath()
+	if _, err := os.Stat(socket); err != nil {
+		return nil, ErrAgentNotRunning
+	}
+	if err := checkAgentDir(filepath.Dir(socket)); err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &agentTransport{
+			base: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					d := net.Dialer{Timeout: agentDialTimeout}
+					conn, err := d.DialContext(ctx, "unix", socket)
+					if err != nil {
+						return nil, err
+					}
+					if err := checkAgentPeer(conn); err != nil {
+						conn.Close()
+						return nil, err
+					}
+					return conn, nil
+				},
+			},
+			login: viper.GetString("login"),
+		},
+	}, nil
+}
+
+// agentProxySession sends API requests through the agent, which adds its session.
+// ErrNotLoggedIn is returned when no agent runs.
+func agentProxySession() (*apiSession, error) {
+	httpclient, err := newAgentClient()
+	if errors.Is(err, ErrAgentNotRunning) {
+		return nil, ErrNotLoggedIn
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiSession{client: httpclient, apiURL: "http://agent" + agentProxyPath}, nil
+}
+
+// agentCall sends a request to the agent itself, errors are those of agentTransport.
+func agentCall(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	httpclient, err := newAgentClient()
+	if err != nil {
+		return err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, "http://agent"+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	response, err := httpclient.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		if out == nil {
+			return nil
+		}
+		if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+			return fmt.Errorf("error decode body: %w", err)
+		}
+		return nil
+	case http.StatusNoContent:
+		return nil
+	default:
+		return &APIError{Method: method, Path: "agent" + path, Status: response.StatusCode}
+	}
+}
+
+// controlAgentConn compares the user of the agent process, read by peerUID from the socket, with uid.
+func controlAgentConn(conn net.Conn, peerUID func(fd int) (int, error), uid int) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("%w: not a unix socket", ErrAgentUntrusted)
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("error reading agent socket: %w", err)
+	}
+
+	var (
+		peer    int
+		peerErr error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		peer, peerErr = peerUID(int(fd))
+	}); err != nil {
+		return fmt.Errorf("error reading agent socket: %w", err)
+	}
+	if peerErr != nil {
+		return fmt.Errorf("error reading agent peer credentials: %w", peerErr)
+	}
+	if peer != uid {
+		return fmt.Errorf("%w: agent runs as user %d", ErrAgentUntrusted, peer)
+	}
+
+	return nil
+}
+
+// GetAgentStatus reports the state of the running agent.
+func GetAgentStatus(ctx context.Context) (*AgentStatus, error) {
+	status := &AgentStatus{}
+	if err := agentCall(ctx, http.MethodGet, "/status", nil, status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// LockAgent forgets the session kept by the agent.
+func LockAgent(ctx context.Context) error {
+	return agentCall(ctx, http.MethodPost, "/lock", nil, nil)
+}
+
+// UnlockAgent moves the session of the current config to the agent.
+func UnlockAgent(ctx context.Context) error {
+	token := viper.GetString("token")
+	if token == "" {
+		return ErrNotLoggedIn
+	}
+
+	if err := UnlockAgentSession(ctx, configSession(token)); err != nil {
+		return err
+	}
+
+	return clearConfigSession()
+}
+
+// UnlockAgentSession hands the session to the agent.
+func UnlockAgentSession(ctx context.Context, session AgentSession) error {
+	return agentCall(ctx, http.MethodPost, "/unlock", session, nil)
+}
+
+// configSession is the session of the current config, the device certificate included.
+func configSession(token string) AgentSession {
+	return AgentSession{
+		Login:      viper.GetString("login"),
+		Token:      token,
+		DeviceCert: viper.GetString("device_cert"),
+		DeviceKey:  viper.GetString("device_key"),
+	}
+}
+
+// clearConfigSession removes the token and the device key from the config, the agent keeps them.
+func clearConfigSession() error {
+	viper.Set("token", "")
+	viper.Set("device_cert", "")
+	viper.Set("device_key", "")
+	if err := viper.WriteConfigAs(client.ConfigPath()); err != nil {
+		return fmt.Errorf("error saving config: %w", err)
+	}
+
+	return nil
+}
+
+// StopAgent asks the agent to exit.
+func StopAgent(ctx context.Context) error {
+	return agentCall(ctx, http.MethodPost, "/stop", nil, nil)
+}
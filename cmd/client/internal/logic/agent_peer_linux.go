@@ -0,0 +1,21 @@
+//go:build linux
+
+package logic
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkAgentPeer makes sure the agent socket is served by a process of the current user.
+func checkAgentPeer(conn net.Conn) error {
+	return controlAgentConn(conn, func(fd int) (int, error) {
+		cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+		if err != nil {
+			return 0, err
+		}
+		return int(cred.Uid), nil
+	}, os.Getuid())
+}
@@ -0,0 +1,140 @@
+//go:build linux || darwin
+
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/certpin"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const testAgentToken = "agent-token"
+
+// startTestAgent serves an agent over a socket in a temp dir. The session of the config
+// is made for alice and the API answers records of her token only.
+func startTestAgent(t *testing.T) {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testAgentToken || r.Header.Get(agentLoginHeader) != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/user/records/mail box" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(models.DataRecord{ID: 1, Type: models.TEXT, Name: "mail box", Data: "secret"})
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigFile(filepath.Join(dir, "gophkeeper.json"))
+	viper.Set("api", srv.URL)
+	viper.Set("pins", []string{certpin.Of(srv.Certificate())})
+	viper.Set("agent_socket", filepath.Join(dir, "agent", agentSocketName))
+	viper.Set("login", "alice")
+	viper.Set("token", testAgentToken)
+
+	l, err := ListenAgent(context.Background())
+	if err != nil {
+		t.Fatalf("ListenAgent: %v", err)
+	}
+	s, err := newAgentSession(zap.NewNop().Sugar(), time.Minute)
+	if err != nil {
+		t.Fatalf("newAgentSession: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := s.serve(ctx, l); err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestAgentKeepsSession(t *testing.T) {
+	startTestAgent(t)
+	ctx := context.Background()
+
+	if token := viper.GetString("token"); token != "" {
+		t.Fatalf("the token is expected to move from the config into the agent, config has %q", token)
+	}
+
+	record, err := GetRecord(ctx, "mail box")
+	if err != nil {
+		t.Fatalf("GetRecord through the agent: %v", err)
+	}
+	if record.Data != "secret" {
+		t.Errorf("GetRecord: got %+v", record)
+	}
+	if _, err := GetRecord(ctx, "other"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("GetRecord of a missing record: ErrRecordNotFound is expected, got %v", err)
+	}
+
+	if err := LockAgent(ctx); err != nil {
+		t.Fatalf("LockAgent: %v", err)
+	}
+	if _, err := GetRecord(ctx, "mail box"); !errors.Is(err, ErrAgentLocked) {
+		t.Errorf("GetRecord of a locked agent: ErrAgentLocked is expected, got %v", err)
+	}
+
+	if err := UnlockAgentSession(ctx, AgentSession{Login: "alice", Token: testAgentToken}); err != nil {
+		t.Fatalf("UnlockAgentSession: %v", err)
+	}
+	if _, err := GetRecord(ctx, "mail box"); err != nil {
+		t.Errorf("GetRecord after unlock: %v", err)
+	}
+
+	viper.Set("login", "bob")
+	if _, err := GetRecord(ctx, "mail box"); !errors.Is(err, ErrAgentOtherLogin) {
+		t.Errorf("GetRecord of another login: ErrAgentOtherLogin is expected, got %v", err)
+	}
+}
+
+// TestAgentConcurrentLock runs requests while the session is locked and unlocked, for the race detector.
+func TestAgentConcurrentLock(t *testing.T) {
+	startTestAgent(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := GetRecord(ctx, "mail box"); err != nil && !errors.Is(err, ErrAgentLocked) {
+					t.Errorf("GetRecord: %v", err)
+				}
+			}
+		}()
+	}
+	for j := 0; j < 20; j++ {
+		if err := LockAgent(ctx); err != nil {
+			t.Errorf("LockAgent: %v", err)
+		}
+		if err := UnlockAgentSession(ctx, AgentSession{Login: "alice", Token: testAgentToken}); err != nil {
+			t.Errorf("UnlockAgentSession: %v", err)
+		}
+	}
+	wg.Wait()
+}
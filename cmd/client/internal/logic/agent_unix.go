@@ -0,0 +1,26 @@
+//go:build !windows
+
+package logic
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkAgentDir makes sure nobody but the current user can place a socket into dir.
+// The fallback directory in the shared temp dir has a predictable name, another user
+// could create it first.
+func checkAgentDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("error checking agent socket dir: %w", err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Getuid() || info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%w: %s must be a directory of the current user with 0700 permissions", ErrAgentUntrusted, dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package logic
+
+// checkAgentDir relies on the per user temp and runtime directories of Windows.
+func checkAgentDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/client"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrNotLoggedIn = errors.New("no auth data, login first")
+	ErrConfig      = errors.New("configuration error")
+)
+
+// APIError is returned when the server answers with an unexpected status.
+type APIError struct {
+	Method string
+	Path   string
+	Status int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.Status, http.StatusText(e.Status))
+}
+
+// apiSession sends requests of the current session: with the token of the config, or through
+// the agent which keeps the session in memory and adds it to the requests.
+type apiSession struct {
+	client *http.Client
+	apiURL string
+	token  string
+}
+
+// currentSession is the session of the config, or the agent session when the config has no token.
+func currentSession() (*apiSession, error) {
+	token := viper.GetString("token")
+	if token == "" {
+		return agentProxySession()
+	}
+
+	httpclient := client.GetHTTPClient()
+	if httpclient == nil {
+		return nil, ErrConfig
+	}
+
+	return &apiSession{client: httpclient.Client, apiURL: httpclient.APIURL, token: token}, nil
+}
+
+// newRequest makes an authorized request to the API path.
+func (s *apiSession) newRequest(
+	ctx context.Context,
+	method string,
+	path string,
+	query url.Values,
+	body io.Reader,
+) (*http.Request, error) {
+	endpoint, err := url.JoinPath(s.apiURL, path)
+	if err != nil {
+		return nil, fmt.Errorf("error building endpoint: %w", err)
+	}
+	if len(query) != 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	}
+
+	return request, nil
+}
+
+// apiCall sends an authorized request to the API. body and out are encoded and decoded as json
+// when not nil. Statuses other than the expected ones are returned as *APIError.
+func apiCall(
+	ctx context.Context,
+	method string,
+	path string,
+	query url.Values,
+	body interface{},
+	out interface{},
+	expected ...int,
+) (int, error) {
+	session, err := currentSession()
+	if err != nil {
+		return 0, err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	request, err := session.newRequest(ctx, method, path, query, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Add("Content-Type", "application/json")
+
+	response, err := session.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	for _, status := range expected {
+		if response.StatusCode != status {
+			continue
+		}
+
+		if out != nil && status != http.StatusNoContent {
+			if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+				return status, fmt.Errorf("error decode body: %w", err)
+			}
+		}
+		return status, nil
+	}
+
+	return response.StatusCode, &APIError{Method: method, Path: path, Status: response.StatusCode}
+}
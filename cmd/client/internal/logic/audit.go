@@ -0,0 +1,54 @@
+package logic
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// AuditFilter selects events of the user audit log, zero values select everything.
+type AuditFilter struct {
+	Since   time.Time
+	Target  string
+	Actions []string
+	Limit   int
+}
+
+// ListAuditEvents requests the latest events of the audit log matching filter, up to filter.Limit.
+func ListAuditEvents(ctx context.Context, filter AuditFilter) ([]models.AuditEvent, error) {
+	query := url.Values{}
+	if len(filter.Actions) != 0 {
+		query.Set("action", strings.Join(filter.Actions, ","))
+	}
+	if filter.Target != "" {
+		query.Set("target", filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339Nano))
+	}
+
+	events := make([]models.AuditEvent, 0)
+	for {
+		limit := models.MaxAuditLimit
+		if filter.Limit > 0 && filter.Limit-len(events) < limit {
+			limit = filter.Limit - len(events)
+		}
+		query.Set("limit", strconv.Itoa(limit))
+
+		var page models.AuditPage
+		if _, err := apiCall(ctx, http.MethodGet, "api/user/audit", query, nil, &page, http.StatusOK); err != nil {
+			return nil, err
+		}
+
+		events = append(events, page.Events...)
+		if page.NextBefore == 0 || (filter.Limit > 0 && len(events) >= filter.Limit) {
+			return events, nil
+		}
+		query.Set("before", strconv.FormatUint(page.NextBefore, 10))
+	}
+}
@@ -0,0 +1,55 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// BatchRecords sends several record operations to the server in a single request.
+func BatchRecords(ctx context.Context, batch models.BatchRequest) (*models.BatchResponse, error) {
+	session, err := currentSession()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range batch.Operations {
+		if op.Record != nil && op.Record.Checksum == "" {
+			op.Record.Checksum = fmt.Sprintf("%x", md5.Sum([]byte(op.Record.Data)))
+		}
+	}
+
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := session.newRequest(ctx, http.MethodPost, "api/user/records/batch", nil, bytes.NewBuffer(b))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Add("Content-Type", "application/json")
+
+	response, err := session.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusUnprocessableEntity {
+		return nil, fmt.Errorf("error in batch: %s", response.Status)
+	}
+
+	var res models.BatchResponse
+	if err = json.NewDecoder(response.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("error decode body: %w", err)
+	}
+
+	return &res, nil
+}
@@ -0,0 +1,45 @@
+package logic
+
+import (
+	"errors"
+	"net"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/cache"
+	"github.com/spf13/viper"
+)
+
+// withCache opens the cache of the logged in user for the duration of fn.
+func withCache(fn func(c *cache.Cache) error) error {
+	c, err := cache.Open(viper.GetString("login"))
+	if err != nil {
+		return err
+	}
+
+	if err := fn(c); err != nil {
+		c.Close()
+		return err
+	}
+
+	return c.Close()
+}
+
+// ClearCache removes the cache of the logged in user.
+func ClearCache() error {
+	return cache.Remove(viper.GetString("login"))
+}
+
+// isOffline reports whether err means the server could not be reached at all. Failures after
+// the connection is made, like certificate or pin mismatches, are not covered.
+func isOffline(err error) bool {
+	if errors.Is(err, ErrServerUnreachable) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,54 @@
+package logic
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"go.uber.org/zap"
+)
+
+// credentialRecords is the record access of the git and docker credential helpers.
+// The helpers work with the server through apiRecords, tests keep records in memory.
+type credentialRecords interface {
+	List(ctx context.Context, filter RecordsFilter) ([]models.DataRecord, error)
+	Upload(ctx context.Context, record models.DataRecordRequest) error
+	Delete(ctx context.Context, record *models.DataRecord) error
+	// Folder returns the id of the folder at path, creating it when it does not exist.
+	Folder(ctx context.Context, path string) (uint64, error)
+}
+
+type apiRecords struct {
+	logger *zap.SugaredLogger
+}
+
+func (r apiRecords) List(ctx context.Context, filter RecordsFilter) ([]models.DataRecord, error) {
+	return ListRecords(ctx, r.logger, filter)
+}
+
+func (r apiRecords) Upload(ctx context.Context, record models.DataRecordRequest) error {
+	_, err := UploadRecord(ctx, record)
+	return err
+}
+
+func (r apiRecords) Delete(ctx context.Context, record *models.DataRecord) error {
+	return deleteRecord(ctx, record)
+}
+
+func (r apiRecords) Folder(ctx context.Context, path string) (uint64, error) {
+	folders, err := ListFolders(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	folderID, err := ResolveFolder(folders, path)
+	if errors.Is(err, ErrFolderNotFound) {
+		folder, err := CreateFolder(ctx, path)
+		if err != nil {
+			return 0, err
+		}
+		return folder.ID, nil
+	}
+
+	return folderID, err
+}
@@ -0,0 +1,356 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// fakeRecords keeps records of the credential helpers in memory. Folders are top level only.
+type fakeRecords struct {
+	folders map[string]uint64
+	records []models.DataRecord
+	nextID  uint64
+}
+
+func newFakeRecords(folders map[string]uint64, records ...models.DataRecord) *fakeRecords {
+	f := &fakeRecords{folders: folders, nextID: 100}
+	if f.folders == nil {
+		f.folders = map[string]uint64{}
+	}
+	for i, r := range records {
+		r.ID = uint64(i + 1)
+		r.Type = models.PASS
+		f.records = append(f.records, r)
+	}
+	return f
+}
+
+func (f *fakeRecords) List(ctx context.Context, filter RecordsFilter) ([]models.DataRecord, error) {
+	folderID, inFolder := uint64(0), filter.Folder != ""
+	if inFolder {
+		id, ok := f.folders[filter.Folder]
+		if !ok {
+			return nil, ErrFolderNotFound
+		}
+		folderID = id
+	}
+
+	var res []models.DataRecord
+	for _, r := range f.records {
+		if string(r.Type) == filter.Type && (!inFolder || r.FolderID == folderID) {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeRecords) Upload(ctx context.Context, req models.DataRecordRequest) error {
+	record := models.DataRecord{
+		ID: req.ID, Type: req.Type, Name: req.Name, Data: req.Data, Metadata: req.Metadata, FolderID: req.FolderID,
+	}
+	if record.ID == 0 {
+		f.nextID++
+		record.ID = f.nextID
+		f.records = append(f.records, record)
+		return nil
+	}
+
+	for i := range f.records {
+		if f.records[i].ID == record.ID {
+			f.records[i] = record
+			return nil
+		}
+	}
+	return fmt.Errorf("record %d not found", record.ID)
+}
+
+func (f *fakeRecords) Delete(ctx context.Context, record *models.DataRecord) error {
+	for i := range f.records {
+		if f.records[i].ID == record.ID {
+			f.records = append(f.records[:i], f.records[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("record %d not found", record.ID)
+}
+
+func (f *fakeRecords) Folder(ctx context.Context, path string) (uint64, error) {
+	if id, ok := f.folders[path]; ok {
+		return id, nil
+	}
+	id := uint64(len(f.folders) + 1)
+	f.folders[path] = id
+	return id, nil
+}
+
+// summary describes records as "folder|name|data|url" lines in a stable order.
+func (f *fakeRecords) summary() []string {
+	names := make(map[uint64]string, len(f.folders))
+	for name, id := range f.folders {
+		names[id] = name
+	}
+
+	res := make([]string, 0, len(f.records))
+	for _, r := range f.records {
+		res = append(res, strings.Join([]string{names[r.FolderID], r.Name, r.Data, r.Metadata[models.MetaURL]}, "|"))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func gitRecord(name, data, url string) models.DataRecord {
+	return models.DataRecord{Name: name, Data: data, Metadata: models.Metadata{models.MetaURL: url}}
+}
+
+func TestGitCredentialHelper(t *testing.T) {
+	github := gitRecord("github", "alice:a-token", "https://github.com")
+	org := gitRecord("org", "bob:b-token", "https://github.com/org")
+	gitlab := gitRecord("gitlab", "carol:c-token", "gitlab.example.com:8443")
+
+	tests := []struct {
+		name    string
+		records []models.DataRecord
+		action  string
+		input   string
+		want    string
+		after   []string
+	}{
+		{
+			name:    "get by host",
+			records: []models.DataRecord{github, gitlab},
+			action:  "get",
+			input:   "protocol=https\nhost=github.com\n\n",
+			want:    "username=alice\npassword=a-token\n",
+		},
+		{
+			name:    "get prefers the longest path",
+			records: []models.DataRecord{github, org},
+			action:  "get",
+			input:   "protocol=https\nhost=github.com\npath=org/repo.git\n",
+			want:    "username=bob\npassword=b-token\n",
+		},
+		{
+			name:    "get skips records of other paths",
+			records: []models.DataRecord{github, org},
+			action:  "get",
+			input:   "protocol=https\nhost=github.com\npath=other/repo.git\n",
+			want:    "username=alice\npassword=a-token\n",
+		},
+		{
+			name:    "get by username",
+			records: []models.DataRecord{github, org},
+			action:  "get",
+			input:   "protocol=https\nhost=github.com\nusername=bob\n",
+			want:    "username=bob\npassword=b-token\n",
+		},
+		{
+			name:    "get by url with a default port",
+			records: []models.DataRecord{github},
+			action:  "get",
+			input:   "url=https://alice@github.com:443/\n",
+			want:    "username=alice\npassword=a-token\n",
+		},
+		{
+			name:    "get of a non-default port",
+			records: []models.DataRecord{gitlab},
+			action:  "get",
+			input:   "protocol=https\nhost=gitlab.example.com:8443\n",
+			want:    "username=carol\npassword=c-token\n",
+		},
+		{
+			name:    "get of another protocol",
+			records: []models.DataRecord{github},
+			action:  "get",
+			input:   "protocol=http\nhost=github.com\n",
+		},
+		{
+			name:    "store a new credential",
+			records: []models.DataRecord{github},
+			action:  "store",
+			input:   "protocol=https\nhost=example.com\npath=team/repo.git\nusername=dave\npassword=d-token\n",
+			after: []string{
+				"|git example.com-team-repo.git dave|dave:d-token|https://example.com/team/repo.git",
+				"|github|alice:a-token|https://github.com",
+			},
+		},
+		{
+			name:    "store updates the host record of a path",
+			records: []models.DataRecord{github},
+			action:  "store",
+			input:   "protocol=https\nhost=github.com\npath=org/repo.git\nusername=alice\npassword=new-token\n",
+			after:   []string{"|github|alice:new-token|https://github.com"},
+		},
+		{
+			name:    "store without a password",
+			records: []models.DataRecord{github},
+			action:  "store",
+			input:   "protocol=https\nhost=example.com\nusername=dave\n",
+			after:   []string{"|github|alice:a-token|https://github.com"},
+		},
+		{
+			name:    "erase the rejected password",
+			records: []models.DataRecord{github, gitlab},
+			action:  "erase",
+			input:   "protocol=https\nhost=github.com\nusername=alice\npassword=a-token\n",
+			after:   []string{"|gitlab|carol:c-token|gitlab.example.com:8443"},
+		},
+		{
+			name:    "erase keeps a changed password",
+			records: []models.DataRecord{github},
+			action:  "erase",
+			input:   "protocol=https\nhost=github.com\nusername=alice\npassword=old-token\n",
+			after:   []string{"|github|alice:a-token|https://github.com"},
+		},
+		{
+			name:    "unknown action",
+			records: []models.DataRecord{github},
+			action:  "capability",
+			input:   "protocol=https\nhost=github.com\n",
+			after:   []string{"|github|alice:a-token|https://github.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := newFakeRecords(nil, tt.records...)
+			var out strings.Builder
+
+			if err := gitCredentialHelper(context.Background(), records, tt.action, strings.NewReader(tt.input), &out); err != nil {
+				t.Fatalf("gitCredentialHelper: %v", err)
+			}
+
+			if out.String() != tt.want {
+				t.Errorf("output %q, want %q", out.String(), tt.want)
+			}
+			if tt.after != nil && !reflect.DeepEqual(records.summary(), tt.after) {
+				t.Errorf("records %q, want %q", records.summary(), tt.after)
+			}
+		})
+	}
+}
+
+func TestGitCredentialHelperBadInput(t *testing.T) {
+	err := gitCredentialHelper(context.Background(), newFakeRecords(nil), "get", strings.NewReader("host\n"), &strings.Builder{})
+	if err == nil {
+		t.Error("gitCredentialHelper: an error is expected for a line without =")
+	}
+}
+
+func TestDockerCredentialHelper(t *testing.T) {
+	folders := func() map[string]uint64 { return map[string]uint64{DockerFolder: 7} }
+	registry := models.DataRecord{
+		Name:     "registry.example.com",
+		Data:     "robot:r-secret",
+		Metadata: models.Metadata{models.MetaURL: "https://registry.example.com/"},
+		FolderID: 7,
+	}
+	elsewhere := models.DataRecord{
+		Name:     "not docker",
+		Data:     "eve:e-secret",
+		Metadata: models.Metadata{models.MetaURL: "https://ghcr.io"},
+	}
+
+	tests := []struct {
+		name    string
+		folders map[string]uint64
+		records []models.DataRecord
+		action  string
+		input   string
+		want    string
+		err     error
+		after   []string
+	}{
+		{
+			name:    "get",
+			folders: folders(),
+			records: []models.DataRecord{registry, elsewhere},
+			action:  "get",
+			input:   "registry.example.com\n",
+			want:    `{"ServerURL":"registry.example.com","Username":"robot","Secret":"r-secret"}` + "\n",
+		},
+		{
+			name:    "get outside of the docker folder",
+			folders: folders(),
+			records: []models.DataRecord{registry, elsewhere},
+			action:  "get",
+			input:   "https://ghcr.io",
+			err:     ErrDockerCredentialsNotFound,
+		},
+		{
+			name:   "get without the docker folder",
+			action: "get",
+			input:  "registry.example.com",
+			err:    ErrDockerCredentialsNotFound,
+		},
+		{
+			name:   "store creates the folder",
+			action: "store",
+			input:  `{"ServerURL":"https://index.docker.io/v1/","Username":"me","Secret":"pw"}`,
+			after:  []string{"docker|index.docker.io-v1|me:pw|https://index.docker.io/v1/"},
+		},
+		{
+			name:    "store updates the record",
+			folders: folders(),
+			records: []models.DataRecord{registry},
+			action:  "store",
+			input:   `{"ServerURL":"https://REGISTRY.example.com","Username":"robot","Secret":"rotated"}`,
+			after:   []string{"docker|registry.example.com|robot:rotated|https://registry.example.com/"},
+		},
+		{
+			name:    "erase",
+			folders: folders(),
+			records: []models.DataRecord{registry, elsewhere},
+			action:  "erase",
+			input:   "https://registry.example.com",
+			after:   []string{"|not docker|eve:e-secret|https://ghcr.io"},
+		},
+		{
+			name:    "erase of unknown server",
+			folders: folders(),
+			records: []models.DataRecord{registry},
+			action:  "erase",
+			input:   "https://ghcr.io",
+			err:     ErrDockerCredentialsNotFound,
+		},
+		{
+			name:    "list",
+			folders: folders(),
+			records: []models.DataRecord{registry, elsewhere},
+			action:  "list",
+			want:    `{"https://registry.example.com/":"robot"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := newFakeRecords(tt.folders, tt.records...)
+			var out strings.Builder
+
+			err := dockerCredentialHelper(context.Background(), records, tt.action, strings.NewReader(tt.input), &out)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("dockerCredentialHelper: error %v is expected, got %v", tt.err, err)
+			}
+
+			if out.String() != tt.want {
+				t.Errorf("output %q, want %q", out.String(), tt.want)
+			}
+			if tt.after != nil && !reflect.DeepEqual(records.summary(), tt.after) {
+				t.Errorf("records %q, want %q", records.summary(), tt.after)
+			}
+		})
+	}
+}
+
+func TestDockerCredentialHelperUnknownAction(t *testing.T) {
+	err := dockerCredentialHelper(context.Background(), newFakeRecords(nil), "version", strings.NewReader(""), &strings.Builder{})
+	if err == nil {
+		t.Error("dockerCredentialHelper: an error is expected for an unknown action")
+	}
+}
@@ -0,0 +1,185 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"go.uber.org/zap"
+)
+
+// DockerFolder keeps PASS records of container registries, one record per server URL.
+const DockerFolder = "docker"
+
+// ErrDockerCredentialsNotFound has the exact text docker expects from helpers.
+var ErrDockerCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// DockerCredentials is the payload of docker credential helpers, see docker-credential-helpers.
+type DockerCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// DockerCredentialHelper answers the get, store, erase and list actions of the docker credential
+// helper protocol. The request is read from in, the reply is written to out as json.
+func DockerCredentialHelper(ctx context.Context, logger *zap.SugaredLogger, action string, in io.Reader, out io.Writer) error {
+	return dockerCredentialHelper(ctx, apiRecords{logger: logger}, action, in, out)
+}
+
+func dockerCredentialHelper(ctx context.Context, records credentialRecords, action string, in io.Reader, out io.Writer) error {
+	input, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("error reading request: %w", err)
+	}
+
+	var reply any
+	switch action {
+	case "get":
+		reply, err = getDockerCredential(ctx, records, strings.TrimSpace(string(input)))
+	case "store":
+		creds := &DockerCredentials{}
+		if err = json.Unmarshal(input, creds); err == nil {
+			err = storeDockerCredential(ctx, records, creds)
+		}
+	case "erase":
+		err = eraseDockerCredential(ctx, records, strings.TrimSpace(string(input)))
+	case "list":
+		reply, err = listDockerCredentials(ctx, records)
+	default:
+		err = fmt.Errorf("unknown credential action %s", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	if reply == nil {
+		return nil
+	}
+	return json.NewEncoder(out).Encode(reply)
+}
+
+// getDockerCredential returns credentials of the registry.
+func getDockerCredential(ctx context.Context, records credentialRecords, serverURL string) (*DockerCredentials, error) {
+	record, err := findDockerRecord(ctx, records, serverURL)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrDockerCredentialsNotFound
+	}
+
+	login, secret, _ := strings.Cut(record.Data, ":")
+	return &DockerCredentials{ServerURL: serverURL, Username: login, Secret: secret}, nil
+}
+
+// storeDockerCredential creates or updates the registry record, creating the docker folder if needed.
+func storeDockerCredential(ctx context.Context, records credentialRecords, creds *DockerCredentials) error {
+	if creds.ServerURL == "" {
+		return errors.New("no server url in credentials")
+	}
+
+	record, err := findDockerRecord(ctx, records, creds.ServerURL)
+	if err != nil {
+		return err
+	}
+
+	data := creds.Username + ":" + creds.Secret
+	if record != nil {
+		if record.Data == data {
+			return nil
+		}
+
+		return records.Upload(ctx, models.DataRecordRequest{
+			ID:       record.ID,
+			Type:     record.Type,
+			Name:     record.Name,
+			Data:     data,
+			Metadata: record.Metadata,
+			FolderID: record.FolderID,
+		})
+	}
+
+	folderID, err := records.Folder(ctx, DockerFolder)
+	if err != nil {
+		return err
+	}
+
+	return records.Upload(ctx, models.DataRecordRequest{
+		Type:     models.PASS,
+		Name:     strings.ReplaceAll(normalizeServerURL(creds.ServerURL), models.PathSeparator, "-"),
+		Data:     data,
+		Metadata: models.Metadata{models.MetaURL: creds.ServerURL},
+		FolderID: folderID,
+	})
+}
+
+// eraseDockerCredential moves the registry record to the trash.
+func eraseDockerCredential(ctx context.Context, records credentialRecords, serverURL string) error {
+	record, err := findDockerRecord(ctx, records, serverURL)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return ErrDockerCredentialsNotFound
+	}
+
+	return records.Delete(ctx, record)
+}
+
+// listDockerCredentials maps server URLs to usernames.
+func listDockerCredentials(ctx context.Context, records credentialRecords) (map[string]string, error) {
+	list, err := dockerRecords(ctx, records)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string, len(list))
+	for _, r := range list {
+		if serverURL := r.Metadata[models.MetaURL]; serverURL != "" {
+			login, _, _ := strings.Cut(r.Data, ":")
+			res[serverURL] = login
+		}
+	}
+
+	return res, nil
+}
+
+func findDockerRecord(ctx context.Context, records credentialRecords, serverURL string) (*models.DataRecord, error) {
+	list, err := dockerRecords(ctx, records)
+	if err != nil {
+		return nil, err
+	}
+
+	want := normalizeServerURL(serverURL)
+	for i := range list {
+		if normalizeServerURL(list[i].Metadata[models.MetaURL]) == want {
+			return &list[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// dockerRecords lists PASS records of the docker folder, none when the folder does not exist yet.
+func dockerRecords(ctx context.Context, records credentialRecords) ([]models.DataRecord, error) {
+	list, err := records.List(ctx, RecordsFilter{Type: string(models.PASS), Folder: DockerFolder})
+	if errors.Is(err, ErrFolderNotFound) {
+		return nil, nil
+	}
+
+	return list, err
+}
+
+// normalizeServerURL makes https://registry.example.com/ and registry.example.com equal.
+func normalizeServerURL(serverURL string) string {
+	if _, rest, ok := strings.Cut(serverURL, "://"); ok {
+		serverURL = rest
+	}
+
+	return strings.ToLower(strings.TrimRight(serverURL, "/"))
+}
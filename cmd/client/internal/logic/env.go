@@ -0,0 +1,82 @@
+package logic
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvVar is a single NAME=VALUE mapping. Values starting with gk:// are resolved from the vault,
+// other values are passed to the child process as is.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// ParseEnvVar parses NAME=VALUE.
+func ParseEnvVar(s string) (EnvVar, error) {
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), "export "))
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return EnvVar{}, fmt.Errorf("bad env mapping %q, want NAME=VALUE", s)
+	}
+
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+
+	return EnvVar{Name: name, Value: value}, nil
+}
+
+// ParseEnvFile reads a mapping file in the .env format: one NAME=VALUE per line,
+// empty lines and lines starting with # are skipped.
+func ParseEnvFile(path string) ([]EnvVar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening env file: %w", err)
+	}
+	defer f.Close()
+
+	var vars []EnvVar
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		v, err := ParseEnvVar(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		vars = append(vars, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading env file: %w", err)
+	}
+
+	return vars, nil
+}
+
+// ResolveEnv resolves vault references and returns the variables in the NAME=VALUE form of os.Environ.
+func ResolveEnv(ctx context.Context, resolver *SecretResolver, vars []EnvVar) ([]string, error) {
+	env := make([]string, 0, len(vars))
+	for _, v := range vars {
+		value := v.Value
+		if IsSecretRef(value) {
+			ref, err := ParseSecretRef(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", v.Name, err)
+			}
+			if value, err = resolver.Resolve(ctx, ref); err != nil {
+				return nil, fmt.Errorf("%s: %w", v.Name, err)
+			}
+		}
+		env = append(env, v.Name+"="+value)
+	}
+
+	return env, nil
+}
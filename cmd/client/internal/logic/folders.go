@@ -0,0 +1,193 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/cache"
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+var ErrFolderNotFound = errors.New("folder not found")
+
+// ListFolders requests user folders and caches them locally. When the server is unreachable
+// the cached folders are returned, so that folder paths still resolve offline.
+func ListFolders(ctx context.Context) ([]models.Folder, error) {
+	folders, err := fetchFolders(ctx)
+	if err != nil && isOffline(err) {
+		if cached, cacheErr := loadCachedFolders(); cacheErr == nil {
+			return cached, nil
+		}
+	}
+
+	return folders, err
+}
+
+// fetchFolders reads folders from the server and refreshes the cached copy.
+func fetchFolders(ctx context.Context) ([]models.Folder, error) {
+	folders := make([]models.Folder, 0)
+	if _, err := apiCall(ctx, http.MethodGet, "api/user/folders", nil, nil, &folders, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	if err := saveCachedFolders(folders); err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+// ResolveFolder finds the id of the folder with a slash separated path. Empty path is the root.
+func ResolveFolder(folders []models.Folder, path string) (uint64, error) {
+	path = strings.Trim(path, models.PathSeparator)
+	if path == "" {
+		return models.RootFolderID, nil
+	}
+
+	for _, f := range folders {
+		if f.Path == path {
+			return f.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrFolderNotFound, path)
+}
+
+// FolderPath returns the path of the folder with id, empty for the root folder.
+func FolderPath(folders []models.Folder, id uint64) string {
+	for _, f := range folders {
+		if f.ID == id {
+			return f.Path
+		}
+	}
+
+	return ""
+}
+
+// CreateFolder creates the folder with every missing parent of path.
+func CreateFolder(ctx context.Context, path string) (*models.Folder, error) {
+	folders, err := ListFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		parent  = models.RootFolderID
+		current string
+		created *models.Folder
+	)
+	for _, name := range strings.Split(strings.Trim(path, models.PathSeparator), models.PathSeparator) {
+		if current != "" {
+			current += models.PathSeparator
+		}
+		current += name
+
+		if id, err := ResolveFolder(folders, current); err == nil {
+			parent = id
+			continue
+		}
+
+		created = &models.Folder{}
+		req := models.FolderRequest{Name: name, ParentID: parent}
+		if _, err := apiCall(ctx, http.MethodPost, "api/user/folders", nil, req, created, http.StatusCreated); err != nil {
+			return nil, err
+		}
+		created.Path = current
+		folders = append(folders, *created)
+		parent = created.ID
+	}
+
+	if created == nil {
+		return nil, fmt.Errorf("folder already exists: %s", path)
+	}
+
+	return created, saveCachedFolders(folders)
+}
+
+// MoveFolder renames the folder at path and places it under the folder at newPath's parent.
+func MoveFolder(ctx context.Context, path string, newPath string) error {
+	folders, err := ListFolders(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := ResolveFolder(folders, path)
+	if err != nil {
+		return err
+	}
+	if id == models.RootFolderID {
+		return fmt.Errorf("root folder cannot be moved")
+	}
+
+	parentPath, name := models.SplitPath(newPath)
+	parentID, err := ResolveFolder(folders, parentPath)
+	if err != nil {
+		return err
+	}
+
+	req := models.FolderRequest{Name: name, ParentID: parentID}
+	_, err = apiCall(ctx, http.MethodPut, "api/user/folders/"+strconv.FormatUint(id, 10), nil, req, nil, http.StatusOK)
+	return err
+}
+
+// DeleteFolder removes the folder at path, its content is moved into the parent folder.
+func DeleteFolder(ctx context.Context, path string) error {
+	folders, err := ListFolders(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := ResolveFolder(folders, path)
+	if err != nil {
+		return err
+	}
+	if id == models.RootFolderID {
+		return fmt.Errorf("root folder cannot be deleted")
+	}
+
+	_, err = apiCall(ctx, http.MethodDelete, "api/user/folders/"+strconv.FormatUint(id, 10), nil, nil, nil, http.StatusNoContent)
+	return err
+}
+
+// MoveRecord places the record at recordPath into the folder at folderPath.
+func MoveRecord(ctx context.Context, recordPath string, folderPath string) error {
+	record, err := GetRecord(ctx, recordPath)
+	if err != nil {
+		return err
+	}
+
+	folders, err := ListFolders(ctx)
+	if err != nil {
+		return err
+	}
+
+	folderID, err := ResolveFolder(folders, folderPath)
+	if err != nil {
+		return err
+	}
+
+	req := models.MoveRecordsRequest{IDs: []uint64{record.ID}, FolderID: folderID}
+	_, err = apiCall(ctx, http.MethodPost, "api/user/records/move", nil, req, nil, http.StatusNoContent)
+	return err
+}
+
+func loadCachedFolders() ([]models.Folder, error) {
+	var folders []models.Folder
+	err := withCache(func(c *cache.Cache) error {
+		var err error
+		folders, err = c.Folders()
+		return err
+	})
+
+	return folders, err
+}
+
+func saveCachedFolders(folders []models.Folder) error {
+	return withCache(func(c *cache.Cache) error {
+		return c.SetFolders(folders)
+	})
+}
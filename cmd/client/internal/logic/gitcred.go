@@ -0,0 +1,252 @@
+package logic
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"go.uber.org/zap"
+)
+
+// GitCredential is a request or a reply of the git credential helper protocol,
+// see gitcredentials(7). Attributes unknown to the helper are ignored.
+type GitCredential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+}
+
+// ReadGitCredential reads key=value lines until an empty line or EOF.
+func ReadGitCredential(r io.Reader) (*GitCredential, error) {
+	cred := &GitCredential{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad credential line %q", line)
+		}
+
+		switch key {
+		case "protocol":
+			cred.Protocol = value
+		case "host":
+			cred.Host = value
+		case "path":
+			cred.Path = value
+		case "username":
+			cred.Username = value
+		case "password":
+			cred.Password = value
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing url: %w", err)
+			}
+			cred.Protocol, cred.Host, cred.Path = u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				cred.Username = u.User.Username()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading credential: %w", err)
+	}
+
+	return cred, nil
+}
+
+// Write prints username and password in the protocol format.
+func (c *GitCredential) Write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "username=%s\npassword=%s\n", c.Username, c.Password)
+	return err
+}
+
+// URL is stored in the record metadata, so the record can be found by the next request.
+func (c *GitCredential) URL() string {
+	u := url.URL{Scheme: c.Protocol, Host: c.Host, Path: c.Path}
+	if c.Path != "" {
+		u.Path = "/" + c.Path
+	}
+	return u.String()
+}
+
+// GitCredentialHelper answers the get, store and erase actions of the git credential helper
+// protocol. The request is read from in, the reply of get is written to out. Git ignores
+// unknown actions of helpers, so do we.
+func GitCredentialHelper(ctx context.Context, logger *zap.SugaredLogger, action string, in io.Reader, out io.Writer) error {
+	return gitCredentialHelper(ctx, apiRecords{logger: logger}, action, in, out)
+}
+
+func gitCredentialHelper(ctx context.Context, records credentialRecords, action string, in io.Reader, out io.Writer) error {
+	cred, err := ReadGitCredential(in)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "get":
+		record, err := findGitCredential(ctx, records, cred)
+		if err != nil || record == nil {
+			return err
+		}
+
+		if cred.Username, err = RecordField(record, "login"); err != nil {
+			return err
+		}
+		if cred.Password, err = RecordField(record, "password"); err != nil {
+			return err
+		}
+		return cred.Write(out)
+	case "store":
+		return storeGitCredential(ctx, records, cred)
+	case "erase":
+		return eraseGitCredential(ctx, records, cred)
+	default:
+		return nil
+	}
+}
+
+// findGitCredential returns the PASS record that matches the request best, or nil if there is none.
+func findGitCredential(ctx context.Context, records credentialRecords, cred *GitCredential) (*models.DataRecord, error) {
+	list, err := records.List(ctx, RecordsFilter{Type: string(models.PASS)})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		best      *models.DataRecord
+		bestScore int
+	)
+	for i := range list {
+		if score := gitCredentialScore(&list[i], cred); score > bestScore {
+			best, bestScore = &list[i], score
+		}
+	}
+
+	return best, nil
+}
+
+// storeGitCredential saves credentials approved by git. The password of a record with the same
+// protocol, host and username is updated, a record of the requested path is preferred.
+// Otherwise a new record is created in the root folder.
+func storeGitCredential(ctx context.Context, records credentialRecords, cred *GitCredential) error {
+	if cred.Host == "" || cred.Username == "" || cred.Password == "" {
+		return nil
+	}
+
+	record, err := findGitCredential(ctx, records, cred)
+	if err == nil && record == nil && cred.Path != "" {
+		record, err = findGitCredential(ctx, records, &GitCredential{
+			Protocol: cred.Protocol,
+			Host:     cred.Host,
+			Username: cred.Username,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	data := cred.Username + ":" + cred.Password
+	if record != nil {
+		if record.Data == data {
+			return nil
+		}
+
+		return records.Upload(ctx, models.DataRecordRequest{
+			ID:       record.ID,
+			Type:     record.Type,
+			Name:     record.Name,
+			Data:     data,
+			Metadata: record.Metadata,
+			FolderID: record.FolderID,
+		})
+	}
+
+	name := strings.ReplaceAll(strings.Trim(cred.Host+"/"+cred.Path, "/"), models.PathSeparator, "-")
+	return records.Upload(ctx, models.DataRecordRequest{
+		Type:     models.PASS,
+		Name:     fmt.Sprintf("git %s %s", name, cred.Username),
+		Data:     data,
+		Metadata: models.Metadata{models.MetaURL: cred.URL()},
+		FolderID: models.RootFolderID,
+	})
+}
+
+// eraseGitCredential moves the matching record to the trash. Git sends the rejected password,
+// so a record that was changed meanwhile is kept.
+func eraseGitCredential(ctx context.Context, records credentialRecords, cred *GitCredential) error {
+	record, err := findGitCredential(ctx, records, cred)
+	if err != nil || record == nil {
+		return err
+	}
+
+	if login, password, _ := strings.Cut(record.Data, ":"); cred.Password != "" &&
+		(login != cred.Username || password != cred.Password) {
+		return nil
+	}
+
+	return records.Delete(ctx, record)
+}
+
+// gitCredentialScore is zero when the record does not match the request host, protocol or username.
+// Records bound to a longer path prefix score higher.
+func gitCredentialScore(record *models.DataRecord, cred *GitCredential) int {
+	raw := record.Metadata[models.MetaURL]
+	if raw == "" {
+		return 0
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return 0
+	}
+	protocol := cred.Protocol
+	if protocol == "" {
+		protocol = u.Scheme
+	}
+	if !strings.EqualFold(u.Scheme, protocol) || gitHost(u.Scheme, u.Host) != gitHost(protocol, cred.Host) {
+		return 0
+	}
+
+	login, _, _ := strings.Cut(record.Data, ":")
+	if cred.Username != "" && login != cred.Username {
+		return 0
+	}
+
+	score := 1
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if cred.Path != "" && !strings.HasPrefix(strings.Trim(cred.Path, "/")+"/", path+"/") {
+			return 0
+		}
+		score += len(path)
+	}
+
+	return score
+}
+
+// defaultGitPorts are dropped from hosts, so example.com:443 matches example.com over https.
+var defaultGitPorts = map[string]string{"https": "443", "http": "80", "ssh": "22", "git": "9418"}
+
+// gitHost lower-cases host and drops the default port of protocol.
+func gitHost(protocol string, host string) string {
+	host = strings.ToLower(host)
+	if port, ok := defaultGitPorts[strings.ToLower(protocol)]; ok {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+
+	return host
+}
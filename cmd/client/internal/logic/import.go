@@ -0,0 +1,84 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/importer"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"go.uber.org/zap"
+)
+
+const importBatchSize = 200
+
+// ImportFailure is an entry the server refused to store.
+type ImportFailure struct {
+	Err  error
+	Name string
+}
+
+// ImportReport summarizes an import run.
+type ImportReport struct {
+	Uploaded []*models.DataRecord
+	Failed   []ImportFailure
+}
+
+// ParseImportFile parses a password manager export from path.
+func ParseImportFile(format importer.Format, path string) (*importer.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening import file: %w", err)
+	}
+	defer f.Close()
+
+	return importer.Parse(format, f)
+}
+
+// ImportRecords uploads parsed entries with best-effort batches and caches the stored records locally.
+func ImportRecords(ctx context.Context, logger *zap.SugaredLogger, entries []importer.Entry) (*ImportReport, error) {
+	report := &ImportReport{}
+
+	for start := 0; start < len(entries); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		batch := models.BatchRequest{
+			Mode:       models.BatchBestEffort,
+			Operations: make([]models.BatchOperation, 0, end-start),
+		}
+		for _, e := range entries[start:end] {
+			batch.Operations = append(batch.Operations, models.BatchOperation{
+				Op: models.BatchUpsert,
+				Record: &models.DataRecordRequest{
+					Type:     e.Type,
+					Name:     e.Name,
+					Data:     e.Data,
+					Metadata: e.Metadata,
+				},
+			})
+		}
+
+		res, err := BatchRecords(ctx, batch)
+		if err != nil {
+			return report, err
+		}
+
+		for _, r := range res.Results {
+			if r.Error != "" || r.Record == nil {
+				report.Failed = append(report.Failed, ImportFailure{Name: r.Name, Err: errors.New(r.Error)})
+				continue
+			}
+
+			report.Uploaded = append(report.Uploaded, r.Record)
+			if err := SaveOrUpdateData(logger, r.Record); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, nil
+}
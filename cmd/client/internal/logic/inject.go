@@ -0,0 +1,60 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// RenderTemplate executes a text/template where {{ gk "folder/record" "field" }} is replaced
+// with the record field from the vault. Nothing is returned unless every reference resolves.
+func RenderTemplate(ctx context.Context, resolver *SecretResolver, name string, text string) ([]byte, error) {
+	funcs := template.FuncMap{
+		"gk": func(path string, field string) (string, error) {
+			return resolver.Resolve(ctx, SecretRef{Path: path, Field: field})
+		},
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("error rendering template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteSecretFile writes data readable by the owner only. The file is replaced atomically,
+// so readers never see partial output.
+func WriteSecretFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error setting permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	return nil
+}
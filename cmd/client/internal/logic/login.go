@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -13,30 +14,48 @@ import (
 	"go.uber.org/zap"
 )
 
+var (
+	ErrEmptyCredentials      = errors.New("login and password must not be empty")
+	ErrPasswordResetRequired = errors.New("password reset required, ask the server operator for a reset code and run reset-password")
+	ErrResetCodeInvalid      = errors.New("reset code is invalid or expired")
+)
+
 type LoginReq struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	CSR      string `json:"csr,omitempty"`
 }
 
-func Login(ctx context.Context, logger *zap.SugaredLogger, login string, password string) (creds *models.TokenResponse, err error) {
+// Login gets a token. The device is enrolled at once: servers with mTLS return a client certificate,
+// which is returned with its key, the token works only together with it.
+func Login(ctx context.Context, logger *zap.SugaredLogger, login string, password string) (creds *models.TokenResponse, deviceKey string, err error) {
+	if login == "" || password == "" {
+		return nil, "", ErrEmptyCredentials
+	}
+
 	httpclient := client.GetHTTPClient()
 	if httpclient == nil {
-		return nil, fmt.Errorf("configuration error")
+		return nil, "", fmt.Errorf("configuration error")
 	}
 	endpoint, _ := url.JoinPath(httpclient.APIURL, "api/user/login")
 
-	b, _ := json.Marshal(LoginReq{Login: login, Password: password})
+	csr, deviceKey, err := newDeviceKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	b, _ := json.Marshal(LoginReq{Login: login, Password: password, CSR: csr})
 
 	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(b))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	request.Header.Add("Content-Type", "application/json")
 
 	response, err := httpclient.Do(request)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	defer func() {
@@ -45,14 +64,65 @@ func Login(ctx context.Context, logger *zap.SugaredLogger, login string, passwor
 		}
 	}()
 
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error in Login")
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return nil, "", ErrPasswordResetRequired
+	default:
+		return nil, "", fmt.Errorf("Error in Login")
 	}
 
 	creds = &models.TokenResponse{}
 	if err = json.NewDecoder(response.Body).Decode(creds); err != nil {
-		return nil, fmt.Errorf("error decode body: %w", err)
+		return nil, "", fmt.Errorf("error decode body: %w", err)
+	}
+
+	if creds.Certificate == "" {
+		return creds, "", nil
+	}
+
+	return creds, deviceKey, nil
+}
+
+// ResetPassword sets a new password with a one-time code issued by the server operator.
+// Accounts registered while the server ignored passwords have to be reset before login.
+func ResetPassword(ctx context.Context, logger *zap.SugaredLogger, login string, code string, password string) error {
+	if login == "" || password == "" {
+		return ErrEmptyCredentials
+	}
+
+	httpclient := client.GetHTTPClient()
+	if httpclient == nil {
+		return fmt.Errorf("configuration error")
 	}
+	endpoint, _ := url.JoinPath(httpclient.APIURL, "api/user/password/reset")
 
-	return creds, nil
+	b, _ := json.Marshal(models.PasswordResetRequest{Login: login, Code: code, Password: password})
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Add("Content-Type", "application/json")
+
+	response, err := httpclient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			logger.Errorf("error: %v", err)
+		}
+	}()
+
+	switch response.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusForbidden:
+		return ErrResetCodeInvalid
+	default:
+		return fmt.Errorf("error resetting password: %s", response.Status)
+	}
 }
@@ -0,0 +1,100 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"github.com/rawen554/goph-keeper/internal/otp"
+)
+
+// hotpAttempts bounds retries of counter advances racing with other devices.
+const hotpAttempts = 5
+
+// OTPCode is a generated one-time password. Remaining is zero for counter based codes.
+type OTPCode struct {
+	Code      string
+	Kind      otp.Kind
+	Remaining time.Duration
+	Counter   uint64
+}
+
+// GenerateOTP computes the current code of the OTP record at path. For HOTP records the
+// counter is advanced on the server before the code is returned, so a code is never reused.
+func GenerateOTP(ctx context.Context, path string) (*OTPCode, error) {
+	record, err := GetRecord(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if record.Type != models.OTP {
+		return nil, fmt.Errorf("record %s is %s, not %s", record.Name, record.Type, models.OTP)
+	}
+
+	for attempt := 1; ; attempt++ {
+		key, err := otp.ParseURI(record.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		code, remaining, err := key.Code(time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		res := &OTPCode{Code: code, Kind: key.Kind, Remaining: remaining, Counter: key.Counter}
+		if key.Kind != otp.HOTP {
+			return res, nil
+		}
+
+		advanced, err := advanceHOTP(ctx, record, key)
+		if err != nil {
+			return nil, err
+		}
+		if advanced {
+			return res, nil
+		}
+		if attempt == hotpAttempts {
+			return nil, fmt.Errorf("error advancing hotp counter: record %s keeps changing", record.Name)
+		}
+
+		// another device has advanced the counter, its code is taken
+		if record, err = fetchRecord(ctx, path); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// advanceHOTP stores the next counter unless the record has changed since it was read.
+func advanceHOTP(ctx context.Context, record *models.DataRecord, key *otp.Key) (bool, error) {
+	key.Counter++
+	batch := models.BatchRequest{
+		Mode: models.BatchAtomic,
+		Operations: []models.BatchOperation{{
+			Op: models.BatchUpsert,
+			Record: &models.DataRecordRequest{
+				Type:     record.Type,
+				Name:     record.Name,
+				Data:     key.URI(),
+				Metadata: record.Metadata,
+				FolderID: record.FolderID,
+			},
+			IfChecksum: record.Checksum,
+		}},
+	}
+
+	updated, err := BatchRecords(ctx, batch)
+	if err != nil {
+		return false, fmt.Errorf("error advancing hotp counter: %w", err)
+	}
+	if !updated.Applied {
+		if updated.Results[0].Status == http.StatusPreconditionFailed {
+			return false, nil
+		}
+		return false, fmt.Errorf("error advancing hotp counter: %s", updated.Results[0].Error)
+	}
+
+	return true, nil
+}
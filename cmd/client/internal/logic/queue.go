@@ -0,0 +1,206 @@
+package logic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/cache"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"go.uber.org/zap"
+)
+
+// ErrQueued is returned when the server is unreachable and the change waits in the pending queue.
+var ErrQueued = errors.New("server is unreachable, the change is queued")
+
+// newIdempotencyKey generates a random key, so the server applies a replayed operation only once.
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating idempotency key: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// queueUpsert journals the record upload and caches the local copy. ErrQueued is returned with the record.
+func queueUpsert(req models.DataRecordRequest) (*models.DataRecord, error) {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.DataRecord{
+		Data:     req.Data,
+		Checksum: req.Checksum,
+		Type:     req.Type,
+		Name:     req.Name,
+		Metadata: req.Metadata,
+		FolderID: req.FolderID,
+	}
+	op := models.BatchOperation{Op: models.BatchUpsert, Record: &req, IdempotencyKey: key}
+
+	err = withCache(func(c *cache.Cache) error {
+		if _, err := c.Enqueue(op); err != nil {
+			return err
+		}
+		return c.PutRecord(record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error queueing upload: %w", err)
+	}
+
+	return record, ErrQueued
+}
+
+// queueDelete journals the record deletion and drops the cached copy.
+func queueDelete(record *models.DataRecord) error {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+
+	op := models.BatchOperation{Op: models.BatchDelete, Name: record.Name, FolderID: record.FolderID, IdempotencyKey: key}
+	err = withCache(func(c *cache.Cache) error {
+		if _, err := c.Enqueue(op); err != nil {
+			return err
+		}
+		return c.DeleteRecord(record)
+	})
+	if err != nil {
+		return fmt.Errorf("error queueing deletion: %w", err)
+	}
+
+	return ErrQueued
+}
+
+// PendingOperations lists changes waiting to be sent to the server.
+func PendingOperations() ([]cache.PendingOp, error) {
+	var ops []cache.PendingOp
+	err := withCache(func(c *cache.Cache) error {
+		var err error
+		ops, err = c.Pending()
+		return err
+	})
+
+	return ops, err
+}
+
+// ReplayPending sends queued changes in the order they were made and returns the number of
+// operations removed from the queue. Operations rejected by the server are dropped with a warning,
+// the ones failed by a server error stay queued and stop the replay.
+func ReplayPending(ctx context.Context, logger *zap.SugaredLogger) (int, error) {
+	ops, err := PendingOperations()
+	if err != nil || len(ops) == 0 {
+		return 0, err
+	}
+
+	done := 0
+	for start := 0; start < len(ops); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(ops) {
+			end = len(ops)
+		}
+		chunk := ops[start:end]
+
+		batch := models.BatchRequest{Mode: models.BatchBestEffort, Operations: make([]models.BatchOperation, len(chunk))}
+		for i := range chunk {
+			batch.Operations[i] = chunk[i].Operation
+		}
+
+		res, err := BatchRecords(ctx, batch)
+		if err != nil {
+			return done, err
+		}
+
+		acked := make([]uint64, 0, len(chunk))
+		applied := make([]models.BatchItemResult, 0, len(chunk))
+		retry := false
+		for _, r := range res.Results {
+			op := chunk[r.Index]
+			switch {
+			case r.Status == http.StatusOK:
+				applied = append(applied, r)
+			case r.Status == http.StatusNotFound && op.Operation.Op == models.BatchDelete:
+				// Already deleted on the server.
+			case r.Status >= http.StatusInternalServerError:
+				retry = true
+				continue
+			default:
+				logger.Warnf("dropping queued %s of %s: %s", op.Operation.Op, r.Name, r.Error)
+				if op.Operation.Op == models.BatchUpsert && !queuedLater(ops, start+r.Index) {
+					applied = append(applied, r)
+				}
+			}
+			acked = append(acked, op.Seq)
+		}
+
+		// Cached copies are updated in the order of operations, so a record uploaded
+		// and deleted while offline does not come back. The local copies of rejected
+		// uploads are removed as well.
+		err = withCache(func(c *cache.Cache) error {
+			for _, r := range applied {
+				op := chunk[r.Index].Operation
+				var err error
+				switch {
+				case r.Record != nil:
+					err = c.PutRecord(r.Record)
+				case op.Op == models.BatchDelete:
+					err = c.DeleteRecord(&models.DataRecord{Name: op.Name, FolderID: op.FolderID})
+				case op.Op == models.BatchUpsert:
+					err = dropPlaceholder(c, op.Record)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return c.Ack(acked...)
+		})
+		if err != nil {
+			return done, err
+		}
+
+		done += len(acked)
+		if retry {
+			return done, fmt.Errorf("server failed to apply some queued changes, they will be retried")
+		}
+	}
+
+	return done, nil
+}
+
+// queuedLater reports whether an operation after ops[i] changes the same record, so the cached
+// copy belongs to that operation.
+func queuedLater(ops []cache.PendingOp, i int) bool {
+	name, folderID := ops[i].Operation.Record.Name, ops[i].Operation.Record.FolderID
+	for _, op := range ops[i+1:] {
+		switch {
+		case op.Operation.Record != nil && op.Operation.Record.Name == name && op.Operation.Record.FolderID == folderID,
+			op.Operation.Record == nil && op.Operation.Name == name && op.Operation.FolderID == folderID:
+			return true
+		}
+	}
+
+	return false
+}
+
+// dropPlaceholder removes the cached copy of a rejected upload. Copies fetched from the server
+// have an id and are left alone.
+func dropPlaceholder(c *cache.Cache, req *models.DataRecordRequest) error {
+	folderID := req.FolderID
+	record, err := c.Record(req.Name, &folderID)
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if record.ID != 0 {
+		return nil
+	}
+
+	return c.DeleteRecord(record)
+}
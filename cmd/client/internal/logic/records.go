@@ -10,108 +10,121 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/rawen554/goph-keeper/cmd/client/internal/client"
+	"github.com/rawen554/goph-keeper/cmd/client/internal/cache"
 	"github.com/rawen554/goph-keeper/internal/models"
-	"github.com/rawen554/goph-keeper/internal/utils"
-	"github.com/spf13/viper"
+	"github.com/rawen554/goph-keeper/internal/otp"
 	"go.uber.org/zap"
-	"golang.org/x/sync/errgroup"
 )
 
+var ErrRecordNotFound = errors.New("record not found")
+
+// RemoveLocalData deletes the cached copy of a record.
+func RemoveLocalData(data *models.DataRecord) error {
+	return withCache(func(c *cache.Cache) error {
+		return c.DeleteRecord(data)
+	})
+}
+
+// SaveOrUpdateData stores the record in the local cache.
 func SaveOrUpdateData(logger *zap.SugaredLogger, data *models.DataRecord) error {
-	login := viper.GetString("login")
-	if login == "" {
-		err := fmt.Errorf("not logged in")
+	err := withCache(func(c *cache.Cache) error {
+		return c.PutRecord(data)
+	})
+	if err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	if err := utils.CreateUsersDir(login); err != nil {
-		err = fmt.Errorf("error creating users dir: %w", err)
-		logger.Error(err)
-		return err
+	return nil
+}
+
+// GetRecord requests a record by its path. "work/aws/root" addresses the record "root"
+// in the folder "work/aws", a bare name matches the record in any folder.
+// The cached copy is returned when the server is unreachable.
+func GetRecord(ctx context.Context, path string) (*models.DataRecord, error) {
+	record, err := fetchRecord(ctx, path)
+	if err != nil && isOffline(err) {
+		if cached, cacheErr := cachedRecord(path); cacheErr == nil {
+			return cached, nil
+		}
 	}
 
-	ext := utils.GetExtension(data.Type)
-	filename := fmt.Sprintf("%s%s", data.Name, ext)
-	filepath := filepath.Join(".", login, filename)
-	localFile, err := os.OpenFile(filepath, os.O_RDONLY, 0600)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			wrLocalFile, err := os.OpenFile(filepath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	return record, err
+}
+
+// cachedRecord resolves the record path over the cached folders and records.
+func cachedRecord(path string) (*models.DataRecord, error) {
+	var record *models.DataRecord
+	err := withCache(func(c *cache.Cache) error {
+		folderPath, name := models.SplitPath(path)
+
+		var folderID *uint64
+		if folderPath != "" {
+			folders, err := c.Folders()
 			if err != nil {
 				return err
 			}
-
-			defer wrLocalFile.Close()
-
-			if err := json.NewEncoder(wrLocalFile).Encode(data); err != nil {
+			id, err := ResolveFolder(folders, folderPath)
+			if err != nil {
 				return err
 			}
-			return nil
+			folderID = &id
 		}
-		return err
-	}
 
-	var localData models.DataRecord
-	if err := json.NewDecoder(localFile).Decode(&localData); err != nil {
+		var err error
+		record, err = c.Record(name, folderID)
 		return err
+	})
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil, ErrRecordNotFound
 	}
 
-	if err := localFile.Close(); err != nil {
-		return err
-	}
-
-	wrLocalFile, err := os.OpenFile(filepath, os.O_RDWR, 0600)
-
-	if data.ID != 0 && localData.ID == 0 {
-		wrLocalFile.Truncate(0)
-		if err := json.NewEncoder(wrLocalFile).Encode(data); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return record, err
 }
 
-func GetRecord(ctx context.Context, name string) (*models.DataRecord, error) {
-	token := viper.GetString("token")
-	if token == "" {
-		return nil, fmt.Errorf("No auth data, login first")
-	}
-
-	httpclient := client.GetHTTPClient()
-	if httpclient == nil {
-		return nil, fmt.Errorf("configuration error")
-	}
-	endpoint, _ := url.JoinPath(httpclient.APIURL, "api/user/records", name)
-
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+func fetchRecord(ctx context.Context, path string) (*models.DataRecord, error) {
+	name, query, err := recordQuery(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 
-	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-
-	response, err := httpclient.Do(request)
+	var record models.DataRecord
+	status, err := apiCall(ctx, http.MethodGet, "api/user/records/"+url.PathEscape(name), query, nil, &record,
+		http.StatusOK)
+	if status == http.StatusNotFound {
+		return nil, ErrRecordNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error: %w", err)
 	}
 
-	if response.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("record not found")
-	}
+	return &record, nil
+}
 
-	var record models.DataRecord
-	if err = json.NewDecoder(response.Body).Decode(&record); err != nil {
-		return nil, fmt.Errorf("error decode body: %w", err)
+// recordQuery splits a record path into the name and the folder_id query parameter.
+func recordQuery(ctx context.Context, path string) (string, url.Values, error) {
+	folderPath, name := models.SplitPath(path)
+
+	query := url.Values{}
+	if folderPath != "" {
+		folders, err := ListFolders(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+
+		folderID, err := ResolveFolder(folders, folderPath)
+		if err != nil {
+			return "", nil, err
+		}
+		query.Set("folder_id", strconv.FormatUint(folderID, 10))
 	}
 
-	return &record, nil
+	return name, query, nil
 }
 
 func PutRecord(ctx context.Context, args []string) (*models.DataRecord, error) {
@@ -125,6 +138,20 @@ func PutRecord(ctx context.Context, args []string) (*models.DataRecord, error) {
 	switch dataType {
 	case "pass":
 		data = args[1]
+	case "otp":
+		key, err := otp.ParseKey(args[1])
+		if err != nil {
+			return nil, err
+		}
+		data = key.URI()
+	case "sshkey":
+		key, err := ReadSSHKeyFile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if data, err = key.Encode(); err != nil {
+			return nil, err
+		}
 	default:
 		path := args[1]
 		fi, err := os.Stat(path)
@@ -134,47 +161,73 @@ func PutRecord(ctx context.Context, args []string) (*models.DataRecord, error) {
 		data = fi.Name()
 	}
 
-	token := viper.GetString("token")
-	if token == "" {
-		return nil, fmt.Errorf("No auth data, login first")
+	folderPath, name := models.SplitPath(args[2])
+	folderID := models.RootFolderID
+	if folderPath != "" {
+		folders, err := ListFolders(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if folderID, err = ResolveFolder(folders, folderPath); err != nil {
+			return nil, err
+		}
+	}
+
+	req := models.DataRecordRequest{
+		Type:     models.DataType(strings.ToUpper(dataType)),
+		Name:     name,
+		Data:     data,
+		FolderID: folderID,
 	}
 
-	httpclient := client.GetHTTPClient()
-	if httpclient == nil {
-		return nil, fmt.Errorf("configuration error")
+	record, err := UploadRecord(ctx, req)
+	if err != nil && isOffline(err) {
+		return queueUpsert(req)
 	}
-	endpoint, _ := url.JoinPath(httpclient.APIURL, "api/user/records")
 
-	checksum := fmt.Sprintf("%x", md5.Sum([]byte(data)))
+	return record, err
+}
 
-	dataObj := models.DataRecordRequest{
-		Type:     models.DataType(dataType),
-		Name:     args[2],
-		Data:     data,
-		Checksum: checksum,
+// UploadRecord sends a single record to the server. On a network failure the record
+// built from dataObj is returned together with the error, so it can be kept locally.
+func UploadRecord(ctx context.Context, dataObj models.DataRecordRequest) (*models.DataRecord, error) {
+	session, err := currentSession()
+	if err != nil {
+		return nil, err
 	}
+
+	if dataObj.Checksum == "" {
+		dataObj.Checksum = fmt.Sprintf("%x", md5.Sum([]byte(dataObj.Data)))
+	}
+
 	dataObjB, err := json.Marshal(dataObj)
 	if err != nil {
 		return nil, err
 	}
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(dataObjB))
+	request, err := session.newRequest(ctx, http.MethodPost, "api/user/records", nil, bytes.NewBuffer(dataObjB))
 	if err != nil {
 		return nil, err
 	}
 
 	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 
-	response, err := httpclient.Do(request)
+	response, err := session.client.Do(request)
 	if err != nil {
 		return &models.DataRecord{
 			Data:     dataObj.Data,
 			Checksum: dataObj.Checksum,
 			Type:     dataObj.Type,
 			Name:     dataObj.Name,
+			Metadata: dataObj.Metadata,
+			FolderID: dataObj.FolderID,
 		}, err
 	}
+	defer response.Body.Close()
 
+	if response.StatusCode == http.StatusConflict {
+		return nil, fmt.Errorf("record with the same name already exists")
+	}
 	if response.StatusCode != http.StatusCreated {
 		return nil, fmt.Errorf("error in Post data")
 	}
@@ -187,38 +240,142 @@ func PutRecord(ctx context.Context, args []string) (*models.DataRecord, error) {
 	return &record, nil
 }
 
-func ListRecords(ctx context.Context, logger *zap.SugaredLogger) ([]models.DataRecord, error) {
-	token := viper.GetString("token")
-	if token == "" {
-		err := fmt.Errorf("no auth data, login first")
-		logger.Error(err)
+// RecordsFilter narrows records listing. Empty fields are not applied.
+type RecordsFilter struct {
+	UpdatedSince time.Time
+	Type         string
+	Tag          string
+	Name         string
+	Folder       string
+}
+
+// ListRecords requests every page of user records matching filter.
+func ListRecords(ctx context.Context, logger *zap.SugaredLogger, filter RecordsFilter) ([]models.DataRecord, error) {
+	records, err := fetchRecords(ctx, logger, filter)
+	if err != nil && isOffline(err) {
+		logger.Warnf("server is unreachable, listing cached records: %v", err)
+		return cachedRecords(filter)
+	}
+
+	return records, err
+}
+
+// fetchRecords reads every page of the listing from the server.
+func fetchRecords(ctx context.Context, logger *zap.SugaredLogger, filter RecordsFilter) ([]models.DataRecord, error) {
+	records := make([]models.DataRecord, 0)
+	cursor := ""
+	for {
+		page, err := listRecordsPage(ctx, logger, filter, cursor)
+		if err != nil {
+			return nil, err
+		}
+		if page == nil {
+			logger.Infoln("no records found")
+			return records, nil
+		}
+
+		records = append(records, page.Records...)
+		if page.NextCursor == "" {
+			return records, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// cachedRecords applies the filter to the cached records the way the server does.
+func cachedRecords(filter RecordsFilter) ([]models.DataRecord, error) {
+	var (
+		records []models.DataRecord
+		folders []models.Folder
+	)
+	err := withCache(func(c *cache.Cache) error {
+		var err error
+		if records, err = c.Records(); err != nil {
+			return err
+		}
+		folders, err = c.Folders()
+		return err
+	})
+	if err != nil && !errors.Is(err, cache.ErrNotFound) {
 		return nil, err
 	}
 
-	httpclient := client.GetHTTPClient()
-	if httpclient == nil {
-		err := fmt.Errorf("configuration error")
+	var folderID *uint64
+	if filter.Folder != "" {
+		id, err := ResolveFolder(folders, filter.Folder)
+		if err != nil {
+			return nil, err
+		}
+		folderID = &id
+	}
+
+	res := make([]models.DataRecord, 0, len(records))
+	for _, r := range records {
+		switch {
+		case filter.Type != "" && !strings.EqualFold(string(r.Type), filter.Type),
+			filter.Name != "" && !strings.HasPrefix(r.Name, filter.Name),
+			filter.Tag != "" && !r.Metadata.HasTag(filter.Tag),
+			folderID != nil && r.FolderID != *folderID,
+			!filter.UpdatedSince.IsZero() && !r.UpdatedAt.After(filter.UpdatedSince):
+			continue
+		}
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+	return res, nil
+}
+
+func listRecordsPage(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	filter RecordsFilter,
+	cursor string,
+) (*models.RecordsPage, error) {
+	session, err := currentSession()
+	if err != nil {
 		logger.Error(err)
 		return nil, err
 	}
-	endpoint, _ := url.JoinPath(httpclient.APIURL, "api/user/records")
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(models.MaxRecordsLimit))
+	for k, v := range map[string]string{"type": filter.Type, "tag": filter.Tag, "name": filter.Name, "cursor": cursor} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	if !filter.UpdatedSince.IsZero() {
+		query.Set("updated_since", filter.UpdatedSince.Format(time.RFC3339Nano))
+	}
+	if filter.Folder != "" {
+		folders, err := ListFolders(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		folderID, err := ResolveFolder(folders, filter.Folder)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("folder_id", strconv.FormatUint(folderID, 10))
+	}
+
+	request, err := session.newRequest(ctx, http.MethodGet, "api/user/records", query, nil)
 	if err != nil {
 		logger.Error(err)
 		return nil, err
 	}
 
 	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 
-	response, err := httpclient.Do(request)
+	response, err := session.client.Do(request)
 	if err != nil {
 		return nil, err
 	}
+	defer response.Body.Close()
 
 	if response.StatusCode == http.StatusNoContent {
-		logger.Infoln("no records found")
 		return nil, nil
 	}
 
@@ -226,36 +383,79 @@ func ListRecords(ctx context.Context, logger *zap.SugaredLogger) ([]models.DataR
 		return nil, fmt.Errorf("error in listrecords\n")
 	}
 
-	records := make([]models.DataRecord, 0)
-	if err = json.NewDecoder(response.Body).Decode(&records); err != nil {
+	page := &models.RecordsPage{}
+	if err = json.NewDecoder(response.Body).Decode(page); err != nil {
 		return nil, fmt.Errorf("error decode body: %w\n", err)
 	}
 
-	return records, nil
+	return page, nil
 }
 
-func SyncDataRecords(ctx context.Context, logger *zap.SugaredLogger) error {
-	records, err := ListRecords(ctx, logger)
-	if err != nil {
+// syncOverlap is how far back from the sync cursor records are fetched again. A transaction
+// stamps records when it starts, so one committed after a sync may be older than the cursor.
+const syncOverlap = 5 * time.Minute
+
+// SyncDataRecords brings the local cache up to date with the server. The first sync, or a full one,
+// replaces the cache. Later syncs fetch records changed since the sync cursor, less syncOverlap, and drop the ones
+// moved to the trash; records purged from the trash stay cached until the next full sync.
+func SyncDataRecords(ctx context.Context, logger *zap.SugaredLogger, full bool) error {
+	if _, err := fetchFolders(ctx); err != nil {
 		return err
 	}
 
-	g := new(errgroup.Group)
-	for _, r := range records {
-		data := r
-
-		g.Go(func() error {
-			if err := SaveOrUpdateData(logger, &data); err != nil {
-				return err
-			}
-
-			return nil
+	var cursor time.Time
+	if !full {
+		err := withCache(func(c *cache.Cache) error {
+			var err error
+			cursor, err = c.SyncCursor()
+			return err
 		})
+		if err != nil {
+			return err
+		}
 	}
 
-	if err := g.Wait(); err != nil {
+	filter := RecordsFilter{}
+	if !cursor.IsZero() {
+		filter.UpdatedSince = cursor.Add(-syncOverlap)
+	}
+	records, err := fetchRecords(ctx, logger, filter)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	var trash []models.DataRecord
+	if !cursor.IsZero() {
+		if trash, err = ListTrash(ctx); err != nil {
+			return err
+		}
+	}
+
+	next := cursor
+	for _, r := range records {
+		if r.UpdatedAt.After(next) {
+			next = r.UpdatedAt
+		}
+	}
+
+	return withCache(func(c *cache.Cache) error {
+		if cursor.IsZero() {
+			if err := c.ReplaceRecords(records); err != nil {
+				return err
+			}
+		} else {
+			for i := range records {
+				if err := c.PutRecord(&records[i]); err != nil {
+					return err
+				}
+			}
+			for i := range trash {
+				if err := c.DeleteRecord(&trash[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		return c.SetSyncCursor(next)
+	})
 }
@@ -13,25 +13,35 @@ import (
 	"go.uber.org/zap"
 )
 
-func Register(logger *zap.SugaredLogger, login string, password string) (creds *models.TokenResponse, err error) {
+// Register creates the account and enrolls the device like Login does.
+func Register(logger *zap.SugaredLogger, login string, password string) (creds *models.TokenResponse, deviceKey string, err error) {
+	if login == "" || password == "" {
+		return nil, "", ErrEmptyCredentials
+	}
+
 	httpclient := client.GetHTTPClient()
 	if httpclient == nil {
-		return nil, fmt.Errorf("configuration error")
+		return nil, "", fmt.Errorf("configuration error")
 	}
 	endpoint, _ := url.JoinPath(httpclient.APIURL, "api/user/register")
 
-	b, _ := json.Marshal(LoginReq{Login: login, Password: password})
+	csr, deviceKey, err := newDeviceKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	b, _ := json.Marshal(LoginReq{Login: login, Password: password, CSR: csr})
 
 	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(b))
 	if err != nil {
-		return nil, fmt.Errorf("error: %w\n", err)
+		return nil, "", fmt.Errorf("error: %w\n", err)
 	}
 
 	request.Header.Add("Content-Type", "application/json")
 
 	response, err := httpclient.Do(request)
 	if err != nil {
-		return nil, fmt.Errorf("error: %w\n", err)
+		return nil, "", fmt.Errorf("error: %w\n", err)
 	}
 
 	defer func() {
@@ -41,15 +51,19 @@ func Register(logger *zap.SugaredLogger, login string, password string) (creds *
 	}()
 
 	if response.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("Error in Register")
+		return nil, "", fmt.Errorf("Error in Register")
 	}
 
 	creds = &models.TokenResponse{}
 	if err = json.NewDecoder(response.Body).Decode(creds); err != nil {
-		return nil, fmt.Errorf("error decode body: %w\n", err)
+		return nil, "", fmt.Errorf("error decode body: %w\n", err)
 	}
 
 	viper.Set("api", httpclient.APIURL)
 
-	return creds, nil
+	if creds.Certificate == "" {
+		return creds, "", nil
+	}
+
+	return creds, deviceKey, nil
 }
@@ -0,0 +1,189 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/cache"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"go.uber.org/zap"
+)
+
+// SearchHit is a record matched by a search query.
+// Field names the best matching field, Remote is set for records found by the server.
+type SearchHit struct {
+	Field  string
+	Record models.DataRecord
+	Score  int
+	Remote bool
+}
+
+// SearchRecords matches query against names, URLs and tags on the server and against
+// the local cache, where notes and text data are searched too. Secrets never leave the client.
+// When the server is unreachable only the local cache is searched.
+func SearchRecords(ctx context.Context, logger *zap.SugaredLogger, query string) ([]SearchHit, error) {
+	hits := make(map[string]SearchHit)
+
+	local, err := LoadLocalRecords()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range local {
+		if hit, ok := matchRecord(query, r); ok {
+			hits[r.Name] = hit
+		}
+	}
+
+	remote, err := searchRemote(ctx, query)
+	if err != nil {
+		logger.Warnf("server search is unavailable, showing local results: %v", err)
+	}
+	for _, r := range remote {
+		hit, ok := matchRecord(query, r)
+		if !ok {
+			hit = SearchHit{Record: r, Field: "name"}
+		}
+		hit.Remote = true
+		if prev, found := hits[r.Name]; !found || prev.Score < hit.Score {
+			hits[r.Name] = hit
+		} else {
+			prev.Remote = true
+			hits[r.Name] = prev
+		}
+	}
+
+	res := make([]SearchHit, 0, len(hits))
+	for _, h := range hits {
+		res = append(res, h)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Record.Name < res[j].Record.Name
+	})
+
+	return res, nil
+}
+
+// LoadLocalRecords reads every record cached for the logged in user.
+func LoadLocalRecords() ([]models.DataRecord, error) {
+	var records []models.DataRecord
+	err := withCache(func(c *cache.Cache) error {
+		var err error
+		records, err = c.Records()
+		return err
+	})
+
+	return records, err
+}
+
+func searchRemote(ctx context.Context, query string) ([]models.DataRecord, error) {
+	session, err := currentSession()
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := session.newRequest(ctx, http.MethodGet, "api/user/records/search", url.Values{"q": {query}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := session.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(response.Status)
+	}
+
+	records := make([]models.DataRecord, 0)
+	if err := json.NewDecoder(response.Body).Decode(&records); err != nil {
+		return nil, fmt.Errorf("error decode body: %w", err)
+	}
+
+	return records, nil
+}
+
+func matchRecord(query string, r models.DataRecord) (SearchHit, bool) {
+	fields := map[string]string{
+		"name":  r.Name,
+		"url":   r.Metadata[models.MetaURL],
+		"tags":  r.Metadata[models.MetaTags],
+		"notes": r.Metadata[models.MetaNotes],
+	}
+	if r.Type == models.TEXT {
+		fields["data"] = r.Data
+	}
+
+	best := SearchHit{Record: r}
+	for field, text := range fields {
+		if score := fuzzyScore(query, text); score > best.Score {
+			best.Score = score
+			best.Field = field
+		}
+	}
+
+	return best, best.Score > 0
+}
+
+// fuzzyScore rates how well text matches query: substrings score highest, then in-order
+// subsequences with a bonus for consecutive and word-start characters. Zero means no match.
+func fuzzyScore(query string, text string) int {
+	query = strings.ToLower(strings.TrimSpace(query))
+	text = strings.ToLower(text)
+	if query == "" || text == "" {
+		return 0
+	}
+
+	const (
+		substringBonus   = 100
+		prefixBonus      = 50
+		consecutiveBonus = 5
+		wordStartBonus   = 3
+	)
+
+	if i := strings.Index(text, query); i >= 0 {
+		score := substringBonus + utf8.RuneCountInString(query)*consecutiveBonus
+		if i == 0 {
+			score += prefixBonus
+		}
+		return score
+	}
+
+	q := []rune(query)
+	score, qi := 0, 0
+	prevMatched := false
+	prev := ' '
+	for _, ch := range text {
+		if qi < len(q) && ch == q[qi] {
+			score++
+			if prevMatched {
+				score += consecutiveBonus
+			}
+			if strings.ContainsRune(" ./-_:@", prev) {
+				score += wordStartBonus
+			}
+			qi++
+			prevMatched = true
+		} else {
+			prevMatched = false
+		}
+		prev = ch
+	}
+
+	if qi < len(q) {
+		return 0
+	}
+
+	return score
+}
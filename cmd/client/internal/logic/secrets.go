@@ -0,0 +1,185 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"github.com/rawen554/goph-keeper/internal/otp"
+)
+
+// SecretScheme prefixes references to record fields, e.g. gk://work/aws/root/password.
+const SecretScheme = "gk://"
+
+var ErrBadSecretRef = errors.New("secret reference must look like " + SecretScheme + "folder/record/field")
+
+// SecretRef points to a single field of a record.
+type SecretRef struct {
+	Path  string
+	Field string
+}
+
+func (r SecretRef) String() string {
+	return SecretScheme + r.Path + models.PathSeparator + r.Field
+}
+
+// IsSecretRef reports whether s should be resolved from the vault.
+func IsSecretRef(s string) bool {
+	return strings.HasPrefix(s, SecretScheme)
+}
+
+// ParseSecretRef splits gk://folder/record/field into the record path and the field name.
+func ParseSecretRef(s string) (SecretRef, error) {
+	if !IsSecretRef(s) {
+		return SecretRef{}, ErrBadSecretRef
+	}
+
+	path, field := models.SplitPath(strings.TrimPrefix(s, SecretScheme))
+	if path == "" || field == "" {
+		return SecretRef{}, ErrBadSecretRef
+	}
+
+	return SecretRef{Path: path, Field: field}, nil
+}
+
+// SecretResolver resolves references, fetching every record from the server only once.
+// Resolved values are remembered, so callers can mask them in output.
+type SecretResolver struct {
+	records map[string]*models.DataRecord
+	values  map[string]struct{}
+}
+
+func NewSecretResolver() *SecretResolver {
+	return &SecretResolver{
+		records: make(map[string]*models.DataRecord),
+		values:  make(map[string]struct{}),
+	}
+}
+
+// Resolve returns the value of the field the reference points to.
+func (r *SecretResolver) Resolve(ctx context.Context, ref SecretRef) (string, error) {
+	record, ok := r.records[ref.Path]
+	if !ok {
+		var err error
+		if record, err = GetRecord(ctx, ref.Path); err != nil {
+			return "", fmt.Errorf("error resolving %s: %w", ref, err)
+		}
+		r.records[ref.Path] = record
+	}
+
+	var (
+		value string
+		err   error
+	)
+	if record.Type == models.OTP && ref.Field == "code" {
+		// HOTP counter must be advanced on the server, so the code is generated through GenerateOTP.
+		var code *OTPCode
+		if code, err = GenerateOTP(ctx, ref.Path); err == nil {
+			value = code.Code
+		}
+	} else {
+		value, err = RecordField(record, ref.Field)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s: %w", ref, err)
+	}
+
+	if value != "" && isSecretField(record.Type, ref.Field) {
+		r.values[value] = struct{}{}
+	}
+
+	return value, nil
+}
+
+// secretFields lists the fields whose values are concealed in output. Logins, urls, notes
+// and other metadata are shown as they are.
+var secretFields = map[models.DataType][]string{
+	models.PASS:   {"password"},
+	models.CARD:   {"number", "cvv"},
+	models.TEXT:   {"text"},
+	models.OTP:    {"code", "secret", "uri"},
+	models.SSHKEY: {"private_key"},
+}
+
+func isSecretField(dataType models.DataType, field string) bool {
+	for _, f := range secretFields[dataType] {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Secrets returns every secret value resolved so far.
+func (r *SecretResolver) Secrets() []string {
+	secrets := make([]string, 0, len(r.values))
+	for v := range r.values {
+		secrets = append(secrets, v)
+	}
+
+	return secrets
+}
+
+// RecordField extracts a named field from the record data. Metadata keys (url, notes, tags)
+// are available for every record type.
+func RecordField(record *models.DataRecord, field string) (string, error) {
+	switch record.Type {
+	case models.PASS:
+		parts := strings.SplitN(record.Data, ":", 2)
+		switch field {
+		case "login", "username":
+			return parts[0], nil
+		case "password":
+			if len(parts) == 2 {
+				return parts[1], nil
+			}
+			return "", nil
+		}
+	case models.CARD:
+		parts := strings.SplitN(record.Data, ":", 4)
+		for i, name := range []string{"number", "expiry", "cvv", "holder"} {
+			if field == name {
+				if i < len(parts) {
+					return parts[i], nil
+				}
+				return "", nil
+			}
+		}
+	case models.TEXT:
+		if field == "text" {
+			return record.Data, nil
+		}
+	case models.OTP:
+		switch field {
+		case "uri":
+			return record.Data, nil
+		case "secret":
+			key, err := otp.ParseURI(record.Data)
+			if err != nil {
+				return "", err
+			}
+			return key.Secret, nil
+		}
+	case models.SSHKEY:
+		key, err := models.ParseSSHKey(record.Data)
+		if err != nil {
+			return "", err
+		}
+		switch field {
+		case "private_key":
+			return key.PrivateKey, nil
+		case "public_key":
+			return key.PublicKey, nil
+		case "comment":
+			return key.Comment, nil
+		}
+	}
+
+	if value, ok := record.Metadata[field]; ok {
+		return value, nil
+	}
+
+	return "", fmt.Errorf("record %s of type %s has no field %s", record.Name, record.Type, field)
+}
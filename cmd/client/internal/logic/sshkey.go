@@ -0,0 +1,110 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/sshagent"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// ReadSSHKeyFile reads an unencrypted private key and builds the SSHKEY record data.
+// The comment is taken from the neighbouring .pub file when it exists.
+func ReadSSHKeyFile(path string) (*models.SSHKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ssh key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("key is protected by passphrase, remove it with ssh-keygen -p first")
+		}
+		return nil, fmt.Errorf("error parsing ssh key: %w", err)
+	}
+
+	key := &models.SSHKey{
+		PrivateKey: string(pemBytes),
+		PublicKey:  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		Comment:    filepath.Base(path),
+	}
+
+	if pub, err := os.ReadFile(path + ".pub"); err == nil {
+		if _, comment, _, _, err := ssh.ParseAuthorizedKey(pub); err == nil && comment != "" {
+			key.Comment = comment
+		}
+	}
+
+	return key, nil
+}
+
+// NewSSHKeySource loads SSHKEY records from the server for the ssh agent. Keys are cached for ttl
+// and the last loaded keys keep being served while the server is unreachable.
+func NewSSHKeySource(logger *zap.SugaredLogger, ttl time.Duration) sshagent.KeySource {
+	var (
+		mu       sync.Mutex
+		keys     []sshagent.Key
+		loadedAt time.Time
+	)
+
+	return func(ctx context.Context) ([]sshagent.Key, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if keys != nil && time.Since(loadedAt) < ttl {
+			return keys, nil
+		}
+
+		loaded, err := loadSSHKeys(ctx, logger)
+		if err != nil {
+			if keys != nil {
+				logger.Warnf("error refreshing ssh keys, serving cached: %v", err)
+				return keys, nil
+			}
+			return nil, err
+		}
+
+		keys, loadedAt = loaded, time.Now()
+		return keys, nil
+	}
+}
+
+func loadSSHKeys(ctx context.Context, logger *zap.SugaredLogger) ([]sshagent.Key, error) {
+	records, err := ListRecords(ctx, logger, RecordsFilter{Type: string(models.SSHKEY)})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]sshagent.Key, 0, len(records))
+	for _, r := range records {
+		data, err := models.ParseSSHKey(r.Data)
+		if err != nil {
+			logger.Warnf("skipping ssh key %s: %v", r.Name, err)
+			continue
+		}
+
+		signer, err := ssh.ParsePrivateKey([]byte(data.PrivateKey))
+		if err != nil {
+			logger.Warnf("skipping ssh key %s: %v", r.Name, err)
+			continue
+		}
+
+		comment := data.Comment
+		if comment == "" {
+			comment = r.Name
+		}
+		keys = append(keys, sshagent.Key{Signer: signer, Name: r.Name, Comment: comment})
+	}
+
+	return keys, nil
+}
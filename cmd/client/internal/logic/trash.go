@@ -0,0 +1,72 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// DeleteRecord moves the record at path into the server trash and drops its cached copy.
+func DeleteRecord(ctx context.Context, path string) error {
+	record, err := GetRecord(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	return deleteRecord(ctx, record)
+}
+
+func deleteRecord(ctx context.Context, record *models.DataRecord) error {
+	query := url.Values{"folder_id": {strconv.FormatUint(record.FolderID, 10)}}
+	if _, err := apiCall(ctx, http.MethodDelete, "api/user/records/"+url.PathEscape(record.Name), query, nil, nil,
+		http.StatusNoContent); err != nil {
+		if isOffline(err) {
+			return queueDelete(record)
+		}
+		return err
+	}
+
+	return RemoveLocalData(record)
+}
+
+func ListTrash(ctx context.Context) ([]models.DataRecord, error) {
+	records := make([]models.DataRecord, 0)
+	if _, err := apiCall(ctx, http.MethodGet, "api/user/records/trash", nil, nil, &records, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// RestoreRecord brings the record at path back from the trash.
+func RestoreRecord(ctx context.Context, path string) (*models.DataRecord, error) {
+	name, query, err := recordQuery(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var record models.DataRecord
+	status, err := apiCall(ctx, http.MethodPost, "api/user/records/"+url.PathEscape(name)+"/restore", query, nil, &record,
+		http.StatusOK)
+	switch status {
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("record is not in trash")
+	case http.StatusConflict:
+		return nil, fmt.Errorf("record with the same name already exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// PurgeTrash permanently removes every record in the trash.
+func PurgeTrash(ctx context.Context) error {
+	_, err := apiCall(ctx, http.MethodDelete, "api/user/records/trash", nil, nil, nil, http.StatusNoContent)
+	return err
+}
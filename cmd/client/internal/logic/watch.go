@@ -0,0 +1,152 @@
+package logic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/cache"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	eventsPath     = "api/user/events"
+	watchRetryMin  = time.Second
+	watchRetryMax  = time.Minute
+	maxEventLength = 1 << 24
+)
+
+// WatchEvents keeps the local cache up to date with changes pushed by the server until ctx is done.
+// After every connect queued changes are sent and the cache is synced from its cursor, so nothing
+// changed while the stream was down is missed. Lost connections are retried with a growing pause.
+func WatchEvents(ctx context.Context, logger *zap.SugaredLogger) error {
+	delay := watchRetryMin
+	for {
+		connected, err := watchStream(ctx, logger)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var apiErr *APIError
+		if errors.Is(err, ErrNotLoggedIn) || errors.Is(err, ErrConfig) ||
+			errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+			return err
+		}
+		if connected {
+			delay = watchRetryMin
+		}
+
+		logger.Warnf("event stream is closed, reconnecting in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > watchRetryMax {
+			delay = watchRetryMax
+		}
+	}
+}
+
+// watchStream applies events of a single connection and reports whether it was established.
+func watchStream(ctx context.Context, logger *zap.SugaredLogger) (bool, error) {
+	session, err := currentSession()
+	if err != nil {
+		return false, err
+	}
+
+	request, err := session.newRequest(ctx, http.MethodGet, eventsPath, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Add("Accept", "text/event-stream")
+
+	response, err := session.client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return false, &APIError{Method: http.MethodGet, Path: eventsPath, Status: response.StatusCode}
+	}
+
+	// The stream is open, so changes made from now on arrive as events.
+	if n, err := ReplayPending(ctx, logger); err != nil {
+		logger.Warnf("error sending queued changes: %v", err)
+	} else if n != 0 {
+		logger.Infof("sent %d queued changes", n)
+	}
+	if err := SyncDataRecords(ctx, logger, false); err != nil {
+		return true, fmt.Errorf("error syncing records: %w", err)
+	}
+	logger.Infoln("watching for changes")
+
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxEventLength)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			if err := applyEvent(ctx, logger, data.String()); err != nil {
+				return true, err
+			}
+			data.Reset()
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() != 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+
+	return true, errors.New("server closed the stream")
+}
+
+// applyEvent updates the cache with a single event.
+func applyEvent(ctx context.Context, logger *zap.SugaredLogger, data string) error {
+	var e models.Event
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		logger.Warnf("skipping malformed event: %v", err)
+		return nil
+	}
+
+	switch e.Type {
+	case models.EventRecordChanged:
+		if e.Record == nil {
+			return nil
+		}
+		logger.Infof("%s changed", e.Record.Name)
+		return withCache(func(c *cache.Cache) error {
+			return c.PutRecord(e.Record)
+		})
+	case models.EventRecordDeleted:
+		logger.Infof("%s deleted", e.Name)
+		return withCache(func(c *cache.Cache) error {
+			return c.DeleteRecord(&models.DataRecord{Name: e.Name, FolderID: e.FolderID})
+		})
+	case models.EventFoldersChanged:
+		logger.Infoln("folders changed")
+		_, err := fetchFolders(ctx)
+		return err
+	default:
+		logger.Debugf("skipping unknown event %s", e.Type)
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+// Package mask hides secret values in a stream of output.
+package mask
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Placeholder replaces every secret occurrence.
+const Placeholder = "<concealed by gophkeeper>"
+
+// MinSecretLength is the length of the shortest masked secret. Shorter values like a CVV
+// occur in unrelated output too often, masking them would garble it.
+const MinSecretLength = 4
+
+// Writer replaces secrets in everything written to it before passing it to the underlying writer.
+// A secret split between two writes is still masked: bytes that may start a secret are held back
+// until the next write or Close.
+type Writer struct {
+	w       io.Writer
+	secrets [][]byte
+	pending []byte
+	mu      sync.Mutex
+}
+
+// NewWriter creates a masking writer. Secrets shorter than MinSecretLength are ignored.
+func NewWriter(w io.Writer, secrets []string) *Writer {
+	mw := &Writer{w: w}
+	for _, s := range secrets {
+		if len(s) >= MinSecretLength {
+			mw.secrets = append(mw.secrets, []byte(s))
+		}
+	}
+	// Longer secrets first, so a secret containing another one is masked as a whole.
+	sort.Slice(mw.secrets, func(i, j int) bool {
+		return len(mw.secrets[i]) > len(mw.secrets[j])
+	})
+
+	return mw
+}
+
+// Write masks p. It always reports len(p) on success, even if a part of p is held back.
+func (mw *Writer) Write(p []byte) (int, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	out, rest := mw.mask(append(mw.pending, p...), false)
+	mw.pending = append([]byte(nil), rest...)
+	if _, err := mw.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close flushes held back bytes, masking the secrets among them.
+func (mw *Writer) Close() error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	if len(mw.pending) == 0 {
+		return nil
+	}
+
+	out, _ := mw.mask(mw.pending, true)
+	mw.pending = nil
+	_, err := mw.w.Write(out)
+	return err
+}
+
+// mask replaces secrets in buf. Unless final, the tail of buf which may begin a secret
+// is returned as rest instead of being written out.
+func (mw *Writer) mask(buf []byte, final bool) (out []byte, rest []byte) {
+	out = make([]byte, 0, len(buf))
+
+	i := 0
+scan:
+	for i < len(buf) {
+		for _, s := range mw.secrets {
+			if bytes.HasPrefix(buf[i:], s) {
+				out = append(out, Placeholder...)
+				i += len(s)
+				continue scan
+			}
+			if !final && bytes.HasPrefix(s, buf[i:]) {
+				// The rest of buf may be the beginning of s, wait for more data.
+				break scan
+			}
+		}
+		out = append(out, buf[i])
+		i++
+	}
+
+	return out, buf[i:]
+}
@@ -0,0 +1,80 @@
+package mask
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	const p = Placeholder
+
+	tests := []struct {
+		name    string
+		secrets []string
+		writes  []string
+		want    string
+	}{
+		{
+			name:    "single write",
+			secrets: []string{"hunter2"},
+			writes:  []string{"password is hunter2, again hunter2\n"},
+			want:    "password is " + p + ", again " + p + "\n",
+		},
+		{
+			name:    "secret split between writes",
+			secrets: []string{"hunter2"},
+			writes:  []string{"password is hun", "te", "r2!\n"},
+			want:    "password is " + p + "!\n",
+		},
+		{
+			name:    "byte by byte",
+			secrets: []string{"s3cr3t"},
+			writes:  []string{"a", "s", "3", "c", "r", "3", "t", "b"},
+			want:    "a" + p + "b",
+		},
+		{
+			name:    "longer secret containing a shorter one",
+			secrets: []string{"token", "token-secret"},
+			writes:  []string{"token-sec", "ret and token"},
+			want:    p + " and " + p,
+		},
+		{
+			name:    "beginning of a secret which never completes",
+			secrets: []string{"hunter2"},
+			writes:  []string{"hunt", "ing"},
+			want:    "hunting",
+		},
+		{
+			name:    "secret held back until close",
+			secrets: []string{"token", "token-secret"},
+			writes:  []string{"the token"},
+			want:    "the " + p,
+		},
+		{
+			name:    "short values are not masked",
+			secrets: []string{"1", "a", "123", ""},
+			writes:  []string{"a 1 and 123 stay\n"},
+			want:    "a 1 and 123 stay\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := NewWriter(&out, tt.secrets)
+
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			if got := out.String(); got != tt.want {
+				t.Errorf("output %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
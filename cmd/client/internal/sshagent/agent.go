@@ -0,0 +1,250 @@
+// Package sshagent serves SSH keys stored in gophkeeper over the ssh-agent protocol.
+// Keys are only held in memory, the agent is read-only for its clients.
+package sshagent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	ErrReadOnly   = errors.New("agent is read-only, add keys to gophkeeper instead")
+	ErrLocked     = errors.New("agent is locked")
+	ErrKeyUnknown = errors.New("key not found")
+	ErrDenied     = errors.New("key usage denied")
+)
+
+// Key is a vault record usable for signing.
+type Key struct {
+	Signer  ssh.Signer
+	Name    string
+	Comment string
+}
+
+// KeySource loads the current set of keys, e.g. from the vault.
+type KeySource func(ctx context.Context) ([]Key, error)
+
+// Confirm is asked before every signature when set. Returning false denies the request.
+type Confirm func(key Key) bool
+
+// Agent implements agent.ExtendedAgent over keys provided by a KeySource.
+type Agent struct {
+	source     KeySource
+	confirm    Confirm
+	passphrase []byte
+	mu         sync.Mutex
+	locked     bool
+}
+
+var _ agent.ExtendedAgent = (*Agent)(nil)
+
+func New(source KeySource, confirm Confirm) *Agent {
+	return &Agent{source: source, confirm: confirm}
+}
+
+// Listen opens a unix socket at path accessible by the current user only. The socket is bound
+// in a new private directory and moved to path after its mode is set, so others never reach it.
+func Listen(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".gophkeeper-agent-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating socket dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bound := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", bound)
+	if err != nil {
+		return nil, fmt.Errorf("error listening agent socket: %w", err)
+	}
+	// the bound path is gone after the move, the caller removes the socket at path
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(bound, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error setting socket permissions: %w", err)
+	}
+	if err := os.Rename(bound, path); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error moving agent socket: %w", err)
+	}
+
+	return l, nil
+}
+
+// Serve answers agent requests on every connection accepted from l until ctx is done.
+func (a *Agent) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error accepting agent connection: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+
+			_ = a.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn answers agent requests on a single connection, e.g. one end of net.Pipe.
+func (a *Agent) ServeConn(conn net.Conn) error {
+	return agent.ServeAgent(a, conn)
+}
+
+func (a *Agent) keys() ([]Key, error) {
+	a.mu.Lock()
+	locked := a.locked
+	a.mu.Unlock()
+	if locked {
+		return nil, ErrLocked
+	}
+
+	return a.source(context.Background())
+}
+
+func (a *Agent) List() ([]*agent.Key, error) {
+	keys, err := a.keys()
+	if errors.Is(err, ErrLocked) {
+		return []*agent.Key{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*agent.Key, 0, len(keys))
+	for _, k := range keys {
+		pub := k.Signer.PublicKey()
+		res = append(res, &agent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: k.Comment})
+	}
+
+	return res, nil
+}
+
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	keys, err := a.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := key.Marshal()
+	for _, k := range keys {
+		if !bytes.Equal(k.Signer.PublicKey().Marshal(), wanted) {
+			continue
+		}
+
+		if a.confirm != nil && !a.confirm(k) {
+			return nil, ErrDenied
+		}
+
+		if flags == 0 {
+			return k.Signer.Sign(rand.Reader, data)
+		}
+
+		algSigner, ok := k.Signer.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, fmt.Errorf("signature algorithms are not supported by %s", k.Name)
+		}
+
+		var algorithm string
+		switch flags {
+		case agent.SignatureFlagRsaSha256:
+			algorithm = ssh.KeyAlgoRSASHA256
+		case agent.SignatureFlagRsaSha512:
+			algorithm = ssh.KeyAlgoRSASHA512
+		default:
+			return nil, fmt.Errorf("unsupported signature flags: %d", flags)
+		}
+
+		return algSigner.SignWithAlgorithm(rand.Reader, data, algorithm)
+	}
+
+	return nil, ErrKeyUnknown
+}
+
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	keys, err := a.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, k := range keys {
+		signers = append(signers, k.Signer)
+	}
+
+	return signers, nil
+}
+
+func (a *Agent) Add(key agent.AddedKey) error {
+	return ErrReadOnly
+}
+
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	return ErrReadOnly
+}
+
+func (a *Agent) RemoveAll() error {
+	return ErrReadOnly
+}
+
+func (a *Agent) Lock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.locked {
+		return ErrLocked
+	}
+	a.locked = true
+	a.passphrase = append([]byte(nil), passphrase...)
+
+	return nil
+}
+
+func (a *Agent) Unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.locked {
+		return errors.New("agent is not locked")
+	}
+	if subtle.ConstantTimeCompare(passphrase, a.passphrase) != 1 {
+		return errors.New("incorrect passphrase")
+	}
+	a.locked = false
+	a.passphrase = nil
+
+	return nil
+}
+
+func (a *Agent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
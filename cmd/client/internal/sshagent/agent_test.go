@@ -0,0 +1,158 @@
+package sshagent_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rawen554/goph-keeper/cmd/client/internal/sshagent"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newKey(t *testing.T, name string) sshagent.Key {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+
+	return sshagent.Key{Signer: signer, Name: name, Comment: name + "@gophkeeper"}
+}
+
+// newClient serves a over one end of a pipe and returns a client of the other end.
+func newClient(t *testing.T, a *sshagent.Agent) agent.ExtendedAgent {
+	t.Helper()
+
+	server, conn := net.Pipe()
+	go func() {
+		defer server.Close()
+		_ = a.ServeConn(server)
+	}()
+	t.Cleanup(func() { conn.Close() })
+
+	return agent.NewClient(conn)
+}
+
+func TestAgent(t *testing.T) {
+	work, home := newKey(t, "work"), newKey(t, "home")
+	a := sshagent.New(func(ctx context.Context) ([]sshagent.Key, error) {
+		return []sshagent.Key{work, home}, nil
+	}, func(key sshagent.Key) bool {
+		return key.Name != "home"
+	})
+	client := newClient(t, a)
+
+	keys, err := client.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(keys) != 2 || keys[0].Comment != work.Comment || keys[1].Comment != home.Comment {
+		t.Fatalf("List: both keys are expected, got %v", keys)
+	}
+
+	data := []byte("session data")
+	sig, err := client.Sign(work.Signer.PublicKey(), data)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := work.Signer.PublicKey().Verify(data, sig); err != nil {
+		t.Errorf("Sign: the signature does not verify: %v", err)
+	}
+
+	if _, err := client.Sign(home.Signer.PublicKey(), data); err == nil {
+		t.Error("Sign: a denied key is expected to fail")
+	}
+	if _, err := client.Sign(newKey(t, "other").Signer.PublicKey(), data); err == nil {
+		t.Error("Sign: an unknown key is expected to fail")
+	}
+
+	if err := client.Add(agent.AddedKey{PrivateKey: ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))}); err == nil {
+		t.Error("Add: the agent is expected to be read-only")
+	}
+	if err := client.Remove(work.Signer.PublicKey()); err == nil {
+		t.Error("Remove: the agent is expected to be read-only")
+	}
+	if err := client.RemoveAll(); err == nil {
+		t.Error("RemoveAll: the agent is expected to be read-only")
+	}
+	if keys, err := client.List(); err != nil || len(keys) != 2 {
+		t.Errorf("List after refused changes: both keys are expected, got %v, %v", keys, err)
+	}
+}
+
+func TestAgentLock(t *testing.T) {
+	key := newKey(t, "work")
+	client := newClient(t, sshagent.New(func(ctx context.Context) ([]sshagent.Key, error) {
+		return []sshagent.Key{key}, nil
+	}, nil))
+
+	if err := client.Lock([]byte("secret")); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if keys, err := client.List(); err != nil || len(keys) != 0 {
+		t.Errorf("List of a locked agent: no keys are expected, got %v, %v", keys, err)
+	}
+	if _, err := client.Sign(key.Signer.PublicKey(), []byte("data")); err == nil {
+		t.Error("Sign: a locked agent is expected to refuse")
+	}
+
+	if err := client.Unlock([]byte("wrong")); err == nil {
+		t.Error("Unlock: a wrong passphrase is expected to fail")
+	}
+	if err := client.Unlock([]byte("secret")); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if _, err := client.Sign(key.Signer.PublicKey(), []byte("data")); err != nil {
+		t.Errorf("Sign after unlock: %v", err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.sock")
+
+	l, err := sshagent.Listen(path)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Errorf("Listen: a socket with mode 0600 is expected, got %s", fi.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Listen: the private dir is expected to be removed, got %v", entries)
+	}
+
+	key := newKey(t, "work")
+	a := sshagent.New(func(ctx context.Context) ([]sshagent.Key, error) {
+		return []sshagent.Key{key}, nil
+	}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = a.Serve(ctx, l) }()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if keys, err := agent.NewClient(conn).List(); err != nil || len(keys) != 1 {
+		t.Errorf("List over the socket: one key is expected, got %v, %v", keys, err)
+	}
+}
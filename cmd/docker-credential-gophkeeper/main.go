@@ -0,0 +1,23 @@
+// Command docker-credential-gophkeeper is a docker credential helper. It reuses the login state
+// and records logic of the client through the dockercred package.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/rawen554/goph-keeper/cmd/client/dockercred"
+	"github.com/rawen554/goph-keeper/internal/logger"
+)
+
+func main() {
+	logger, err := logger.NewLogger()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := dockercred.Execute(); err != nil {
+		logger.Errorf("error: %v", err)
+		os.Exit(1)
+	}
+}
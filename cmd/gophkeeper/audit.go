@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/audit"
+	"github.com/rawen554/goph-keeper/internal/config"
+	"github.com/rawen554/goph-keeper/internal/events"
+	"github.com/rawen554/goph-keeper/internal/keyring"
+)
+
+const verifyAuditCommand = "verify-audit"
+
+// verifyAudit checks the hash chains of the audit log and prints the head of every chain.
+// Heads kept from an earlier run reveal events cut from the end of a chain. The audit key
+// is read with the master key.
+func verifyAudit(ctx context.Context, config *config.ServerConfig) error {
+	keys, err := keyring.Load(config.MasterKeyFile, config.MasterKey)
+	if err != nil {
+		return fmt.Errorf("failed to load master key: %w", err)
+	}
+
+	storage, err := store.NewEncryptedStore(keys, nil, func(publisher events.Publisher) (store.Store, error) {
+		return store.Open(ctx, config.Storage, config.DatabaseDSN, config.LogLevel, publisher)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer storage.Close()
+
+	key, err := storage.AuditKey()
+	if err != nil {
+		return err
+	}
+
+	report, err := audit.Verify(storage, key)
+	if err != nil {
+		return fmt.Errorf("failed to verify audit log: %w", err)
+	}
+
+	for _, chain := range report.Chains {
+		fmt.Printf("user %d: %d events from %d, head %s\n", chain.UserID, chain.Events, chain.First, chain.Head)
+	}
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+
+	if len(report.Problems) != 0 {
+		return fmt.Errorf("%w: %d problems found", audit.ErrBrokenChain, len(report.Problems))
+	}
+	fmt.Printf("audit log is intact: %d chains\n", len(report.Chains))
+
+	return nil
+}
@@ -1,28 +1,35 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"crypto/tls"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rawen554/goph-keeper/internal/adapters/store"
 	"github.com/rawen554/goph-keeper/internal/app"
 	"github.com/rawen554/goph-keeper/internal/config"
+	"github.com/rawen554/goph-keeper/internal/events"
+	"github.com/rawen554/goph-keeper/internal/keyring"
 	"github.com/rawen554/goph-keeper/internal/logger"
+	"github.com/rawen554/goph-keeper/internal/metrics"
 )
 
 const (
 	timeoutServerShutdown = time.Second * 5
 	timeoutShutdown       = time.Second * 10
 	component             = "component"
+	// drainDelay gives load balancers the time to notice failing readiness before the server stops.
+	drainDelay = time.Second * 2
 )
 
 func main() {
@@ -32,7 +39,7 @@ func main() {
 }
 
 func run() error {
-	ctx, cancelCtx := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancelCtx := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancelCtx()
 
 	logger, err := logger.NewLogger()
@@ -45,59 +52,162 @@ func run() error {
 		return fmt.Errorf("failed to parse config: %w", err)
 	}
 
-	storage, err := store.NewStore(ctx, config.DatabaseDSN, config.LogLevel)
+	switch flag.Arg(0) {
+	case verifyAuditCommand:
+		return verifyAudit(ctx, config)
+	case resetPasswordCommand:
+		return resetPassword(ctx, config, flag.Args()[1:])
+	}
+
+	var ca *app.CA
+	if config.EnableMTLS {
+		if !config.EnableHTTPS {
+			return errors.New("mTLS requires https")
+		}
+		if ca, err = app.LoadOrCreateCA(config.CACertPath, config.CAKeyPath, logger.Named("ca")); err != nil {
+			return fmt.Errorf("failed to load device CA: %w", err)
+		}
+	}
+
+	var certs *app.CertManager
+	if config.EnableHTTPS {
+		certs, err = app.NewCertManager(config.TLSCertPath, config.TLSKeyPath, tlsHosts(config.TLSHosts), logger.Named("certs"))
+		if err != nil {
+			return fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+	}
+
+	keys, err := keyring.Load(config.MasterKeyFile, config.MasterKey)
+	created := errors.Is(err, os.ErrNotExist)
+	if created {
+		keys, err = keyring.Create(config.MasterKeyFile)
+	}
 	if err != nil {
+		return fmt.Errorf("failed to load master key: %w", err)
+	}
+
+	broker := events.NewBroker()
+	storage, err := store.NewEncryptedStore(keys, metrics.NewLagPublisher(broker), func(publisher events.Publisher) (store.Store, error) {
+		return store.Open(ctx, config.Storage, config.DatabaseDSN, config.LogLevel, publisher)
+	})
+	if err != nil {
+		if created && errors.Is(err, store.ErrForeignDataKeys) {
+			// the new key is of no use, the next start has to fail the same way
+			os.Remove(config.MasterKeyFile)
+			return fmt.Errorf("master key file %s is missing, restore it: %w", config.MasterKeyFile, err)
+		}
 		return fmt.Errorf("failed to initialize storage: %w", err)
 	}
 
+	auditKey, err := storage.AuditKey()
+	if err != nil {
+		storage.Close()
+		return err
+	}
+
+	if config.MetricsAddr != "" {
+		if err := metrics.RegisterStore(storage); err != nil {
+			return fmt.Errorf("failed to register store metrics: %w", err)
+		}
+	}
+
 	wg := &sync.WaitGroup{}
 	defer func() {
 		wg.Wait()
 	}()
 
+	// requests in flight are served until the server is shut down
+	serverStopped := make(chan struct{})
+
 	wg.Add(1)
 	go func() {
 		defer logger.Info("closed DB")
 		defer wg.Done()
-		<-ctx.Done()
+		<-serverStopped
 
 		storage.Close()
 	}()
 
-	componentsErrs := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer logger.Info("trash purger has been stopped")
+		defer wg.Done()
+
+		runTrashPurger(ctx, storage, config.TrashRetention, config.IdempotencyTTL, logger.Named("trash-purger"))
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer logger.Info("audit log purger has been stopped")
+		defer wg.Done()
+
+		runAuditPurger(ctx, storage, config.AuditRetention, auditKey, logger.Named("audit-purger"))
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer logger.Info("data key rewrapper has been stopped")
+		defer wg.Done()
+
+		runDataKeyRewrapper(ctx, storage, logger.Named("key-rewrapper"))
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer logger.Info("legacy record resealer has been stopped")
+		defer wg.Done()
+
+		runLegacyResealer(ctx, storage, logger.Named("resealer"))
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer logger.Info("change listener has been stopped")
+		defer wg.Done()
+
+		if err := storage.ListenChanges(ctx); err != nil {
+			logger.Errorf("error listening data changes: %v", err)
+		}
+	}()
+
+	if certs != nil {
+		wg.Add(1)
+		go func() {
+			defer logger.Info("certificate manager has been stopped")
+			defer wg.Done()
+
+			certs.Run(ctx)
+		}()
+	}
 
-	a := app.NewApp(config, storage, logger.Named("app"))
+	// The metrics and API servers send at most one error each. Only the first one is received,
+	// the buffer keeps the other sender from blocking forever.
+	componentsErrs := make(chan error, 2)
+
+	if config.MetricsAddr != "" {
+		runMetricsServer(ctx, wg, config.MetricsAddr, componentsErrs, logger.Named("metrics"))
+	}
+
+	a := app.NewApp(config, storage, broker, ca, certs, auditKey, logger.Named("app"))
 	srv, err := a.NewServer()
 	if err != nil {
-		logger.Fatalf("error creating server: %w", err)
+		logger.Fatalf("error creating server: %v", err)
 	}
 
 	go func(errs chan<- error) {
 		if config.EnableHTTPS {
-			_, errCert := os.ReadFile(config.TLSCertPath)
-			_, errKey := os.ReadFile(config.TLSKeyPath)
-
-			if errors.Is(errCert, os.ErrNotExist) || errors.Is(errKey, os.ErrNotExist) {
-				privateKey, certBytes, err := app.CreateCertificates(logger.Named("certs-builder"))
-				if err != nil {
-					errs <- fmt.Errorf("error creating tls certs: %w", err)
-					return
-				}
-
-				if err := app.WriteCertificates(certBytes, config.TLSCertPath, privateKey, config.TLSKeyPath, logger); err != nil {
-					errs <- fmt.Errorf("error writing tls certs: %w", err)
-					return
-				}
-			}
-
 			srv.TLSConfig = &tls.Config{
-				MinVersion:         tls.VersionTLS12,
-				ClientAuth:         tls.RequestClientCert,
-				KeyLogWriter:       bufio.NewWriter(os.Stdout),
-				InsecureSkipVerify: true,
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: certs.GetCertificate,
+			}
+			// Certificates are optional for login, which enrolls devices, tokens bound
+			// to a certificate are checked by the auth middleware.
+			if ca != nil {
+				srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+				srv.TLSConfig.ClientCAs = ca.Pool()
 			}
 
-			if err := srv.ListenAndServeTLS(config.TLSCertPath, config.TLSKeyPath); err != nil {
+			if err := srv.ListenAndServeTLS("", ""); err != nil {
 				if errors.Is(err, http.ErrServerClosed) {
 					return
 				}
@@ -119,13 +229,19 @@ func run() error {
 	go func() {
 		defer logger.Info("server has been shutdown")
 		defer wg.Done()
+		defer close(serverStopped)
 		<-ctx.Done()
 
+		a.Drain()
+		logger.Infof("draining for %s before shutdown", drainDelay)
+		time.Sleep(drainDelay)
+
 		shutdownTimeoutCtx, cancelShutdownTimeoutCtx := context.WithTimeout(context.Background(), timeoutServerShutdown)
 		defer cancelShutdownTimeoutCtx()
 		if err := srv.Shutdown(shutdownTimeoutCtx); err != nil {
 			logger.Errorf("an error occurred during server shutdown: %v", err)
 		}
+		a.FlushAudit()
 	}()
 
 	select {
@@ -145,3 +261,15 @@ func run() error {
 
 	return nil
 }
+
+// tlsHosts splits the comma separated names of the server.
+func tlsHosts(list string) []string {
+	hosts := make([]string, 0)
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
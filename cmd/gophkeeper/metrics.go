@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/rawen554/goph-keeper/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const metricsRoute = "/metrics"
+
+// runMetricsServer serves metrics on their own listener, so they are not exposed with the API.
+// The server is shut down when ctx is done.
+func runMetricsServer(ctx context.Context, wg *sync.WaitGroup, addr string, errs chan<- error, logger *zap.SugaredLogger) {
+	mux := http.NewServeMux()
+	mux.Handle(metricsRoute, metrics.Handler())
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		logger.Infof("serving metrics on %s%s", addr, metricsRoute)
+		if err := srv.ListenAndServe(); err != nil {
+			if errors.Is(err, http.ErrServerClosed) {
+				return
+			}
+			errs <- fmt.Errorf("run metrics server has failed: %w", err)
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer logger.Info("metrics server has been shutdown")
+		defer wg.Done()
+		<-ctx.Done()
+
+		shutdownTimeoutCtx, cancelShutdownTimeoutCtx := context.WithTimeout(context.Background(), timeoutServerShutdown)
+		defer cancelShutdownTimeoutCtx()
+		if err := srv.Shutdown(shutdownTimeoutCtx); err != nil {
+			logger.Errorf("an error occurred during metrics server shutdown: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/app"
+	"github.com/rawen554/goph-keeper/internal/config"
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+const (
+	resetPasswordCommand = "reset-password"
+	resetScanBatch       = 100
+)
+
+// resetPassword issues one-time reset codes and prints them for the operator to hand over.
+// Without logins codes are issued to every account with an empty password, those were
+// registered while passwords of requests were not decoded and refuse to log in until reset.
+func resetPassword(ctx context.Context, config *config.ServerConfig, logins []string) error {
+	storage, err := store.Open(ctx, config.Storage, config.DatabaseDSN, config.LogLevel, nil)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer storage.Close()
+
+	users := make([]models.User, 0, len(logins))
+	for _, login := range logins {
+		u, err := storage.GetUser(&models.User{Login: login})
+		if err != nil {
+			return fmt.Errorf("failed to get user %s: %w", login, err)
+		}
+		users = append(users, *u)
+	}
+	if len(logins) == 0 {
+		if users, err = emptyPasswordUsers(storage); err != nil {
+			return err
+		}
+	}
+
+	expiresAt := time.Now().Add(app.ResetCodeTTL)
+	for _, u := range users {
+		code, hash, err := app.NewResetCode()
+		if err != nil {
+			return err
+		}
+		if err := storage.SetPasswordReset(u.ID, hash, expiresAt); err != nil {
+			return fmt.Errorf("failed to save reset code of %s: %w", u.Login, err)
+		}
+		fmt.Printf("%s: %s\n", u.Login, code)
+	}
+	fmt.Printf("%d reset codes issued, valid until %s\n", len(users), expiresAt.Format(time.RFC3339))
+
+	return nil
+}
+
+func emptyPasswordUsers(storage store.Store) ([]models.User, error) {
+	var found []models.User
+	var afterID uint64
+	for {
+		users, err := storage.GetUsers(afterID, resetScanBatch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		for i := range users {
+			if app.HasEmptyPassword(&users[i]) {
+				found = append(found, users[i])
+			}
+		}
+		if len(users) < resetScanBatch {
+			return found, nil
+		}
+		afterID = users[len(users)-1].ID
+	}
+}
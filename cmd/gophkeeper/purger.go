@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"go.uber.org/zap"
+)
+
+const (
+	purgeInterval = time.Hour
+	// changeLogRetention is enough for change listeners of every replica to catch up after reconnect.
+	changeLogRetention = 24 * time.Hour
+)
+
+// runTrashPurger permanently removes records which stay in the trash longer than retention.
+// Idempotency keys of batch operations older than keyTTL and the revision log are trimmed
+// regardless of the retention.
+func runTrashPurger(ctx context.Context, storage store.Store, retention time.Duration, keyTTL time.Duration,
+	logger *zap.SugaredLogger) {
+	if retention <= 0 {
+		logger.Warnln("trash retention is not set, deleted records are kept forever")
+	}
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if retention > 0 {
+			purged, err := storage.PurgeExpiredRecords(time.Now().Add(-retention))
+			if err != nil {
+				logger.Errorf("error purging trash: %v", err)
+			} else if purged != 0 {
+				logger.Infof("purged %d records deleted more than %s ago", purged, retention)
+			}
+		}
+
+		if _, err := storage.PurgeAppliedOperations(time.Now().Add(-keyTTL)); err != nil {
+			logger.Errorf("error purging idempotency keys: %v", err)
+		}
+
+		if _, err := storage.PurgeChanges(time.Now().Add(-changeLogRetention)); err != nil {
+			logger.Errorf("error purging revision log: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runAuditPurger removes audit events older than retention, the last event of every chain stays.
+// The watermarks of purged chains are hashed with key.
+func runAuditPurger(ctx context.Context, storage store.Store, retention time.Duration, key []byte,
+	logger *zap.SugaredLogger) {
+	if retention <= 0 {
+		logger.Warnln("audit retention is not set, audit events are kept forever")
+		return
+	}
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := storage.PurgeAuditLog(time.Now().Add(-retention), key)
+		if err != nil {
+			logger.Errorf("error purging audit log: %v", err)
+		} else if purged != 0 {
+			logger.Infof("purged %d audit events older than %s", purged, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"go.uber.org/zap"
+)
+
+// rewrapInterval also catches data keys created with an older master key by replicas
+// which are not restarted with the new one yet.
+const rewrapInterval = time.Hour
+
+// runDataKeyRewrapper moves data keys to the current master key after its rotation.
+func runDataKeyRewrapper(ctx context.Context, storage *store.EncryptedStore, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(rewrapInterval)
+	defer ticker.Stop()
+
+	for {
+		rewrapped, err := storage.RewrapDataKeys()
+		if err != nil {
+			logger.Errorf("error rewrapping data keys: %v", err)
+		}
+		if rewrapped != 0 {
+			logger.Infof("rewrapped %d data keys with the current master key", rewrapped)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runLegacyResealer seals records stored before encryption or before data was bound to its
+// record. It stops once none are left, the store refuses such records from then on.
+func runLegacyResealer(ctx context.Context, storage *store.EncryptedStore, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(rewrapInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		resealed, err := storage.ResealLegacyRecords()
+		switch {
+		case err != nil:
+			logger.Errorf("error sealing legacy records: %v", err)
+		case resealed == 0:
+			logger.Infoln("no legacy records are left, unsealed data is refused")
+			return
+		default:
+			// the next run makes sure none are left
+			logger.Infof("sealed %d legacy records", resealed)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// auditAppendAttempts bounds retries of appends racing for the same place in a chain.
+	auditAppendAttempts = 5
+	// auditLockClass is the first key of advisory locks of audit chains, the second is the user id.
+	auditLockClass = 0x61756474
+)
+
+// chainAuditEvent places the event after last in the chain of its user and hashes it with key.
+func chainAuditEvent(e *models.AuditEvent, last *models.AuditEvent, key []byte) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	// the stored time has to hash the same after it is read back
+	e.CreatedAt = e.CreatedAt.UTC().Truncate(time.Microsecond)
+
+	e.Seq = 1
+	e.PrevHash = ""
+	if last != nil {
+		e.Seq = last.Seq + 1
+		e.PrevHash = last.Hash
+	}
+	e.Hash = e.ComputeHash(key)
+}
+
+// AppendAuditEvent adds the event hashed with key to the end of the chain of its user.
+// Appends to a chain wait for each other on a lock where the database has one, otherwise
+// they collide on the sequence number and the loser is chained again.
+func (db *DBStore) AppendAuditEvent(e *models.AuditEvent, key []byte) error {
+	for attempt := 1; ; attempt++ {
+		err := db.conn.Transaction(func(tx *gorm.DB) error {
+			if db.dialect.auditLock != "" {
+				// the lock key wraps around for big ids, a shared lock only makes appends wait
+				if err := tx.Exec(db.dialect.auditLock, auditLockClass, int32(e.UserID)).Error; err != nil {
+					return err
+				}
+			}
+
+			last := make([]models.AuditEvent, 0, 1)
+			if err := tx.Where("user_id = ?", e.UserID).Order("seq DESC").Limit(1).Find(&last).Error; err != nil {
+				return err
+			}
+
+			e.ID = 0
+			if len(last) != 0 {
+				chainAuditEvent(e, &last[0], key)
+			} else {
+				chainAuditEvent(e, nil, key)
+			}
+
+			return tx.Create(e).Error
+		})
+		if errors.Is(err, gorm.ErrDuplicatedKey) && attempt < auditAppendAttempts {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error appending audit event: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// GetAuditEvents reads a page of the audit log of the user, the latest events first.
+func (db *DBStore) GetAuditEvents(userID uint64, query models.AuditQuery) (*models.AuditPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = models.DefaultAuditLimit
+	}
+
+	q := db.conn.Where("user_id = ?", userID)
+	if len(query.Actions) != 0 {
+		q = q.Where("action IN ?", query.Actions)
+	}
+	if !query.Since.IsZero() {
+		q = q.Where("created_at >= ?", query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		q = q.Where("created_at < ?", query.Until.UTC())
+	}
+	if query.Target != "" {
+		q = q.Where("target = ?", query.Target)
+	}
+	if query.Before != 0 {
+		q = q.Where("seq < ?", query.Before)
+	}
+
+	events := make([]models.AuditEvent, 0, limit+1)
+	if err := q.Order("seq DESC").Limit(limit + 1).Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("error getting audit events: %w", err)
+	}
+
+	return auditPage(events, limit), nil
+}
+
+// auditPage cuts the events read one over the limit into a page.
+func auditPage(events []models.AuditEvent, limit int) *models.AuditPage {
+	page := &models.AuditPage{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		page.NextBefore = events[limit-1].Seq
+	}
+
+	return page
+}
+
+// ScanAuditLog reads the chains of every user in order, starting after the event
+// afterSeq of the user afterUserID.
+func (db *DBStore) ScanAuditLog(afterUserID uint64, afterSeq uint64, limit int) ([]models.AuditEvent, error) {
+	events := make([]models.AuditEvent, 0, limit)
+	if err := db.conn.
+		Where("user_id > ? OR (user_id = ? AND seq > ?)", afterUserID, afterUserID, afterSeq).
+		Order("user_id, seq").
+		Limit(limit).
+		Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("error reading audit log: %w", err)
+	}
+
+	return events, nil
+}
+
+// auditChainBounds are the first and last events of a chain and the first one created after
+// the purge time, KeepSeq is nil when there is none.
+type auditChainBounds struct {
+	KeepSeq  *uint64
+	UserID   uint64
+	FirstSeq uint64
+	LastSeq  uint64
+}
+
+// purgeEnd is the last event to purge from the chain, zero when there is nothing to purge.
+// Only events older than the time from the start of the chain are purged, and never the last one.
+func (b auditChainBounds) purgeEnd() uint64 {
+	keep := b.LastSeq
+	if b.KeepSeq != nil && *b.KeepSeq < keep {
+		keep = *b.KeepSeq
+	}
+	if keep <= b.FirstSeq {
+		return 0
+	}
+
+	return keep - 1
+}
+
+// PurgeAuditLog removes events created before the time from the start of every chain. The last
+// event is kept, so new events go on chaining to it. The watermark of the chain moves to the
+// first event left, the verification checks the chain starts there.
+func (db *DBStore) PurgeAuditLog(before time.Time, key []byte) (int64, error) {
+	var purged int64
+	err := db.conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(db.dialect.auditPurgeStart).Error; err != nil {
+			return err
+		}
+
+		var chains []auditChainBounds
+		if err := tx.Raw(`SELECT user_id, min(seq) AS first_seq, max(seq) AS last_seq,
+			min(CASE WHEN created_at >= ? THEN seq END) AS keep_seq
+			FROM audit_events GROUP BY user_id`, before.UTC()).Scan(&chains).Error; err != nil {
+			return err
+		}
+
+		for _, chain := range chains {
+			end := chain.purgeEnd()
+			if end == 0 {
+				continue
+			}
+
+			last := models.AuditEvent{}
+			if err := tx.Where("user_id = ? AND seq = ?", chain.UserID, end).First(&last).Error; err != nil {
+				return err
+			}
+
+			w := models.AuditWatermark{UserID: chain.UserID, Seq: end + 1, PrevHash: last.Hash}
+			w.Hash = w.ComputeHash(key)
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&w).Error; err != nil {
+				return err
+			}
+
+			res := tx.Exec("DELETE FROM audit_events WHERE user_id = ? AND seq <= ?", chain.UserID, end)
+			if res.Error != nil {
+				return res.Error
+			}
+			purged += res.RowsAffected
+		}
+
+		if db.dialect.auditPurgeEnd != "" {
+			return tx.Exec(db.dialect.auditPurgeEnd).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error purging audit log: %w", err)
+	}
+
+	return purged, nil
+}
+
+func (db *DBStore) GetAuditWatermarks() ([]models.AuditWatermark, error) {
+	watermarks := make([]models.AuditWatermark, 0)
+	if err := db.conn.Order("user_id").Find(&watermarks).Error; err != nil {
+		return nil, fmt.Errorf("error getting audit watermarks: %w", err)
+	}
+
+	return watermarks, nil
+}
+
+// createAuditTriggers makes the audit log append-only, only PurgeAuditLog removes events.
+// It runs after auto migration, because the table is created by gorm. The hash chain still
+// reveals changes made by the ones who can drop the triggers.
+func createAuditTriggers(conn *gorm.DB) error {
+	for _, stmt := range []string{
+		`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' AND current_setting('gophkeeper.audit_purge', true) = 'on' THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql`,
+		"DROP TRIGGER IF EXISTS audit_events_no_change ON audit_events",
+		"CREATE TRIGGER audit_events_no_change BEFORE UPDATE OR DELETE ON audit_events " +
+			"FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()",
+		"DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events",
+		"CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events " +
+			"FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only()",
+	} {
+		if err := conn.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("error creating audit log triggers: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	changesChannel = "gophkeeper_changes"
+	changesBatch   = 500
+	listenRetryMin = time.Second
+	listenRetryMax = 30 * time.Second
+	// changesPollInterval is used when the database cannot notify listeners.
+	changesPollInterval = 500 * time.Millisecond
+	// changeGapTimeout is how long a skipped revision log id is waited for. Ids are taken
+	// before commit, so a concurrent transaction may commit a smaller id later, or roll it back.
+	changeGapTimeout = time.Minute
+	maxChangeGaps    = 1024
+)
+
+// logChanges writes events into the revision log and notifies listeners on commit of tx.
+func (db *DBStore) logChanges(tx *gorm.DB, evs ...models.Event) error {
+	if len(evs) == 0 {
+		return nil
+	}
+
+	changes := make([]models.Change, len(evs))
+	for i, e := range evs {
+		changes[i] = models.NewChange(e)
+	}
+
+	return db.saveChanges(tx, changes)
+}
+
+// logRecordChanges logs records changed by a bulk update, listeners read them by id.
+func (db *DBStore) logRecordChanges(tx *gorm.DB, ids []uint64, userID uint64, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	changes := make([]models.Change, len(ids))
+	for i, id := range ids {
+		changes[i] = models.Change{Type: models.EventRecordChanged, Revision: at, UserID: userID, RecordID: id}
+	}
+
+	return db.saveChanges(tx, changes)
+}
+
+func (db *DBStore) saveChanges(tx *gorm.DB, changes []models.Change) error {
+	if err := tx.CreateInBatches(changes, changesBatch).Error; err != nil {
+		return fmt.Errorf("error saving revision log: %w", err)
+	}
+
+	if !db.dialect.listen {
+		return nil
+	}
+
+	// Postgres delivers notifications of a transaction when it commits.
+	if err := tx.Exec("SELECT pg_notify(?, '')", changesChannel).Error; err != nil {
+		return fmt.Errorf("error notifying listeners: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeChanges trims the revision log. Entries are kept only for listeners to catch up after reconnect.
+func (db *DBStore) PurgeChanges(before time.Time) (int64, error) {
+	result := db.conn.Where("created_at < ?", before.UTC()).Delete(&models.Change{})
+	if err := result.Error; err != nil {
+		return 0, fmt.Errorf("error purging revision log: %w", err)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// ListenChanges relays changes committed by every server replica to the publisher until ctx is done.
+// A dropped listener reconnects and backfills the changes it missed from the revision log.
+// Databases without notifications have a single replica, which polls the log.
+func (db *DBStore) ListenChanges(ctx context.Context) error {
+	r := &changeRelay{db: db, gaps: make(map[uint64]time.Time)}
+	if err := db.conn.WithContext(ctx).Model(&models.Change{}).Select("coalesce(max(id), 0)").Scan(&r.lastID).Error; err != nil {
+		return fmt.Errorf("error reading revision log: %w", err)
+	}
+
+	run := r.listen
+	if !db.dialect.listen {
+		run = r.poll
+	}
+
+	delay := listenRetryMin
+	for {
+		err := run(ctx, func() { delay = listenRetryMin })
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		log.Printf("change listener is disconnected, reconnecting in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > listenRetryMax {
+			delay = listenRetryMax
+		}
+	}
+}
+
+// changeRelay reads the revision log after lastID. Ids skipped by the log order are
+// kept as gaps and looked up again until their transactions commit or the gaps expire.
+type changeRelay struct {
+	db     *DBStore
+	gaps   map[uint64]time.Time
+	lastID uint64
+}
+
+func (r *changeRelay) listen(ctx context.Context, connected func()) error {
+	conn, err := pgx.Connect(ctx, r.db.dsn)
+	if err != nil {
+		return fmt.Errorf("error connecting: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+changesChannel); err != nil {
+		return fmt.Errorf("error listening %s: %w", changesChannel, err)
+	}
+	connected()
+
+	// Changes committed while nobody listened are read before waiting.
+	for {
+		if err := r.relay(ctx); err != nil {
+			return err
+		}
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return fmt.Errorf("error waiting for notification: %w", err)
+		}
+	}
+}
+
+func (r *changeRelay) poll(ctx context.Context, connected func()) error {
+	ticker := time.NewTicker(changesPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.relay(ctx); err != nil {
+			return err
+		}
+		connected()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes revision log entries which have not been published yet.
+func (r *changeRelay) relay(ctx context.Context) error {
+	for {
+		q := r.db.conn.WithContext(ctx).Where("id > ?", r.lastID)
+		if len(r.gaps) != 0 {
+			ids := make([]uint64, 0, len(r.gaps))
+			for id := range r.gaps {
+				ids = append(ids, id)
+			}
+			q = q.Or("id IN ?", ids)
+		}
+
+		changes := make([]models.Change, 0, changesBatch)
+		if err := q.Order("id").Limit(changesBatch).Find(&changes).Error; err != nil {
+			return fmt.Errorf("error reading revision log: %w", err)
+		}
+
+		if err := r.publish(ctx, changes); err != nil {
+			return err
+		}
+		r.advance(changes)
+
+		if len(changes) < changesBatch {
+			return nil
+		}
+	}
+}
+
+func (r *changeRelay) advance(changes []models.Change) {
+	now := time.Now()
+	for _, c := range changes {
+		if c.ID <= r.lastID {
+			delete(r.gaps, c.ID)
+			continue
+		}
+
+		if c.ID-r.lastID <= maxChangeGaps {
+			for id := r.lastID + 1; id < c.ID; id++ {
+				r.gaps[id] = now
+			}
+		}
+		r.lastID = c.ID
+	}
+
+	for id, since := range r.gaps {
+		if now.Sub(since) > changeGapTimeout || len(r.gaps) > maxChangeGaps {
+			delete(r.gaps, id)
+		}
+	}
+}
+
+// publish turns log entries into events. Changed records are read in their current state,
+// the ones deleted since are skipped, as their deletion follows in the log.
+func (r *changeRelay) publish(ctx context.Context, changes []models.Change) error {
+	if r.db.publisher == nil || len(changes) == 0 {
+		return nil
+	}
+
+	ids := make([]uint64, 0, len(changes))
+	for _, c := range changes {
+		if c.Type == models.EventRecordChanged {
+			ids = append(ids, c.RecordID)
+		}
+	}
+
+	records := make(map[uint64]*models.DataRecord, len(ids))
+	if len(ids) != 0 {
+		found := make([]models.DataRecord, 0, len(ids))
+		if err := r.db.conn.WithContext(ctx).Where("id IN ?", ids).Find(&found).Error; err != nil {
+			return fmt.Errorf("error reading changed records: %w", err)
+		}
+		for i := range found {
+			records[found[i].ID] = &found[i]
+		}
+	}
+
+	for _, c := range changes {
+		switch c.Type {
+		case models.EventRecordChanged:
+			if record, ok := records[c.RecordID]; ok && record.UserID == c.UserID {
+				r.db.publisher.Publish(models.RecordChangedEvent(record))
+			}
+		case models.EventRecordDeleted:
+			r.db.publisher.Publish(models.RecordDeletedEvent(c.UserID, c.Name, c.FolderID, c.Revision))
+		case models.EventFoldersChanged:
+			r.db.publisher.Publish(models.FoldersChangedEvent(c.UserID, c.FolderID, c.Revision))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package store
+
+// dialect is the SQL which differs between the databases DBStore runs on.
+type dialect struct {
+	// tagFilter matches records whose comma separated tags contain the argument.
+	tagFilter string
+	// dataBytes is the stored size of record data in bytes.
+	dataBytes string
+	// migrations is the dir of embedded migrations.
+	migrations string
+	// migrationState reads the applied schema version and whether its migration has failed halfway.
+	// Postgres is migrated by golang-migrate, SQLite migrations run in transactions and are never dirty.
+	migrationState string
+	// listen is set when replicas are notified about changes, otherwise the revision log is polled.
+	listen bool
+	// auditLock takes a transaction lock of an audit chain, it is empty where writers are serialized anyway.
+	auditLock string
+	// auditPurgeStart lets the transaction delete from the append-only audit log, auditPurgeEnd
+	// takes the permission back where it does not end with the transaction.
+	auditPurgeStart string
+	auditPurgeEnd   string
+	// fuzzySearch enables search by trigram similarity.
+	fuzzySearch bool
+}
+
+var postgresDialect = dialect{
+	tagFilter:       "? = ANY(regexp_split_to_array(metadata->>'tags', '\\s*,\\s*'))",
+	dataBytes:       "octet_length(data)",
+	migrations:      "migrations/postgres",
+	migrationState:  "SELECT version, dirty FROM schema_migrations",
+	listen:          true,
+	auditLock:       "SELECT pg_advisory_xact_lock(?, ?)",
+	auditPurgeStart: "SET LOCAL gophkeeper.audit_purge = 'on'",
+	fuzzySearch:     true,
+}
+
+var sqliteDialect = dialect{
+	tagFilter:       "has_tag(metadata->>'tags', ?)",
+	dataBytes:       "length(CAST(data AS BLOB))",
+	migrations:      "migrations/sqlite",
+	migrationState:  "SELECT coalesce(max(version), 0) AS version, false AS dirty FROM schema_migrations",
+	auditPurgeStart: "INSERT INTO audit_purge (active) VALUES (1)",
+	auditPurgeEnd:   "DELETE FROM audit_purge",
+}
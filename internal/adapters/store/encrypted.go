@@ -0,0 +1,412 @@
+package store
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rawen554/goph-keeper/internal/events"
+	"github.com/rawen554/goph-keeper/internal/keyring"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// rewrapBatch is the number of data keys rewrapped at once.
+	rewrapBatch = 100
+	// resealBatch is the number of legacy records sealed at once.
+	resealBatch = 100
+)
+
+var (
+	ErrForeignDataKeys = errors.New("master key does not open stored data keys")
+	ErrUnsealedData    = errors.New("record data is not sealed")
+)
+
+// EncryptedStore seals data of records with the data key of their user before they reach
+// the underlying store and opens it on the way back, events included. Data keys are kept
+// in the underlying store wrapped by the master key. Names and metadata are not encrypted,
+// they are needed for listing and search. Checksums are taken over the sealed data, so they
+// change with every write and tell nothing about the plaintext. Sealed data is bound to its
+// user and record name, see recordAAD.
+//
+// Records stored before encryption, or sealed before the binding, are read as they are until
+// ResealLegacyRecords finds none left, then such records are refused with ErrUnsealedData.
+type EncryptedStore struct {
+	Store
+	keys       *keyring.Keyring
+	ciphers    map[uint64]*keyring.Cipher
+	sealedOnly atomic.Bool
+	mu         sync.Mutex
+}
+
+// NewEncryptedStore wraps the store made by open. The store is given the publisher
+// which opens records of events before they are passed to publisher.
+func NewEncryptedStore(
+	keys *keyring.Keyring,
+	publisher events.Publisher,
+	open func(publisher events.Publisher) (Store, error),
+) (*EncryptedStore, error) {
+	s := &EncryptedStore{keys: keys, ciphers: make(map[uint64]*keyring.Cipher)}
+
+	var opening events.Publisher
+	if publisher != nil {
+		opening = &openingPublisher{store: s, next: publisher}
+	}
+
+	inner, err := open(opening)
+	if err != nil {
+		return nil, err
+	}
+	s.Store = inner
+
+	if err := s.checkDataKeys(); err != nil {
+		inner.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// checkDataKeys makes sure the master keys open the data keys already stored, so a lost or
+// wrong master key is found before new data is sealed next to data which cannot be opened.
+func (s *EncryptedStore) checkDataKeys() error {
+	keys, err := s.Store.GetStaleDataKeys(math.MaxUint32, 1)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if _, err := s.keys.Unwrap(key.Wrapped, key.MasterVersion); err != nil {
+			return fmt.Errorf("%w: data key of user %d: %v", ErrForeignDataKeys, key.UserID, err)
+		}
+	}
+
+	return nil
+}
+
+// cipher returns the cipher of the user data key, the key is created on first use. The lock
+// guards only the map, requests of other users do not wait for the database. Requests racing
+// on the first use end up with the same stored key.
+func (s *EncryptedStore) cipher(userID uint64) (*keyring.Cipher, error) {
+	s.mu.Lock()
+	c, ok := s.ciphers[userID]
+	s.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	dataKey, err := s.dataKey(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = keyring.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.ciphers[userID]; ok {
+		return cached, nil
+	}
+	s.ciphers[userID] = c
+
+	return c, nil
+}
+
+// dataKey reads and unwraps the data key of the user, the key is created on first use.
+func (s *EncryptedStore) dataKey(userID uint64) ([]byte, error) {
+	key, err := s.Store.GetDataKey(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		key, err = s.createDataKey(userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := s.keys.Unwrap(key.Wrapped, key.MasterVersion)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key of user %d: %w", userID, err)
+	}
+
+	return dataKey, nil
+}
+
+// AuditKey returns the key of audit log hashes. It is kept like data keys, so it is
+// rewrapped on master key rotation and lost together with the master key only.
+func (s *EncryptedStore) AuditKey() ([]byte, error) {
+	key, err := s.dataKey(models.AuditKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting audit key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (s *EncryptedStore) createDataKey(userID uint64) (*models.DataKey, error) {
+	dataKey, err := keyring.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, version, err := s.keys.Wrap(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping data key: %w", err)
+	}
+
+	key := &models.DataKey{UserID: userID, Wrapped: wrapped, MasterVersion: version}
+	if err := s.Store.CreateDataKey(key); err != nil {
+		// another replica has created the key first
+		if errors.Is(err, ErrDataKeyExists) {
+			return s.Store.GetDataKey(userID)
+		}
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// recordAAD binds sealed data to the user and the name of its record, so data copied to
+// another record or user does not open. Folders are not bound, moves do not touch data.
+func recordAAD(userID uint64, name string) []byte {
+	aad := strconv.AppendUint([]byte("record:"), userID, 10)
+	aad = append(aad, ':')
+	return append(aad, name...)
+}
+
+func (s *EncryptedStore) sealRecord(r *models.DataRecord, userID uint64) error {
+	c, err := s.cipher(userID)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := c.Seal(r.Data, recordAAD(userID, r.Name))
+	if err != nil {
+		return fmt.Errorf("error encrypting record %s: %w", r.Name, err)
+	}
+	r.Data = sealed
+	r.Checksum = fmt.Sprintf("%x", md5.Sum([]byte(sealed)))
+
+	return nil
+}
+
+// openRecord decrypts data in place. Legacy records are opened too, or left as is when
+// stored before encryption, until every record is resealed.
+func (s *EncryptedStore) openRecord(r *models.DataRecord) error {
+	if !keyring.IsSealed(r.Data) {
+		if s.sealedOnly.Load() {
+			return fmt.Errorf("%w: record %d", ErrUnsealedData, r.ID)
+		}
+		if !keyring.IsLegacy(r.Data) {
+			return nil
+		}
+	}
+
+	c, err := s.cipher(r.UserID)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.Open(r.Data, recordAAD(r.UserID, r.Name))
+	if err != nil {
+		return fmt.Errorf("error decrypting record %d: %w", r.ID, err)
+	}
+	r.Data = data
+
+	return nil
+}
+
+func (s *EncryptedStore) openRecords(records []models.DataRecord) error {
+	for i := range records {
+		if err := s.openRecord(&records[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PutDataRecord stores the sealed data, data keeps the plaintext for the caller.
+func (s *EncryptedStore) PutDataRecord(data *models.DataRecord, userID uint64) error {
+	plaintext := data.Data
+	if err := s.sealRecord(data, userID); err != nil {
+		return err
+	}
+	defer func() { data.Data = plaintext }()
+
+	return s.Store.PutDataRecord(data, userID)
+}
+
+func (s *EncryptedStore) GetUserRecord(recordName string, folderID *uint64, userID uint64) (*models.DataRecord, error) {
+	record, err := s.Store.GetUserRecord(recordName, folderID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, s.openRecord(record)
+}
+
+func (s *EncryptedStore) GetUserRecords(userID uint64, query models.RecordsQuery) (*models.RecordsPage, error) {
+	page, err := s.Store.GetUserRecords(userID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, s.openRecords(page.Records)
+}
+
+// SearchUserRecords has nothing to open, search results come without data.
+func (s *EncryptedStore) SearchUserRecords(userID uint64, query string, limit int) ([]models.DataRecord, error) {
+	return s.Store.SearchUserRecords(userID, query, limit)
+}
+
+// ApplyBatch stores sealed records of upserts, the items keep the plaintext for the caller.
+func (s *EncryptedStore) ApplyBatch(items []BatchItem, userID uint64, atomic bool) ([]error, error) {
+	plaintexts := make([]string, len(items))
+	for i, item := range items {
+		if item.Record == nil {
+			continue
+		}
+
+		plaintexts[i] = item.Record.Data
+		if err := s.sealRecord(item.Record, userID); err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		for i, item := range items {
+			if item.Record != nil {
+				item.Record.Data = plaintexts[i]
+			}
+		}
+	}()
+
+	return s.Store.ApplyBatch(items, userID, atomic)
+}
+
+func (s *EncryptedStore) GetDeletedRecords(userID uint64) ([]models.DataRecord, error) {
+	records, err := s.Store.GetDeletedRecords(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, s.openRecords(records)
+}
+
+func (s *EncryptedStore) RestoreUserRecord(recordName string, folderID *uint64, userID uint64) (*models.DataRecord, error) {
+	record, err := s.Store.RestoreUserRecord(recordName, folderID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, s.openRecord(record)
+}
+
+// RewrapDataKeys wraps data keys, which are wrapped by older master keys, with the current one.
+// Data itself is not touched, so an old master key may be dropped once nothing is left to rewrap.
+func (s *EncryptedStore) RewrapDataKeys() (int, error) {
+	current := s.keys.Current()
+	rewrapped := 0
+
+	for {
+		stale, err := s.Store.GetStaleDataKeys(current, rewrapBatch)
+		if err != nil {
+			return rewrapped, err
+		}
+
+		for i := range stale {
+			key := &stale[i]
+			dataKey, err := s.keys.Unwrap(key.Wrapped, key.MasterVersion)
+			if err != nil {
+				return rewrapped, fmt.Errorf("error unwrapping data key of user %d: %w", key.UserID, err)
+			}
+
+			oldVersion := key.MasterVersion
+			if key.Wrapped, key.MasterVersion, err = s.keys.Wrap(dataKey); err != nil {
+				return rewrapped, fmt.Errorf("error wrapping data key of user %d: %w", key.UserID, err)
+			}
+			if err := s.Store.RewrapDataKey(key, oldVersion); err != nil {
+				return rewrapped, err
+			}
+			rewrapped++
+		}
+
+		if len(stale) < rewrapBatch {
+			return rewrapped, nil
+		}
+	}
+}
+
+// ResealLegacyRecords seals records stored before encryption and records sealed before data
+// was bound to its record, trashed ones included. It returns the number of records found;
+// once a run finds none, records which are not sealed are refused on read.
+func (s *EncryptedStore) ResealLegacyRecords() (int, error) {
+	found := 0
+
+	for afterID := uint64(0); ; {
+		records, err := s.Store.GetUnsealedRecords(keyring.SealedPrefix, afterID, resealBatch)
+		if err != nil {
+			return found, err
+		}
+
+		for i := range records {
+			r := &records[i]
+			afterID = r.ID
+			stored := r.Data
+
+			if keyring.IsLegacy(stored) {
+				c, err := s.cipher(r.UserID)
+				if err != nil {
+					return found, err
+				}
+				if r.Data, err = c.Open(stored, nil); err != nil {
+					return found, fmt.Errorf("error decrypting record %d: %w", r.ID, err)
+				}
+			}
+			if err := s.sealRecord(r, r.UserID); err != nil {
+				return found, err
+			}
+			// a record written since it was read is sealed by the writer
+			if err := s.Store.ResealRecord(r, stored); err != nil {
+				return found, err
+			}
+			found++
+		}
+
+		if len(records) < resealBatch {
+			break
+		}
+	}
+
+	if found == 0 {
+		s.sealedOnly.Store(true)
+	}
+
+	return found, nil
+}
+
+// openingPublisher decrypts records of events, an event which cannot be decrypted is dropped.
+type openingPublisher struct {
+	store *EncryptedStore
+	next  events.Publisher
+}
+
+func (p *openingPublisher) Publish(e models.Event) {
+	if e.Record != nil {
+		record := *e.Record
+		if err := p.store.openRecord(&record); err != nil {
+			log.Printf("error publishing event: %v", err)
+			return
+		}
+		e.Record = &record
+	}
+
+	p.next.Publish(e)
+}
@@ -0,0 +1,219 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/adapters/store/storetest"
+	"github.com/rawen554/goph-keeper/internal/events"
+	"github.com/rawen554/goph-keeper/internal/keyring"
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, publisher events.Publisher) store.Store {
+		keys, err := keyring.Create(filepath.Join(t.TempDir(), "master.key"))
+		if err != nil {
+			t.Fatalf("keyring.Create: %v", err)
+		}
+
+		s, err := store.NewEncryptedStore(keys, publisher, func(publisher events.Publisher) (store.Store, error) {
+			return newSQLiteStore(t, publisher), nil
+		})
+		if err != nil {
+			t.Fatalf("NewEncryptedStore: %v", err)
+		}
+
+		return s
+	})
+}
+
+func TestEncryptedStoreAuditKey(t *testing.T) {
+	keys, err := keyring.Create(filepath.Join(t.TempDir(), "master.key"))
+	if err != nil {
+		t.Fatalf("keyring.Create: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "gophkeeper.db")
+	open := func() *store.EncryptedStore {
+		s, err := store.NewEncryptedStore(keys, nil, func(publisher events.Publisher) (store.Store, error) {
+			return store.NewSQLiteStore(context.Background(), file, "error", publisher)
+		})
+		if err != nil {
+			t.Fatalf("NewEncryptedStore: %v", err)
+		}
+		return s
+	}
+
+	s := open()
+	key, err := s.AuditKey()
+	if err != nil || len(key) != keyring.KeySize {
+		t.Fatalf("AuditKey: got %d bytes, %v", len(key), err)
+	}
+	s.Close()
+
+	s = open()
+	defer s.Close()
+	if again, err := s.AuditKey(); err != nil || !bytes.Equal(again, key) {
+		t.Errorf("AuditKey: the same key is expected after reopening, got %v", err)
+	}
+}
+
+// openEncrypted opens the SQLite database file with the master keys.
+func openEncrypted(t *testing.T, keys string, file string) (*store.EncryptedStore, error) {
+	t.Helper()
+
+	k, err := keyring.Parse(keys)
+	if err != nil {
+		t.Fatalf("keyring.Parse: %v", err)
+	}
+
+	return store.NewEncryptedStore(k, nil, func(publisher events.Publisher) (store.Store, error) {
+		return store.NewSQLiteStore(context.Background(), file, "error", publisher)
+	})
+}
+
+func mustOpenEncrypted(t *testing.T, keys string, file string) *store.EncryptedStore {
+	t.Helper()
+
+	s, err := openEncrypted(t, keys, file)
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: %v", err)
+	}
+
+	return s
+}
+
+func masterKey(version string, b byte) string {
+	return version + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, keyring.KeySize))
+}
+
+func putPlainRecord(t *testing.T, s store.Store, userID uint64, name string, data string) *models.DataRecord {
+	t.Helper()
+
+	r := &models.DataRecord{Type: models.TEXT, Name: name, Data: data, UserID: userID}
+	if err := s.PutDataRecord(r, userID); err != nil {
+		t.Fatalf("PutDataRecord(%s): %v", name, err)
+	}
+
+	return r
+}
+
+func expectRecordData(t *testing.T, s store.Store, userID uint64, name string, want string) {
+	t.Helper()
+
+	r, err := s.GetUserRecord(name, nil, userID)
+	if err != nil {
+		t.Fatalf("GetUserRecord(%s): %v", name, err)
+	}
+	if r.Data != want {
+		t.Errorf("GetUserRecord(%s): data %q, want %q", name, r.Data, want)
+	}
+}
+
+func TestEncryptedStoreRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gophkeeper.db")
+	v1, v2 := masterKey("1", 1), masterKey("2", 2)
+
+	s := mustOpenEncrypted(t, v1, file)
+	alice := &models.User{Login: "alice", Password: "hash"}
+	if _, err := s.CreateUser(alice); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	putPlainRecord(t, s, alice.ID, "mail", "mail secret")
+	s.Close()
+
+	s = mustOpenEncrypted(t, v1+"\n"+v2, file)
+	rewrapped, err := s.RewrapDataKeys()
+	if err != nil || rewrapped != 1 {
+		t.Fatalf("RewrapDataKeys: rewrapped %d, %v", rewrapped, err)
+	}
+	expectRecordData(t, s, alice.ID, "mail", "mail secret")
+	putPlainRecord(t, s, alice.ID, "bank", "bank secret")
+	s.Close()
+
+	// the old master key may be dropped once every data key is rewrapped
+	s = mustOpenEncrypted(t, v2, file)
+	expectRecordData(t, s, alice.ID, "mail", "mail secret")
+	expectRecordData(t, s, alice.ID, "bank", "bank secret")
+	s.Close()
+
+	if _, err := openEncrypted(t, v1, file); !errors.Is(err, store.ErrForeignDataKeys) {
+		t.Errorf("NewEncryptedStore with the dropped key: ErrForeignDataKeys is expected, got %v", err)
+	}
+}
+
+func TestEncryptedStoreLegacyRecords(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gophkeeper.db")
+
+	// records of the database before encryption
+	plain, err := store.NewSQLiteStore(context.Background(), file, "error", nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	alice := &models.User{Login: "alice", Password: "hash"}
+	if _, err := plain.CreateUser(alice); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	putPlainRecord(t, plain, alice.ID, "note", "old secret")
+	plain.Close()
+
+	s := mustOpenEncrypted(t, masterKey("1", 1), file)
+	defer s.Close()
+	expectRecordData(t, s, alice.ID, "note", "old secret")
+
+	resealed, err := s.ResealLegacyRecords()
+	if err != nil || resealed != 1 {
+		t.Fatalf("ResealLegacyRecords: resealed %d, %v", resealed, err)
+	}
+	raw, err := s.Store.GetUserRecord("note", nil, alice.ID)
+	if err != nil || !keyring.IsSealed(raw.Data) {
+		t.Fatalf("stored data is expected to be sealed, got %+v, %v", raw, err)
+	}
+	expectRecordData(t, s, alice.ID, "note", "old secret")
+
+	if resealed, err := s.ResealLegacyRecords(); err != nil || resealed != 0 {
+		t.Fatalf("ResealLegacyRecords: nothing is expected to be left, resealed %d, %v", resealed, err)
+	}
+
+	// data written around the store is refused once every record is sealed
+	putPlainRecord(t, s.Store, alice.ID, "planted", "plaintext")
+	if _, err := s.GetUserRecord("planted", nil, alice.ID); !errors.Is(err, store.ErrUnsealedData) {
+		t.Errorf("GetUserRecord of unsealed data: ErrUnsealedData is expected, got %v", err)
+	}
+}
+
+func TestEncryptedStoreBindsData(t *testing.T) {
+	s := mustOpenEncrypted(t, masterKey("1", 1), filepath.Join(t.TempDir(), "gophkeeper.db"))
+	defer s.Close()
+
+	alice := &models.User{Login: "alice", Password: "hash"}
+	if _, err := s.CreateUser(alice); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	putPlainRecord(t, s, alice.ID, "mail", "mail secret")
+	bank := putPlainRecord(t, s, alice.ID, "bank", "bank secret")
+
+	mail, err := s.Store.GetUserRecord("mail", nil, alice.ID)
+	if err != nil {
+		t.Fatalf("GetUserRecord: %v", err)
+	}
+	stored, err := s.Store.GetUserRecord("bank", nil, alice.ID)
+	if err != nil {
+		t.Fatalf("GetUserRecord: %v", err)
+	}
+
+	// sealed data of mail copied into bank opens with the same data key, but not for another record
+	copied := &models.DataRecord{ID: bank.ID, Data: mail.Data, Checksum: mail.Checksum}
+	if err := s.Store.ResealRecord(copied, stored.Data); err != nil {
+		t.Fatalf("ResealRecord: %v", err)
+	}
+	if _, err := s.GetUserRecord("bank", nil, alice.ID); !errors.Is(err, keyring.ErrDecryptionFailed) {
+		t.Errorf("GetUserRecord of copied data: ErrDecryptionFailed is expected, got %v", err)
+	}
+}
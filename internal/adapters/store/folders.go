@@ -0,0 +1,172 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+)
+
+func (db *DBStore) GetUserFolders(userID uint64) ([]models.Folder, error) {
+	folders := make([]models.Folder, 0)
+	if err := db.conn.Where(&models.Folder{UserID: userID}).Order("id").Find(&folders).Error; err != nil {
+		return nil, fmt.Errorf("error getting user folders: %w", err)
+	}
+
+	models.FillFolderPaths(folders)
+
+	return folders, nil
+}
+
+func (db *DBStore) CreateFolder(folder *models.Folder) error {
+	return db.conn.Transaction(func(tx *gorm.DB) error {
+		if err := checkFolderOwner(tx, folder.ParentID, folder.UserID); err != nil {
+			return err
+		}
+
+		if err := tx.Create(folder).Error; err != nil {
+			return folderError(err)
+		}
+
+		return db.logChanges(tx, models.FoldersChangedEvent(folder.UserID, folder.ID, folder.UpdatedAt))
+	})
+}
+
+// UpdateFolder renames the folder and moves it under ParentID.
+func (db *DBStore) UpdateFolder(folder *models.Folder) error {
+	return db.conn.Transaction(func(tx *gorm.DB) error {
+		if err := checkFolderOwner(tx, folder.ID, folder.UserID); err != nil {
+			return err
+		}
+		if err := checkFolderOwner(tx, folder.ParentID, folder.UserID); err != nil {
+			return err
+		}
+
+		for parent := folder.ParentID; parent != models.RootFolderID; {
+			if parent == folder.ID {
+				return ErrFolderCycle
+			}
+
+			var p models.Folder
+			if err := tx.Select("parent_id").First(&p, parent).Error; err != nil {
+				return fmt.Errorf("error walking folder parents: %w", err)
+			}
+			parent = p.ParentID
+		}
+
+		now := tx.NowFunc()
+		result := tx.Model(&models.Folder{}).
+			Where("id = ? AND user_id = ?", folder.ID, folder.UserID).
+			Updates(map[string]interface{}{"name": folder.Name, "parent_id": folder.ParentID, "updated_at": now})
+		if err := result.Error; err != nil {
+			return folderError(err)
+		}
+
+		return db.logChanges(tx, models.FoldersChangedEvent(folder.UserID, folder.ID, now))
+	})
+}
+
+// DeleteFolder removes the folder. Its records and subfolders are moved into the parent folder,
+// and their update time is bumped so that clients pick the move up on the next sync.
+func (db *DBStore) DeleteFolder(folderID uint64, userID uint64) error {
+	return db.conn.Transaction(func(tx *gorm.DB) error {
+		var folder models.Folder
+		result := tx.Where("id = ? AND user_id = ?", folderID, userID).Limit(1).Find(&folder)
+		if err := result.Error; err != nil {
+			return fmt.Errorf("error getting folder: %w", err)
+		}
+		if result.RowsAffected == 0 {
+			return ErrFolderNotFound
+		}
+
+		now := tx.NowFunc()
+		if err := tx.Model(&models.Folder{}).
+			Where("user_id = ? AND parent_id = ?", userID, folderID).
+			Updates(map[string]interface{}{"parent_id": folder.ParentID, "updated_at": now}).Error; err != nil {
+			return folderError(err)
+		}
+
+		var moved []uint64
+		if err := tx.Model(&models.DataRecord{}).
+			Where("user_id = ? AND folder_id = ?", userID, folderID).
+			Pluck("id", &moved).Error; err != nil {
+			return fmt.Errorf("error getting folder records: %w", err)
+		}
+
+		if err := tx.Model(&models.DataRecord{}).
+			Where("user_id = ? AND folder_id = ?", userID, folderID).
+			Updates(map[string]interface{}{"folder_id": folder.ParentID, "updated_at": now}).Error; err != nil {
+			return folderError(err)
+		}
+
+		if err := tx.Delete(&folder).Error; err != nil {
+			return fmt.Errorf("error deleting folder: %w", err)
+		}
+
+		if err := db.logChanges(tx, models.FoldersChangedEvent(userID, folderID, now)); err != nil {
+			return err
+		}
+
+		return db.logRecordChanges(tx, moved, userID, now)
+	})
+}
+
+func (db *DBStore) MoveRecords(ids []uint64, folderID uint64, userID uint64) error {
+	ids = distinctIDs(ids)
+	return db.conn.Transaction(func(tx *gorm.DB) error {
+		if err := checkFolderOwner(tx, folderID, userID); err != nil {
+			return err
+		}
+
+		now := tx.NowFunc()
+		result := tx.Model(&models.DataRecord{}).
+			Where("user_id = ? AND id IN ?", userID, ids).
+			Updates(map[string]interface{}{"folder_id": folderID, "updated_at": now})
+		if err := result.Error; err != nil {
+			return folderError(err)
+		}
+		if result.RowsAffected != int64(len(ids)) {
+			return gorm.ErrRecordNotFound
+		}
+
+		return db.logRecordChanges(tx, ids, userID, now)
+	})
+}
+
+// distinctIDs drops repeated ids, so the count of updated rows can be checked against them.
+func distinctIDs(ids []uint64) []uint64 {
+	seen := make(map[uint64]bool, len(ids))
+	distinct := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			distinct = append(distinct, id)
+		}
+	}
+	return distinct
+}
+
+func checkFolderOwner(tx *gorm.DB, folderID uint64, userID uint64) error {
+	if folderID == models.RootFolderID {
+		return nil
+	}
+
+	var count int64
+	if err := tx.Model(&models.Folder{}).Where("id = ? AND user_id = ?", folderID, userID).Count(&count).Error; err != nil {
+		return fmt.Errorf("error checking folder: %w", err)
+	}
+	if count == 0 {
+		return ErrFolderNotFound
+	}
+
+	return nil
+}
+
+func folderError(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrDuplicateName
+	}
+
+	return fmt.Errorf("error saving changes: %w", err)
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+)
+
+func (db *DBStore) GetDataKey(userID uint64) (*models.DataKey, error) {
+	key := models.DataKey{}
+	if err := db.conn.Where("user_id = ?", userID).First(&key).Error; err != nil {
+		return nil, fmt.Errorf("error getting data key: %w", err)
+	}
+
+	return &key, nil
+}
+
+// CreateDataKey saves the first data key of the user. Concurrent requests of a new user
+// may race to create it, all but one get ErrDataKeyExists and read the saved key.
+func (db *DBStore) CreateDataKey(key *models.DataKey) error {
+	if err := db.conn.Create(key).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrDataKeyExists
+		}
+		return fmt.Errorf("error saving data key: %w", err)
+	}
+
+	return nil
+}
+
+// GetStaleDataKeys finds data keys wrapped by master keys older than version.
+func (db *DBStore) GetStaleDataKeys(version uint32, limit int) ([]models.DataKey, error) {
+	keys := make([]models.DataKey, 0, limit)
+	result := db.conn.Where("master_version < ?", version).Order("user_id").Limit(limit).Find(&keys)
+	if err := result.Error; err != nil {
+		return nil, fmt.Errorf("error getting stale data keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RewrapDataKey replaces the wrapped key unless it was rewrapped since it was read with oldVersion.
+func (db *DBStore) RewrapDataKey(key *models.DataKey, oldVersion uint32) error {
+	result := db.conn.Model(&models.DataKey{}).
+		Where("user_id = ? AND master_version = ?", key.UserID, oldVersion).
+		Updates(map[string]interface{}{
+			"wrapped":        key.Wrapped,
+			"master_version": key.MasterVersion,
+			"updated_at":     db.conn.NowFunc(),
+		})
+	if err := result.Error; err != nil {
+		return fmt.Errorf("error rewrapping data key: %w", err)
+	}
+
+	return nil
+}
+
+// GetUnsealedRecords finds records with data not starting with prefix, trashed ones included, by id.
+func (db *DBStore) GetUnsealedRecords(prefix string, afterID uint64, limit int) ([]models.DataRecord, error) {
+	records := make([]models.DataRecord, 0, limit)
+	result := db.conn.Unscoped().
+		Where("id > ? AND data NOT LIKE ? ESCAPE '\\'", afterID, escapeLike(prefix)+"%").
+		Order("id").
+		Limit(limit).
+		Find(&records)
+	if err := result.Error; err != nil {
+		return nil, fmt.Errorf("error getting unsealed records: %w", err)
+	}
+
+	return records, nil
+}
+
+// ResealRecord replaces the data and checksum unless the data was changed since it was read as oldData.
+// updated_at moves forward, so clients fetch the new checksum on their next sync.
+func (db *DBStore) ResealRecord(record *models.DataRecord, oldData string) error {
+	result := db.conn.Unscoped().Model(&models.DataRecord{}).
+		Where("id = ? AND data = ?", record.ID, oldData).
+		Updates(map[string]interface{}{
+			"data":       record.Data,
+			"checksum":   record.Checksum,
+			"updated_at": db.conn.NowFunc(),
+		})
+	if err := result.Error; err != nil {
+		return fmt.Errorf("error resealing record: %w", err)
+	}
+
+	return nil
+}
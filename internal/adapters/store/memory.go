@@ -0,0 +1,1053 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/events"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+)
+
+// MemoryStore keeps data in the process memory, it is meant for tests and demos.
+// Data is lost on exit, and every change works on a copy of the whole state,
+// which replaces the state only when the change succeeds.
+type MemoryStore struct {
+	publisher events.Publisher
+	state     *memoryState
+	mu        sync.RWMutex
+}
+
+type appliedKey struct {
+	key    string
+	userID uint64
+}
+
+type memoryState struct {
+	users    map[uint64]models.User
+	records  map[uint64]models.DataRecord
+	folders  map[uint64]models.Folder
+	applied  map[appliedKey]time.Time
+	dataKeys map[uint64]models.DataKey
+	events   []models.Event
+	audit    []models.AuditEvent
+	// watermarks is replaced as a whole by PurgeAuditLog, copies of the state share it
+	watermarks map[uint64]models.AuditWatermark
+	userSeq    uint64
+	recordSeq  uint64
+	folderSeq  uint64
+	auditSeq   uint64
+}
+
+func NewMemoryStore(publisher events.Publisher) *MemoryStore {
+	return &MemoryStore{
+		publisher: publisher,
+		state: &memoryState{
+			users:    make(map[uint64]models.User),
+			records:  make(map[uint64]models.DataRecord),
+			folders:  make(map[uint64]models.Folder),
+			applied:  make(map[appliedKey]time.Time),
+			dataKeys: make(map[uint64]models.DataKey),
+		},
+	}
+}
+
+// update runs fn on a copy of the state. The copy replaces the state and its events
+// are published only when fn succeeds.
+func (m *MemoryStore) update(fn func(s *memoryState) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.state.clone()
+	if err := fn(s); err != nil {
+		return err
+	}
+
+	evs := s.events
+	s.events = nil
+	m.state = s
+
+	if m.publisher != nil {
+		for _, e := range evs {
+			m.publisher.Publish(e)
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryState) clone() *memoryState {
+	c := *s
+	c.users = make(map[uint64]models.User, len(s.users))
+	for id, u := range s.users {
+		c.users[id] = u
+	}
+	c.records = make(map[uint64]models.DataRecord, len(s.records))
+	for id, r := range s.records {
+		c.records[id] = cloneRecord(r)
+	}
+	c.folders = make(map[uint64]models.Folder, len(s.folders))
+	for id, f := range s.folders {
+		c.folders[id] = f
+	}
+	c.applied = make(map[appliedKey]time.Time, len(s.applied))
+	for k, t := range s.applied {
+		c.applied[k] = t
+	}
+	c.dataKeys = make(map[uint64]models.DataKey, len(s.dataKeys))
+	for id, k := range s.dataKeys {
+		c.dataKeys[id] = k
+	}
+	c.events = append([]models.Event(nil), s.events...)
+	// the log is only appended to, the capped slice makes the copy append to its own array
+	c.audit = s.audit[:len(s.audit):len(s.audit)]
+
+	return &c
+}
+
+func cloneRecord(r models.DataRecord) models.DataRecord {
+	if r.Metadata != nil {
+		meta := make(models.Metadata, len(r.Metadata))
+		for k, v := range r.Metadata {
+			meta[k] = v
+		}
+		r.Metadata = meta
+	}
+
+	return r
+}
+
+func (s *memoryState) emit(e models.Event) {
+	s.events = append(s.events, e)
+}
+
+func (s *memoryState) emitRecord(r models.DataRecord) {
+	r = cloneRecord(r)
+	s.emit(models.RecordChangedEvent(&r))
+}
+
+func (s *memoryState) checkFolderOwner(folderID uint64, userID uint64) error {
+	if folderID == models.RootFolderID {
+		return nil
+	}
+
+	if f, ok := s.folders[folderID]; !ok || f.UserID != userID {
+		return ErrFolderNotFound
+	}
+
+	return nil
+}
+
+// sortedRecords returns records matching fn in the id order.
+func (s *memoryState) sortedRecords(fn func(r *models.DataRecord) bool) []models.DataRecord {
+	res := make([]models.DataRecord, 0)
+	for _, r := range s.records {
+		r := r
+		if fn(&r) {
+			res = append(res, cloneRecord(r))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+
+	return res
+}
+
+func (s *memoryState) recordNameTaken(userID uint64, folderID uint64, name string, exceptID uint64) bool {
+	for _, r := range s.records {
+		if r.ID != exceptID && !r.DeletedAt.Valid && r.UserID == userID && r.FolderID == folderID && r.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *memoryState) folderNameTaken(userID uint64, parentID uint64, name string, exceptID uint64) bool {
+	for _, f := range s.folders {
+		if f.ID != exceptID && f.UserID == userID && f.ParentID == parentID && f.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findRecord finds an alive record by name in the folder, or in any folder when folderID is nil.
+func (s *memoryState) findRecord(recordName string, folderID *uint64, userID uint64) (models.DataRecord, error) {
+	found := s.sortedRecords(func(r *models.DataRecord) bool {
+		return !r.DeletedAt.Valid && r.UserID == userID && r.Name == recordName &&
+			(folderID == nil || r.FolderID == *folderID)
+	})
+	if len(found) == 0 {
+		return models.DataRecord{}, fmt.Errorf("error getting order: %w", gorm.ErrRecordNotFound)
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].FolderID < found[j].FolderID })
+
+	return found[0], nil
+}
+
+// saveRecord creates the record, or replaces the one with its id.
+func (s *memoryState) saveRecord(r *models.DataRecord, now time.Time) error {
+	if r.ID != 0 {
+		if old, ok := s.records[r.ID]; ok && (old.UserID != r.UserID || old.DeletedAt.Valid) {
+			return fmt.Errorf("error saving changes: record %d cannot be replaced", r.ID)
+		}
+	}
+	if s.recordNameTaken(r.UserID, r.FolderID, r.Name, r.ID) {
+		return ErrDuplicateName
+	}
+
+	if r.ID == 0 {
+		s.recordSeq++
+		r.ID = s.recordSeq
+	} else if r.ID > s.recordSeq {
+		s.recordSeq = r.ID
+	}
+	if r.UploadedAt.IsZero() {
+		r.UploadedAt = now
+	}
+	r.UpdatedAt = now
+
+	s.records[r.ID] = cloneRecord(*r)
+	s.emitRecord(*r)
+
+	return nil
+}
+
+func (m *MemoryStore) CreateUser(user *models.User) (int64, error) {
+	err := m.update(func(s *memoryState) error {
+		for _, u := range s.users {
+			if u.Login == user.Login {
+				return ErrDuplicateLogin
+			}
+		}
+
+		s.userSeq++
+		user.ID = s.userSeq
+		s.users[user.ID] = *user
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+func (m *MemoryStore) GetUser(u *models.User) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var found *models.User
+	for _, user := range m.state.users {
+		user := user
+		if (u.ID == 0 || user.ID == u.ID) && (u.Login == "" || user.Login == u.Login) &&
+			(u.Password == "" || user.Password == u.Password) && (found == nil || user.ID < found.ID) {
+			found = &user
+		}
+	}
+	if found == nil {
+		return nil, ErrLoginNotFound
+	}
+
+	return found, nil
+}
+
+func (m *MemoryStore) GetUsers(afterID uint64, limit int) ([]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]models.User, 0, limit)
+	for _, u := range m.state.users {
+		if u.ID > afterID {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+
+	return users, nil
+}
+
+func (m *MemoryStore) SetPasswordReset(userID uint64, codeHash string, expiresAt time.Time) error {
+	return m.update(func(s *memoryState) error {
+		u, ok := s.users[userID]
+		if !ok {
+			return ErrLoginNotFound
+		}
+		expiresAt := expiresAt.UTC()
+		u.ResetCodeHash, u.ResetExpiresAt = codeHash, &expiresAt
+		s.users[userID] = u
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) ResetPassword(login string, codeHash string, now time.Time, passwordHash string) error {
+	return m.update(func(s *memoryState) error {
+		for id, u := range s.users {
+			if u.Login != login {
+				continue
+			}
+			if u.ResetCodeHash == "" || u.ResetCodeHash != codeHash || u.ResetExpiresAt == nil || !u.ResetExpiresAt.After(now) {
+				return ErrResetCodeInvalid
+			}
+			u.Password, u.ResetCodeHash, u.ResetExpiresAt = passwordHash, "", nil
+			s.users[id] = u
+
+			return nil
+		}
+
+		return ErrResetCodeInvalid
+	})
+}
+
+func (m *MemoryStore) PutDataRecord(data *models.DataRecord, userID uint64) error {
+	return m.update(func(s *memoryState) error {
+		if err := s.checkFolderOwner(data.FolderID, userID); err != nil {
+			return err
+		}
+
+		data.UserID = userID
+		return s.saveRecord(data, time.Now())
+	})
+}
+
+func (m *MemoryStore) GetUserRecord(recordName string, folderID *uint64, userID uint64) (*models.DataRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, err := m.state.findRecord(recordName, folderID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (m *MemoryStore) GetUserRecords(userID uint64, query models.RecordsQuery) (*models.RecordsPage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > models.MaxRecordsLimit {
+		limit = models.DefaultRecordsLimit
+	}
+	column, desc := query.Sort.Column()
+	byName := column == "name"
+
+	var cursor *models.Cursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = models.DecodeCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	// compare orders records by the sort column and id.
+	compare := func(name string, updatedAt time.Time, id uint64, c models.Cursor) int {
+		switch {
+		case byName && name != c.Name:
+			return strings.Compare(name, c.Name)
+		case !byName && !updatedAt.Equal(c.UpdatedAt):
+			if updatedAt.Before(c.UpdatedAt) {
+				return -1
+			}
+			return 1
+		case id < c.ID:
+			return -1
+		case id > c.ID:
+			return 1
+		default:
+			return 0
+		}
+	}
+	order := 1
+	if desc {
+		order = -1
+	}
+
+	m.mu.RLock()
+	records := m.state.sortedRecords(func(r *models.DataRecord) bool {
+		switch {
+		case r.DeletedAt.Valid || r.UserID != userID,
+			query.Type != "" && r.Type != query.Type,
+			query.FolderID != nil && r.FolderID != *query.FolderID,
+			query.NamePrefix != "" && !strings.HasPrefix(r.Name, query.NamePrefix),
+			query.Tag != "" && !r.Metadata.HasTag(query.Tag),
+			!query.UpdatedSince.IsZero() && !r.UpdatedAt.After(query.UpdatedSince),
+			cursor != nil && compare(r.Name, r.UpdatedAt, r.ID, *cursor)*order <= 0:
+			return false
+		}
+		return true
+	})
+	m.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return compare(records[i].Name, records[i].UpdatedAt, records[i].ID, models.CursorAfter(&records[j]))*order < 0
+	})
+
+	page := &models.RecordsPage{Records: records}
+	if len(records) > limit {
+		page.Records = records[:limit]
+		page.NextCursor = models.CursorAfter(&page.Records[limit-1]).Encode()
+	}
+
+	return page, nil
+}
+
+// SearchUserRecords finds records whose name, URL or tags contain query, their data is dropped.
+func (m *MemoryStore) SearchUserRecords(userID uint64, query string, limit int) ([]models.DataRecord, error) {
+	if limit <= 0 || limit > models.MaxRecordsLimit {
+		limit = models.DefaultRecordsLimit
+	}
+	query = strings.ToLower(query)
+
+	m.mu.RLock()
+	records := m.state.sortedRecords(func(r *models.DataRecord) bool {
+		text := strings.ToLower(r.Name + " " + r.Metadata[models.MetaURL] + " " + r.Metadata[models.MetaTags])
+		return !r.DeletedAt.Valid && r.UserID == userID && strings.Contains(text, query)
+	})
+	m.mu.RUnlock()
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	for i := range records {
+		records[i].Data = ""
+	}
+
+	return records, nil
+}
+
+// ApplyBatch applies every item and returns an error per item. In atomic mode the first
+// failure discards the whole batch, otherwise each failed item is discarded alone.
+func (m *MemoryStore) ApplyBatch(items []BatchItem, userID uint64, atomic bool) ([]error, error) {
+	errs := make([]error, len(items))
+	now := time.Now()
+
+	err := m.update(func(s *memoryState) error {
+		for i, item := range items {
+			if !atomic {
+				recordSeq, events := s.recordSeq, len(s.events)
+				errs[i] = s.applyBatchItem(item, userID, now)
+				if errs[i] != nil && !errors.Is(errs[i], ErrAlreadyApplied) {
+					s.undoBatchItem(item, userID, recordSeq, events)
+				}
+				continue
+			}
+
+			err := s.applyBatchItem(item, userID, now)
+			if err == nil || errors.Is(err, ErrAlreadyApplied) {
+				errs[i] = err
+				continue
+			}
+
+			for j := range errs {
+				errs[j] = ErrBatchAborted
+			}
+			errs[i] = err
+			return err
+		}
+
+		return nil
+	})
+	if err != nil && !atomic {
+		return nil, fmt.Errorf("error applying batch: %w", err)
+	}
+
+	return errs, nil
+}
+
+// undoBatchItem reverts a failed item of a batch which is not atomic. applyBatchItem changes
+// records only after every check has passed, so a failed item leaves behind no more than its
+// idempotency key, the record id sequence and events.
+func (s *memoryState) undoBatchItem(item BatchItem, userID uint64, recordSeq uint64, events int) {
+	if item.IdempotencyKey != "" {
+		delete(s.applied, appliedKey{key: item.IdempotencyKey, userID: userID})
+	}
+	s.recordSeq = recordSeq
+	s.events = s.events[:events]
+}
+
+func (s *memoryState) applyBatchItem(item BatchItem, userID uint64, now time.Time) error {
+	if item.IdempotencyKey != "" {
+		key := appliedKey{key: item.IdempotencyKey, userID: userID}
+		if _, ok := s.applied[key]; ok {
+			return ErrAlreadyApplied
+		}
+		s.applied[key] = now
+	}
+
+	switch item.Op {
+	case models.BatchUpsert:
+		if err := s.checkFolderOwner(item.Record.FolderID, userID); err != nil {
+			return err
+		}
+
+		folderID := item.Record.FolderID
+		existing, err := s.findRecord(item.Record.Name, &folderID, userID)
+		if err == nil {
+			item.Record.ID = existing.ID
+			item.Record.UploadedAt = now
+		}
+		if item.IfChecksum != "" && (err != nil || existing.Checksum != item.IfChecksum) {
+			return ErrRecordChanged
+		}
+
+		item.Record.UserID = userID
+		return s.saveRecord(item.Record, now)
+	case models.BatchDelete:
+		folderID := item.FolderID
+		record, err := s.findRecord(item.Name, &folderID, userID)
+		if err != nil {
+			return gorm.ErrRecordNotFound
+		}
+
+		record.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		s.records[record.ID] = record
+		s.emit(models.RecordDeletedEvent(userID, record.Name, record.FolderID, now))
+
+		return nil
+	default:
+		return fmt.Errorf("unknown batch operation: %s", item.Op)
+	}
+}
+
+func (m *MemoryStore) GetUserFolders(userID uint64) ([]models.Folder, error) {
+	m.mu.RLock()
+	folders := make([]models.Folder, 0)
+	for _, f := range m.state.folders {
+		if f.UserID == userID {
+			folders = append(folders, f)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(folders, func(i, j int) bool { return folders[i].ID < folders[j].ID })
+	models.FillFolderPaths(folders)
+
+	return folders, nil
+}
+
+func (m *MemoryStore) CreateFolder(folder *models.Folder) error {
+	return m.update(func(s *memoryState) error {
+		if err := s.checkFolderOwner(folder.ParentID, folder.UserID); err != nil {
+			return err
+		}
+		if s.folderNameTaken(folder.UserID, folder.ParentID, folder.Name, 0) {
+			return ErrDuplicateName
+		}
+
+		s.folderSeq++
+		folder.ID = s.folderSeq
+		folder.UpdatedAt = time.Now()
+		s.folders[folder.ID] = *folder
+		s.emit(models.FoldersChangedEvent(folder.UserID, folder.ID, folder.UpdatedAt))
+
+		return nil
+	})
+}
+
+// UpdateFolder renames the folder and moves it under ParentID.
+func (m *MemoryStore) UpdateFolder(folder *models.Folder) error {
+	return m.update(func(s *memoryState) error {
+		if err := s.checkFolderOwner(folder.ID, folder.UserID); err != nil {
+			return err
+		}
+		if err := s.checkFolderOwner(folder.ParentID, folder.UserID); err != nil {
+			return err
+		}
+
+		for parent := folder.ParentID; parent != models.RootFolderID; parent = s.folders[parent].ParentID {
+			if parent == folder.ID {
+				return ErrFolderCycle
+			}
+		}
+
+		if s.folderNameTaken(folder.UserID, folder.ParentID, folder.Name, folder.ID) {
+			return ErrDuplicateName
+		}
+
+		f := s.folders[folder.ID]
+		f.Name = folder.Name
+		f.ParentID = folder.ParentID
+		f.UpdatedAt = time.Now()
+		s.folders[f.ID] = f
+		s.emit(models.FoldersChangedEvent(f.UserID, f.ID, f.UpdatedAt))
+
+		return nil
+	})
+}
+
+// DeleteFolder removes the folder. Its records and subfolders are moved into the parent folder.
+func (m *MemoryStore) DeleteFolder(folderID uint64, userID uint64) error {
+	return m.update(func(s *memoryState) error {
+		folder, ok := s.folders[folderID]
+		if !ok || folder.UserID != userID {
+			return ErrFolderNotFound
+		}
+
+		now := time.Now()
+		for _, f := range s.folders {
+			if f.UserID != userID || f.ParentID != folderID {
+				continue
+			}
+			if s.folderNameTaken(userID, folder.ParentID, f.Name, f.ID) {
+				return ErrDuplicateName
+			}
+			f.ParentID = folder.ParentID
+			f.UpdatedAt = now
+			s.folders[f.ID] = f
+		}
+
+		moved := s.sortedRecords(func(r *models.DataRecord) bool {
+			return !r.DeletedAt.Valid && r.UserID == userID && r.FolderID == folderID
+		})
+		for i := range moved {
+			if s.recordNameTaken(userID, folder.ParentID, moved[i].Name, moved[i].ID) {
+				return ErrDuplicateName
+			}
+			moved[i].FolderID = folder.ParentID
+			moved[i].UpdatedAt = now
+			s.records[moved[i].ID] = cloneRecord(moved[i])
+		}
+
+		delete(s.folders, folderID)
+		s.emit(models.FoldersChangedEvent(userID, folderID, now))
+		for _, r := range moved {
+			s.emitRecord(r)
+		}
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) MoveRecords(ids []uint64, folderID uint64, userID uint64) error {
+	return m.update(func(s *memoryState) error {
+		if err := s.checkFolderOwner(folderID, userID); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		moved := make(map[uint64]bool, len(ids))
+		for _, id := range ids {
+			r, ok := s.records[id]
+			if moved[id] {
+				continue
+			}
+			if !ok || r.DeletedAt.Valid || r.UserID != userID {
+				return gorm.ErrRecordNotFound
+			}
+			if s.recordNameTaken(userID, folderID, r.Name, r.ID) {
+				return ErrDuplicateName
+			}
+
+			moved[id] = true
+			r.FolderID = folderID
+			r.UpdatedAt = now
+			s.records[id] = r
+			s.emitRecord(r)
+		}
+
+		return nil
+	})
+}
+
+// DeleteUserRecord moves a record into the trash.
+func (m *MemoryStore) DeleteUserRecord(recordName string, folderID *uint64, userID uint64) error {
+	return m.update(func(s *memoryState) error {
+		record, err := s.findRecord(recordName, folderID, userID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		record.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		s.records[record.ID] = record
+		s.emit(models.RecordDeletedEvent(userID, record.Name, record.FolderID, now))
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) GetDeletedRecords(userID uint64) ([]models.DataRecord, error) {
+	m.mu.RLock()
+	records := m.state.sortedRecords(func(r *models.DataRecord) bool {
+		return r.DeletedAt.Valid && r.UserID == userID
+	})
+	m.mu.RUnlock()
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].DeletedAt.Time.After(records[j].DeletedAt.Time) })
+
+	return records, nil
+}
+
+// RestoreUserRecord brings the most recently deleted record with the name back from the trash.
+func (m *MemoryStore) RestoreUserRecord(recordName string, folderID *uint64, userID uint64) (*models.DataRecord, error) {
+	var record models.DataRecord
+	err := m.update(func(s *memoryState) error {
+		deleted := s.sortedRecords(func(r *models.DataRecord) bool {
+			return r.DeletedAt.Valid && r.UserID == userID && r.Name == recordName &&
+				(folderID == nil || r.FolderID == *folderID)
+		})
+		if len(deleted) == 0 {
+			return fmt.Errorf("error getting deleted record: %w", gorm.ErrRecordNotFound)
+		}
+		sort.SliceStable(deleted, func(i, j int) bool { return deleted[i].DeletedAt.Time.After(deleted[j].DeletedAt.Time) })
+		record = deleted[0]
+
+		// the folder could have been deleted while the record was in the trash
+		if err := s.checkFolderOwner(record.FolderID, userID); err != nil {
+			record.FolderID = models.RootFolderID
+		}
+		if s.recordNameTaken(userID, record.FolderID, record.Name, record.ID) {
+			return ErrDuplicateName
+		}
+
+		record.DeletedAt = gorm.DeletedAt{}
+		record.UpdatedAt = time.Now()
+		s.records[record.ID] = cloneRecord(record)
+		s.emitRecord(record)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// PurgeDeletedRecords removes every record in the user's trash.
+func (m *MemoryStore) PurgeDeletedRecords(userID uint64) (int64, error) {
+	return m.purge(func(r *models.DataRecord) bool { return r.UserID == userID })
+}
+
+// PurgeExpiredRecords removes records of all users which were deleted before the moment.
+func (m *MemoryStore) PurgeExpiredRecords(before time.Time) (int64, error) {
+	return m.purge(func(r *models.DataRecord) bool { return r.DeletedAt.Time.Before(before) })
+}
+
+func (m *MemoryStore) purge(match func(r *models.DataRecord) bool) (int64, error) {
+	var purged []models.DataRecord
+	err := m.update(func(s *memoryState) error {
+		purged = s.sortedRecords(func(r *models.DataRecord) bool { return r.DeletedAt.Valid && match(r) })
+		for _, r := range purged {
+			delete(s.records, r.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removeRecordFiles(purged)
+
+	return int64(len(purged)), nil
+}
+
+// PurgeAppliedOperations forgets idempotency keys of operations applied before the time.
+func (m *MemoryStore) PurgeAppliedOperations(before time.Time) (int64, error) {
+	var purged int64
+	err := m.update(func(s *memoryState) error {
+		for k, t := range s.applied {
+			if t.Before(before) {
+				delete(s.applied, k)
+				purged++
+			}
+		}
+		return nil
+	})
+
+	return purged, err
+}
+
+// PurgeChanges does nothing, changes are published right away and not logged.
+func (m *MemoryStore) PurgeChanges(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// ListenChanges waits for ctx, the memory store publishes changes itself.
+func (m *MemoryStore) ListenChanges(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (m *MemoryStore) GetDataKey(userID uint64) (*models.DataKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.state.dataKeys[userID]
+	if !ok {
+		return nil, fmt.Errorf("error getting data key: %w", gorm.ErrRecordNotFound)
+	}
+	key.Wrapped = append([]byte(nil), key.Wrapped...)
+
+	return &key, nil
+}
+
+func (m *MemoryStore) CreateDataKey(key *models.DataKey) error {
+	return m.update(func(s *memoryState) error {
+		if _, ok := s.dataKeys[key.UserID]; ok {
+			return ErrDataKeyExists
+		}
+
+		key.UpdatedAt = time.Now()
+		s.dataKeys[key.UserID] = *key
+		return nil
+	})
+}
+
+// GetStaleDataKeys finds data keys wrapped by master keys older than version.
+func (m *MemoryStore) GetStaleDataKeys(version uint32, limit int) ([]models.DataKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]models.DataKey, 0)
+	for _, k := range m.state.dataKeys {
+		if k.MasterVersion < version {
+			res = append(res, k)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID < res[j].UserID })
+	if len(res) > limit {
+		res = res[:limit]
+	}
+
+	return res, nil
+}
+
+// RewrapDataKey replaces the wrapped key unless it was rewrapped since it was read with oldVersion.
+func (m *MemoryStore) RewrapDataKey(key *models.DataKey, oldVersion uint32) error {
+	return m.update(func(s *memoryState) error {
+		if stored, ok := s.dataKeys[key.UserID]; ok && stored.MasterVersion == oldVersion {
+			key.UpdatedAt = time.Now()
+			s.dataKeys[key.UserID] = *key
+		}
+		return nil
+	})
+}
+
+// GetUnsealedRecords finds records with data not starting with prefix, trashed ones included, by id.
+func (m *MemoryStore) GetUnsealedRecords(prefix string, afterID uint64, limit int) ([]models.DataRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := m.state.sortedRecords(func(r *models.DataRecord) bool {
+		return r.ID > afterID && !strings.HasPrefix(r.Data, prefix)
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+
+	return res, nil
+}
+
+// ResealRecord replaces the data and checksum unless the data was changed since it was read as oldData.
+func (m *MemoryStore) ResealRecord(record *models.DataRecord, oldData string) error {
+	return m.update(func(s *memoryState) error {
+		stored, ok := s.records[record.ID]
+		if !ok || stored.Data != oldData {
+			return nil
+		}
+
+		stored.Data = record.Data
+		stored.Checksum = record.Checksum
+		stored.UpdatedAt = time.Now()
+		s.records[record.ID] = stored
+		return nil
+	})
+}
+
+func (m *MemoryStore) AppendAuditEvent(e *models.AuditEvent, key []byte) error {
+	return m.update(func(s *memoryState) error {
+		var last *models.AuditEvent
+		for i := len(s.audit) - 1; i >= 0; i-- {
+			if s.audit[i].UserID == e.UserID {
+				last = &s.audit[i]
+				break
+			}
+		}
+
+		chainAuditEvent(e, last, key)
+		s.auditSeq++
+		e.ID = s.auditSeq
+		s.audit = append(s.audit, *e)
+
+		return nil
+	})
+}
+
+// PurgeAuditLog removes events created before the time from the start of chains, the last
+// event of every chain is kept.
+func (m *MemoryStore) PurgeAuditLog(before time.Time, key []byte) (int64, error) {
+	var purged int64
+	err := m.update(func(s *memoryState) error {
+		// events of a user are appended in the order of their seq
+		chains := make(map[uint64]*auditChainBounds)
+		for _, e := range s.audit {
+			c, ok := chains[e.UserID]
+			if !ok {
+				c = &auditChainBounds{UserID: e.UserID, FirstSeq: e.Seq}
+				chains[e.UserID] = c
+			}
+			c.LastSeq = e.Seq
+			if c.KeepSeq == nil && !e.CreatedAt.Before(before) {
+				seq := e.Seq
+				c.KeepSeq = &seq
+			}
+		}
+
+		ends := make(map[uint64]models.AuditEvent)
+		for _, e := range s.audit {
+			if e.Seq == chains[e.UserID].purgeEnd() {
+				ends[e.UserID] = e
+			}
+		}
+
+		watermarks := make(map[uint64]models.AuditWatermark, len(s.watermarks)+len(ends))
+		for id, w := range s.watermarks {
+			watermarks[id] = w
+		}
+		for id, end := range ends {
+			w := models.AuditWatermark{UserID: id, Seq: end.Seq + 1, PrevHash: end.Hash}
+			w.Hash = w.ComputeHash(key)
+			watermarks[id] = w
+		}
+		s.watermarks = watermarks
+
+		kept := make([]models.AuditEvent, 0, len(s.audit))
+		for _, e := range s.audit {
+			if end, ok := ends[e.UserID]; ok && e.Seq <= end.Seq {
+				purged++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		s.audit = kept[:len(kept):len(kept)]
+
+		return nil
+	})
+
+	return purged, err
+}
+
+func (m *MemoryStore) GetAuditWatermarks() ([]models.AuditWatermark, error) {
+	m.mu.RLock()
+	watermarks := make([]models.AuditWatermark, 0, len(m.state.watermarks))
+	for _, w := range m.state.watermarks {
+		watermarks = append(watermarks, w)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(watermarks, func(i, j int) bool { return watermarks[i].UserID < watermarks[j].UserID })
+
+	return watermarks, nil
+}
+
+func (m *MemoryStore) GetAuditEvents(userID uint64, query models.AuditQuery) (*models.AuditPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = models.DefaultAuditLimit
+	}
+
+	actions := make(map[models.AuditAction]bool, len(query.Actions))
+	for _, a := range query.Actions {
+		actions[a] = true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// events of a user are appended in the order of their seq
+	events := make([]models.AuditEvent, 0, limit+1)
+	for i := len(m.state.audit) - 1; i >= 0 && len(events) <= limit; i-- {
+		e := m.state.audit[i]
+		switch {
+		case e.UserID != userID,
+			len(actions) != 0 && !actions[e.Action],
+			!query.Since.IsZero() && e.CreatedAt.Before(query.Since),
+			!query.Until.IsZero() && !e.CreatedAt.Before(query.Until),
+			query.Target != "" && e.Target != query.Target,
+			query.Before != 0 && e.Seq >= query.Before:
+			continue
+		}
+		events = append(events, e)
+	}
+
+	return auditPage(events, limit), nil
+}
+
+func (m *MemoryStore) ScanAuditLog(afterUserID uint64, afterSeq uint64, limit int) ([]models.AuditEvent, error) {
+	m.mu.RLock()
+	events := make([]models.AuditEvent, 0)
+	for _, e := range m.state.audit {
+		if e.UserID > afterUserID || (e.UserID == afterUserID && e.Seq > afterSeq) {
+			events = append(events, e)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].UserID != events[j].UserID {
+			return events[i].UserID < events[j].UserID
+		}
+		return events[i].Seq < events[j].Seq
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	return events, nil
+}
+
+func (m *MemoryStore) RecordStats() ([]models.RecordStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byType := make(map[models.DataType]*models.RecordStats)
+	for _, r := range m.state.records {
+		if r.DeletedAt.Valid {
+			continue
+		}
+
+		stats, ok := byType[r.Type]
+		if !ok {
+			stats = &models.RecordStats{Type: r.Type}
+			byType[r.Type] = stats
+		}
+		stats.Count++
+		stats.Bytes += int64(len(r.Data))
+	}
+
+	res := make([]models.RecordStats, 0, len(byType))
+	for _, stats := range byType {
+		res = append(res, *stats)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Type < res[j].Type })
+
+	return res, nil
+}
+
+func (m *MemoryStore) DBStats() (sql.DBStats, bool) {
+	return sql.DBStats{}, false
+}
+
+func (m *MemoryStore) Ping() error {
+	return nil
+}
+
+// CheckMigrations has nothing to check, the memory store has no schema.
+func (m *MemoryStore) CheckMigrations() error {
+	return nil
+}
+
+func (m *MemoryStore) Close() {}
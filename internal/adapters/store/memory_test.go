@@ -0,0 +1,15 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/adapters/store/storetest"
+	"github.com/rawen554/goph-keeper/internal/events"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, publisher events.Publisher) store.Store {
+		return store.NewMemoryStore(publisher)
+	})
+}
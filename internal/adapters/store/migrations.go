@@ -0,0 +1,64 @@
+package store
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles lists the up migrations embedded in dir by version.
+func migrationFiles(dir string) ([]string, error) {
+	files, err := fs.Glob(migrationsDir, dir+"/*.up.sql")
+	if err != nil {
+		return nil, fmt.Errorf("error listing migrations: %w", err)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// migrationVersion is the number the migration file name starts with.
+func migrationVersion(file string) (uint64, error) {
+	version, err := strconv.ParseUint(strings.SplitN(path.Base(file), "_", 2)[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad migration name %s: %w", file, err)
+	}
+
+	return version, nil
+}
+
+// CheckMigrations fails when the schema is behind the migrations of the build or a migration
+// has failed halfway. A schema migrated further by a newer replica is fine.
+func (db *DBStore) CheckMigrations() error {
+	files, err := migrationFiles(db.dialect.migrations)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	latest, err := migrationVersion(files[len(files)-1])
+	if err != nil {
+		return err
+	}
+
+	var state struct {
+		Version uint64
+		Dirty   bool
+	}
+	if err := db.conn.Raw(db.dialect.migrationState).Scan(&state).Error; err != nil {
+		return fmt.Errorf("error reading migration state: %w", err)
+	}
+
+	if state.Dirty {
+		return fmt.Errorf("migration %d has failed halfway", state.Version)
+	}
+	if state.Version < latest {
+		return fmt.Errorf("schema version is %d, %d is expected", state.Version, latest)
+	}
+
+	return nil
+}
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS idx_records_user_folder_name;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_records_name ON data_records (name);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+-- record names were unique across all users, they are unique per user folder now
+DROP INDEX IF EXISTS idx_data_records_name;
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS idx_records_user_folder_name_alive;
+
+-- there is no trash before soft deletion, trashed records would be alive again and clash by name
+DELETE FROM data_records WHERE deleted_at IS NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_records_user_folder_name ON data_records (user_id, folder_id, name);
+
+COMMIT;
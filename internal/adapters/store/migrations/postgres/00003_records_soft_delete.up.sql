@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+-- deleted records stay in the trash, names are unique among alive records only
+DROP INDEX IF EXISTS idx_records_user_folder_name;
+
+COMMIT;
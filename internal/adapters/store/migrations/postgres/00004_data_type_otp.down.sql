@@ -0,0 +1,2 @@
+-- enum values cannot be dropped from a type, OTP is left in data_type
+SELECT 1;
@@ -0,0 +1 @@
+ALTER TYPE data_type ADD VALUE IF NOT EXISTS 'OTP';
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS idx_data_records_search;
+ALTER TABLE IF EXISTS data_records DROP COLUMN IF EXISTS metadata;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+-- records of databases created before metadata get an empty object, new databases get the column from gorm
+ALTER TABLE IF EXISTS data_records ADD COLUMN IF NOT EXISTS metadata jsonb DEFAULT '{}';
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE IF EXISTS users DROP COLUMN IF EXISTS reset_expires_at;
+ALTER TABLE IF EXISTS users DROP COLUMN IF EXISTS reset_code_hash;
+
+COMMIT;
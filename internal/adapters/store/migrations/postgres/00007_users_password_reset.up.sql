@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+-- one-time codes of forced password resets, accounts registered while passwords were not decoded need one
+ALTER TABLE IF EXISTS users ADD COLUMN IF NOT EXISTS reset_code_hash varchar(64);
+ALTER TABLE IF EXISTS users ADD COLUMN IF NOT EXISTS reset_expires_at timestamptz;
+
+COMMIT;
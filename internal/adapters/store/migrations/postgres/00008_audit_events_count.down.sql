@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE IF EXISTS audit_events DROP COLUMN IF EXISTS count;
+
+COMMIT;
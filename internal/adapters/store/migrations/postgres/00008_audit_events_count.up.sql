@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+-- events logged before failed logins were summed up count none, new databases get the column from gorm
+ALTER TABLE IF EXISTS audit_events ADD COLUMN IF NOT EXISTS count bigint NOT NULL DEFAULT 0;
+
+COMMIT;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS changes;
+DROP TABLE IF EXISTS applied_operations;
+DROP TABLE IF EXISTS data_records;
+DROP TABLE IF EXISTS folders;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,59 @@
+-- SQLite has no auto migration of the models, the schema mirrors the Postgres one.
+-- Times are stored as text and compared as text, so defaults have the layout the driver writes:
+-- UTC, fractional seconds without trailing zeros, e.g. '2023-01-02 15:04:05.12+00:00'.
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    login VARCHAR(100),
+    password VARCHAR(255) NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_login ON users (login);
+
+CREATE TABLE IF NOT EXISTS folders (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    updated_at DATETIME NOT NULL DEFAULT (rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', 'now'), '0'), '.') || '+00:00'),
+    name TEXT NOT NULL,
+    parent_id INTEGER NOT NULL DEFAULT 0,
+    user_id INTEGER REFERENCES users (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_user_parent_name ON folders (user_id, parent_id, name);
+
+CREATE TABLE IF NOT EXISTS data_records (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uploaded_at DATETIME DEFAULT (rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', 'now'), '0'), '.') || '+00:00'),
+    updated_at DATETIME NOT NULL DEFAULT (rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', 'now'), '0'), '.') || '+00:00'),
+    deleted_at DATETIME,
+    metadata TEXT DEFAULT '{}',
+    type TEXT NOT NULL CHECK (type IN ('PASS', 'TEXT', 'BIN', 'CARD', 'OTP', 'SSHKEY')),
+    checksum TEXT,
+    data TEXT,
+    file_path TEXT,
+    name TEXT NOT NULL,
+    user_id INTEGER REFERENCES users (id),
+    folder_id INTEGER NOT NULL DEFAULT 0,
+    blocked NUMERIC
+);
+CREATE INDEX IF NOT EXISTS idx_data_records_updated_at ON data_records (updated_at);
+CREATE INDEX IF NOT EXISTS idx_data_records_deleted_at ON data_records (deleted_at);
+-- deleted records stay in the trash, names are unique among alive records only
+CREATE UNIQUE INDEX IF NOT EXISTS idx_records_user_folder_name_alive
+    ON data_records (user_id, folder_id, name) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS applied_operations (
+    created_at DATETIME NOT NULL DEFAULT (rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', 'now'), '0'), '.') || '+00:00'),
+    "key" VARCHAR(128),
+    user_id INTEGER,
+    PRIMARY KEY ("key", user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_applied_operations_created_at ON applied_operations (created_at);
+
+CREATE TABLE IF NOT EXISTS changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    revision DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT (rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', 'now'), '0'), '.') || '+00:00'),
+    type TEXT NOT NULL,
+    name TEXT,
+    user_id INTEGER NOT NULL,
+    record_id INTEGER,
+    folder_id INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_changes_created_at ON changes (created_at);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS data_keys (
+    updated_at DATETIME NOT NULL DEFAULT (rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', 'now'), '0'), '.') || '+00:00'),
+    wrapped BLOB NOT NULL,
+    user_id INTEGER PRIMARY KEY,
+    master_version INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_data_keys_master_version ON data_keys (master_version);
@@ -0,0 +1,11 @@
+-- a row is present only while the audit log is purged, old events may be deleted then
+CREATE TABLE IF NOT EXISTS audit_purge (
+    active INTEGER NOT NULL
+);
+
+DROP TRIGGER IF EXISTS audit_events_no_delete;
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+WHEN NOT EXISTS (SELECT 1 FROM audit_purge)
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN reset_expires_at;
+ALTER TABLE users DROP COLUMN reset_code_hash;
@@ -0,0 +1,3 @@
+-- one-time codes of forced password resets, accounts registered while passwords were not decoded need one
+ALTER TABLE users ADD COLUMN reset_code_hash VARCHAR(64);
+ALTER TABLE users ADD COLUMN reset_expires_at DATETIME;
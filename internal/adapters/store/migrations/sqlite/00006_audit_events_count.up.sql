@@ -0,0 +1,2 @@
+-- events logged before failed logins were summed up count none
+ALTER TABLE audit_events ADD COLUMN count INTEGER NOT NULL DEFAULT 0;
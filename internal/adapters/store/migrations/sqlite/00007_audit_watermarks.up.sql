@@ -0,0 +1,7 @@
+-- where every chain starts after a purge, hashed with the audit key
+CREATE TABLE IF NOT EXISTS audit_watermarks (
+    user_id INTEGER PRIMARY KEY,
+    seq INTEGER NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+	"time"
+
+	sqlitedriver "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite"
+	"github.com/rawen554/goph-keeper/internal/events"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"github.com/rawen554/goph-keeper/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqliteOptions are appended to the database file name. Times are written in a sortable
+// format, and transactions take the write lock at once, so that concurrent ones wait
+// for each other instead of failing.
+const sqliteOptions = "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)" +
+	"&_pragma=case_sensitive_like(1)&_time_format=sqlite&_txlock=immediate"
+
+func init() {
+	sqlitedriver.MustRegisterDeterministicScalarFunction("has_tag", 2,
+		func(ctx *sqlitedriver.FunctionContext, args []driver.Value) (driver.Value, error) {
+			tags, _ := args[0].(string)
+			tag, _ := args[1].(string)
+			return models.Metadata{models.MetaTags: tags}.HasTag(tag), nil
+		})
+}
+
+// NewSQLiteStore opens the embedded database in the file and migrates it. It is meant for
+// a single server, changes are relayed to publisher by polling the revision log.
+func NewSQLiteStore(ctx context.Context, file string, logLevel string, publisher events.Publisher) (Store, error) {
+	if file == "" {
+		return nil, fmt.Errorf("sqlite database file is not set")
+	}
+
+	dsn := file
+	if strings.Contains(dsn, "?") {
+		dsn += "&" + sqliteOptions
+	} else {
+		dsn += "?" + sqliteOptions
+	}
+
+	conn, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		TranslateError: true,
+		// Times are compared as text, so all of them are kept in UTC.
+		NowFunc: func() time.Time { return time.Now().UTC() },
+		Logger:  logger.Default.LogMode(logger.LogLevel(utils.ConvertLogLevelToInt(logLevel))),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cant open sqlite db: %w", err)
+	}
+
+	if err := runSQLiteMigrations(conn.WithContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	log.Printf("successfully opened the database %s", file)
+
+	return &DBStore{conn: conn, publisher: publisher, dialect: sqliteDialect, dsn: dsn}, nil
+}
+
+// runSQLiteMigrations applies the embedded migrations which are not applied yet, each in its transaction.
+func runSQLiteMigrations(conn *gorm.DB) error {
+	if err := conn.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)").Error; err != nil {
+		return fmt.Errorf("error creating migrations table: %w", err)
+	}
+
+	var applied []uint64
+	if err := conn.Table("schema_migrations").Pluck("version", &applied).Error; err != nil {
+		return fmt.Errorf("error reading applied migrations: %w", err)
+	}
+	done := make(map[uint64]bool, len(applied))
+	for _, v := range applied {
+		done[v] = true
+	}
+
+	files, err := migrationFiles(sqliteDialect.migrations)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		version, err := migrationVersion(f)
+		if err != nil {
+			return err
+		}
+		if done[version] {
+			continue
+		}
+
+		script, err := fs.ReadFile(migrationsDir, f)
+		if err != nil {
+			return fmt.Errorf("error reading migration %s: %w", f, err)
+		}
+
+		err = conn.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(string(script)).Error; err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", f, err)
+		}
+	}
+
+	return nil
+}
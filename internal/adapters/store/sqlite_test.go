@@ -0,0 +1,26 @@
+package store_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/adapters/store/storetest"
+	"github.com/rawen554/goph-keeper/internal/events"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, newSQLiteStore)
+}
+
+func newSQLiteStore(t *testing.T, publisher events.Publisher) store.Store {
+	t.Helper()
+
+	s, err := store.NewSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "gophkeeper.db"), "error", publisher)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+
+	return s
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// RecordStats counts records of every type, the ones in the trash are left out.
+func (db *DBStore) RecordStats() ([]models.RecordStats, error) {
+	stats := make([]models.RecordStats, 0)
+	if err := db.conn.Model(&models.DataRecord{}).
+		Select("type, count(*) AS count, coalesce(sum(" + db.dialect.dataBytes + "), 0) AS bytes").
+		Group("type").
+		Scan(&stats).Error; err != nil {
+		return nil, fmt.Errorf("error counting records: %w", err)
+	}
+
+	return stats, nil
+}
+
+// DBStats reports the connection pool of the database.
+func (db *DBStore) DBStats() (sql.DBStats, bool) {
+	sqlDB, err := db.conn.DB()
+	if err != nil {
+		log.Printf("gorm cant get sql.DB interface: %v", err)
+		return sql.DBStats{}, false
+	}
+
+	return sqlDB.Stats(), true
+}
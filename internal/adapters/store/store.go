@@ -2,35 +2,83 @@ package store
 
 import (
 	"context"
+	"database/sql"
 	"embed"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rawen554/goph-keeper/internal/events"
 	"github.com/rawen554/goph-keeper/internal/models"
 	"github.com/rawen554/goph-keeper/internal/utils"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
+// DBStore keeps data in a SQL database through gorm, see NewStore and NewSQLiteStore.
 type DBStore struct {
-	conn *gorm.DB
+	conn      *gorm.DB
+	publisher events.Publisher
+	dialect   dialect
+	dsn       string
 }
 
 type Store interface {
 	CreateUser(user *models.User) (int64, error)
 	GetUser(u *models.User) (*models.User, error)
+	// GetUsers lists users with ids after afterID, by id.
+	GetUsers(afterID uint64, limit int) ([]models.User, error)
+	// SetPasswordReset saves the hash of a one-time reset code of the user, replacing an earlier one.
+	SetPasswordReset(userID uint64, codeHash string, expiresAt time.Time) error
+	// ResetPassword sets the password hash of the login if its reset code is codeHash and has not
+	// expired at now. The code is used up, any other one fails with ErrResetCodeInvalid.
+	ResetPassword(login string, codeHash string, now time.Time, passwordHash string) error
 	PutDataRecord(data *models.DataRecord, userID uint64) error
-	GetUserRecord(recordName string, userID uint64) (*models.DataRecord, error)
-	GetUserRecords(userID uint64) ([]models.DataRecord, error)
+	GetUserRecord(recordName string, folderID *uint64, userID uint64) (*models.DataRecord, error)
+	GetUserRecords(userID uint64, query models.RecordsQuery) (*models.RecordsPage, error)
+	ApplyBatch(items []BatchItem, userID uint64, atomic bool) ([]error, error)
+	// SearchUserRecords returns matching records without their data, secrets never show up in search results.
+	SearchUserRecords(userID uint64, query string, limit int) ([]models.DataRecord, error)
+	GetUserFolders(userID uint64) ([]models.Folder, error)
+	CreateFolder(folder *models.Folder) error
+	UpdateFolder(folder *models.Folder) error
+	DeleteFolder(folderID uint64, userID uint64) error
+	MoveRecords(ids []uint64, folderID uint64, userID uint64) error
+	DeleteUserRecord(recordName string, folderID *uint64, userID uint64) error
+	GetDeletedRecords(userID uint64) ([]models.DataRecord, error)
+	RestoreUserRecord(recordName string, folderID *uint64, userID uint64) (*models.DataRecord, error)
+	PurgeDeletedRecords(userID uint64) (int64, error)
+	PurgeExpiredRecords(before time.Time) (int64, error)
+	PurgeAppliedOperations(before time.Time) (int64, error)
+	PurgeChanges(before time.Time) (int64, error)
+	ListenChanges(ctx context.Context) error
+	GetDataKey(userID uint64) (*models.DataKey, error)
+	CreateDataKey(key *models.DataKey) error
+	GetStaleDataKeys(version uint32, limit int) ([]models.DataKey, error)
+	RewrapDataKey(key *models.DataKey, oldVersion uint32) error
+	// GetUnsealedRecords finds records with data not starting with prefix, trashed ones included, by id.
+	GetUnsealedRecords(prefix string, afterID uint64, limit int) ([]models.DataRecord, error)
+	// ResealRecord replaces the data and checksum unless the data was changed since it was read as oldData.
+	ResealRecord(record *models.DataRecord, oldData string) error
+	AppendAuditEvent(e *models.AuditEvent, key []byte) error
+	// PurgeAuditLog removes events created before the time from the start of chains and moves
+	// the watermarks of the chains, which are hashed with key.
+	PurgeAuditLog(before time.Time, key []byte) (int64, error)
+	GetAuditWatermarks() ([]models.AuditWatermark, error)
+	GetAuditEvents(userID uint64, query models.AuditQuery) (*models.AuditPage, error)
+	ScanAuditLog(afterUserID uint64, afterSeq uint64, limit int) ([]models.AuditEvent, error)
+	RecordStats() ([]models.RecordStats, error)
+	// DBStats reports the connection pool, ok is false for stores without one.
+	DBStats() (stats sql.DBStats, ok bool)
 	Ping() error
+	CheckMigrations() error
 	Close()
 }
 
@@ -44,11 +92,54 @@ var ErrURLDeleted = errors.New("url is deleted")
 var ErrLoginNotFound = errors.New("login not found")
 var ErrDuplicateLogin = errors.New("login already registered")
 var ErrNotEnoughAmount = errors.New("not enough balance")
+var ErrBatchAborted = errors.New("batch aborted by a failed operation")
+var ErrRecordChanged = errors.New("record has been changed since it was read")
+var ErrFolderNotFound = errors.New("folder not found")
+var ErrDuplicateName = errors.New("name is already taken in the folder")
+var ErrFolderCycle = errors.New("folder cannot be moved into itself")
+var ErrAlreadyApplied = errors.New("operation with the idempotency key is already applied")
+var ErrDataKeyExists = errors.New("user already has a data key")
+var ErrResetCodeInvalid = errors.New("password reset code is invalid or expired")
+
+// BatchItem is a validated operation of a batch request.
+// Record is set for upserts, Name for deletions.
+type BatchItem struct {
+	Record         *models.DataRecord
+	Op             models.BatchOp
+	Name           string
+	IdempotencyKey string
+	IfChecksum     string
+	FolderID       uint64
+}
+
+// Storage backends selected by the storage setting.
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory"
+)
+
+// Open creates the store of the storage backend. dsn is the Postgres DSN or the SQLite
+// database file, the memory store does not use it.
+func Open(ctx context.Context, storage string, dsn string, logLevel string, publisher events.Publisher) (Store, error) {
+	switch storage {
+	case StoragePostgres, "":
+		return NewStore(ctx, dsn, logLevel, publisher)
+	case StorageSQLite:
+		return NewSQLiteStore(ctx, dsn, logLevel, publisher)
+	case StorageMemory:
+		return NewMemoryStore(publisher), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", storage)
+	}
+}
 
-func NewStore(ctx context.Context, dsn string, logLevel string) (Store, error) {
+// NewStore connects to the Postgres database and migrates it. Committed changes of user data
+// are published as events to publisher by ListenChanges.
+func NewStore(ctx context.Context, dsn string, logLevel string, publisher events.Publisher) (Store, error) {
 	conn, err := gorm.Open(postgres.New(postgres.Config{
 		DSN: dsn,
-	}), &gorm.Config{})
+	}), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, fmt.Errorf("cant connect to db: %w", err)
 	}
@@ -62,20 +153,31 @@ func NewStore(ctx context.Context, dsn string, logLevel string) (Store, error) {
 	}
 
 	conn.Logger = logger.Default.LogMode(logger.LogLevel(utils.ConvertLogLevelToInt(logLevel)))
-	if err := conn.AutoMigrate(&models.User{}, &models.DataRecord{}); err != nil {
+	if err := conn.AutoMigrate(
+		&models.User{}, &models.Folder{}, &models.DataRecord{}, &models.AppliedOperation{},
+		&models.Change{}, &models.DataKey{}, &models.AuditEvent{}, &models.AuditWatermark{},
+	); err != nil {
 		return nil, fmt.Errorf("error auto migrating models: %w", err)
 	}
 
+	if err := createSearchIndexes(conn); err != nil {
+		return nil, err
+	}
+
+	if err := createAuditTriggers(conn); err != nil {
+		return nil, err
+	}
+
 	log.Println("successfully connected to the database")
 
-	return &DBStore{conn: conn}, nil
+	return &DBStore{conn: conn, publisher: publisher, dialect: postgresDialect, dsn: dsn}, nil
 }
 
-//go:embed migrations/*.sql
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
 var migrationsDir embed.FS
 
 func runMigrations(dsn string) error {
-	d, err := iofs.New(migrationsDir, "migrations")
+	d, err := iofs.New(migrationsDir, "migrations/postgres")
 	if err != nil {
 		return fmt.Errorf("failed to return an iofs driver: %w", err)
 	}
@@ -92,6 +194,25 @@ func runMigrations(dsn string) error {
 	return nil
 }
 
+// searchExpr is the non-secret text of a record which search runs over.
+const searchExpr = `lower(name || ' ' || coalesce(metadata->>'url', '') || ' ' || coalesce(metadata->>'tags', ''))`
+
+// createSearchIndexes builds the trigram index for record search.
+// It runs after auto migration, because the records table is created by gorm.
+func createSearchIndexes(conn *gorm.DB) error {
+	if err := conn.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return fmt.Errorf("error creating pg_trgm extension: %w", err)
+	}
+
+	if err := conn.Exec(
+		"CREATE INDEX IF NOT EXISTS idx_data_records_search ON data_records USING gin ((" + searchExpr + ") gin_trgm_ops)",
+	).Error; err != nil {
+		return fmt.Errorf("error creating search index: %w", err)
+	}
+
+	return nil
+}
+
 func prepareConnPool(conn *gorm.DB) error {
 	sqlDB, err := conn.DB()
 	if err != nil {
@@ -109,11 +230,8 @@ func (db *DBStore) CreateUser(user *models.User) (int64, error) {
 	result := db.conn.Create(user)
 
 	if result.Error != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(result.Error, &pgErr) {
-			if pgErr.Code == pgerrcode.UniqueViolation {
-				return 0, ErrDuplicateLogin
-			}
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return 0, ErrDuplicateLogin
 		}
 
 		log.Printf("error saving user to db: %v", result.Error)
@@ -134,18 +252,29 @@ func (db *DBStore) GetUser(u *models.User) (*models.User, error) {
 }
 
 func (db *DBStore) PutDataRecord(data *models.DataRecord, userID uint64) error {
-	result := db.conn.Where("user_id = ?", userID).Save(&data)
+	return db.conn.Transaction(func(tx *gorm.DB) error {
+		if err := checkFolderOwner(tx, data.FolderID, userID); err != nil {
+			return err
+		}
 
-	if err := result.Error; err != nil {
-		return fmt.Errorf("error saving data: %w", err)
-	}
+		result := tx.Where("user_id = ?", userID).Save(&data)
 
-	return nil
+		if err := result.Error; err != nil {
+			return folderError(err)
+		}
+
+		return db.logChanges(tx, models.RecordChangedEvent(data))
+	})
 }
 
-func (db *DBStore) GetUserRecord(recordName string, userID uint64) (*models.DataRecord, error) {
+// GetUserRecord finds a record by name in the folder, or in any folder when folderID is nil.
+func (db *DBStore) GetUserRecord(recordName string, folderID *uint64, userID uint64) (*models.DataRecord, error) {
 	record := models.DataRecord{}
-	result := db.conn.Where(&models.DataRecord{UserID: userID, Name: recordName}).First(&record)
+	tx := db.conn.Where(&models.DataRecord{UserID: userID, Name: recordName})
+	if folderID != nil {
+		tx = tx.Where("folder_id = ?", *folderID)
+	}
+	result := tx.Order("folder_id").First(&record)
 
 	if err := result.Error; err != nil {
 		return nil, fmt.Errorf("error getting order: %w", err)
@@ -154,21 +283,233 @@ func (db *DBStore) GetUserRecord(recordName string, userID uint64) (*models.Data
 	return &record, nil
 }
 
-func (db *DBStore) GetUserRecords(userID uint64) ([]models.DataRecord, error) {
-	records := make([]models.DataRecord, 0)
-	result := db.conn.Where(&models.DataRecord{UserID: userID}).Find(&records)
+func (db *DBStore) GetUserRecords(userID uint64, query models.RecordsQuery) (*models.RecordsPage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > models.MaxRecordsLimit {
+		limit = models.DefaultRecordsLimit
+	}
+	column, desc := query.Sort.Column()
 
+	tx := db.conn.Where(&models.DataRecord{UserID: userID})
+	if query.Type != "" {
+		tx = tx.Where("type = ?", query.Type)
+	}
+	if query.FolderID != nil {
+		tx = tx.Where("folder_id = ?", *query.FolderID)
+	}
+	if query.NamePrefix != "" {
+		tx = tx.Where("name LIKE ? ESCAPE '\\'", escapeLike(query.NamePrefix)+"%")
+	}
+	if query.Tag != "" {
+		tx = tx.Where(db.dialect.tagFilter, query.Tag)
+	}
+	if !query.UpdatedSince.IsZero() {
+		tx = tx.Where("updated_at > ?", query.UpdatedSince.UTC())
+	}
+
+	if query.Cursor != "" {
+		cursor, err := models.DecodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		var value interface{} = cursor.Name
+		if column == "updated_at" {
+			value = cursor.UpdatedAt.UTC()
+		}
+		tx = tx.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, op), value, cursor.ID)
+	}
+
+	order := "ASC"
+	if desc {
+		order = "DESC"
+	}
+
+	records := make([]models.DataRecord, 0, limit)
+	result := tx.Order(fmt.Sprintf("%s %s, id %s", column, order, order)).Limit(limit + 1).Find(&records)
 	if err := result.Error; err != nil {
-		return nil, fmt.Errorf("error getting all user orders: %w", err)
+		return nil, fmt.Errorf("error getting user records: %w", err)
+	}
+
+	page := &models.RecordsPage{Records: records}
+	if len(records) > limit {
+		page.Records = records[:limit]
+		page.NextCursor = models.CursorAfter(&page.Records[limit-1]).Encode()
 	}
 
-	if len(records) == 0 {
-		return nil, models.ErrNoData
+	return page, nil
+}
+
+// SearchUserRecords finds records whose name, URL or tags contain query or are similar to it.
+// The data column is not read.
+func (db *DBStore) SearchUserRecords(userID uint64, query string, limit int) ([]models.DataRecord, error) {
+	if limit <= 0 || limit > models.MaxRecordsLimit {
+		limit = models.DefaultRecordsLimit
+	}
+	query = strings.ToLower(query)
+
+	records := make([]models.DataRecord, 0)
+	tx := db.conn.Where(&models.DataRecord{UserID: userID})
+	if db.dialect.fuzzySearch {
+		tx = tx.
+			Where("("+searchExpr+" LIKE ? ESCAPE '\\' OR ? <% "+searchExpr+")", "%"+escapeLike(query)+"%", query).
+			Clauses(clause.OrderBy{Expression: clause.Expr{
+				SQL:  "word_similarity(?, " + searchExpr + ") DESC, name",
+				Vars: []interface{}{query},
+			}})
+	} else {
+		tx = tx.Where(searchExpr+" LIKE ? ESCAPE '\\'", "%"+escapeLike(query)+"%").Order("name")
+	}
+	result := tx.Omit("data").Limit(limit).Find(&records)
+	if err := result.Error; err != nil {
+		return nil, fmt.Errorf("error searching user records: %w", err)
 	}
 
 	return records, nil
 }
 
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
+// ApplyBatch runs every item in a single transaction and returns an error per item.
+// In atomic mode the first failure rolls back the whole batch and every item is reported
+// as failed, otherwise each item is isolated by a savepoint and the rest are committed.
+func (db *DBStore) ApplyBatch(items []BatchItem, userID uint64, atomic bool) ([]error, error) {
+	errs := make([]error, len(items))
+	failed := false
+
+	err := db.conn.Transaction(func(tx *gorm.DB) error {
+		for i, item := range items {
+			savepoint := fmt.Sprintf("batch_item_%d", i)
+			if !atomic {
+				if err := tx.SavePoint(savepoint).Error; err != nil {
+					return fmt.Errorf("error creating savepoint: %w", err)
+				}
+			}
+
+			err := applyBatchItem(tx, item, userID)
+			if err == nil || errors.Is(err, ErrAlreadyApplied) {
+				errs[i] = err
+				continue
+			}
+
+			failed = true
+			if atomic {
+				for j := range errs {
+					errs[j] = ErrBatchAborted
+				}
+				errs[i] = err
+				return err
+			}
+
+			errs[i] = err
+			if err := tx.RollbackTo(savepoint).Error; err != nil {
+				return fmt.Errorf("error rolling back to savepoint: %w", err)
+			}
+		}
+
+		now := tx.NowFunc()
+		changes := make([]models.Event, 0, len(items))
+		for i, item := range items {
+			switch {
+			case errs[i] != nil:
+			case item.Op == models.BatchDelete:
+				changes = append(changes, models.RecordDeletedEvent(userID, item.Name, item.FolderID, now))
+			default:
+				changes = append(changes, models.RecordChangedEvent(item.Record))
+			}
+		}
+
+		return db.logChanges(tx, changes...)
+	})
+	if err != nil && !(atomic && failed) {
+		return nil, fmt.Errorf("error applying batch: %w", err)
+	}
+
+	return errs, nil
+}
+
+func applyBatchItem(tx *gorm.DB, item BatchItem, userID uint64) error {
+	if item.IdempotencyKey != "" {
+		applied := models.AppliedOperation{Key: item.IdempotencyKey, UserID: userID}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&applied)
+		if err := result.Error; err != nil {
+			return fmt.Errorf("error saving idempotency key: %w", err)
+		}
+		if result.RowsAffected == 0 {
+			return ErrAlreadyApplied
+		}
+	}
+
+	switch item.Op {
+	case models.BatchUpsert:
+		if err := checkFolderOwner(tx, item.Record.FolderID, userID); err != nil {
+			return err
+		}
+
+		existing := models.DataRecord{}
+		result := tx.
+			Where("user_id = ? AND folder_id = ? AND name = ?", userID, item.Record.FolderID, item.Record.Name).
+			Limit(1).
+			Find(&existing)
+		if err := result.Error; err != nil {
+			return fmt.Errorf("error looking up record: %w", err)
+		}
+		if result.RowsAffected != 0 {
+			item.Record.ID = existing.ID
+			item.Record.UploadedAt = tx.NowFunc()
+		}
+		if item.IfChecksum != "" {
+			if result.RowsAffected == 0 {
+				return ErrRecordChanged
+			}
+			// the conditional update locks the row, a concurrent writer then sees the new checksum
+			swapped := tx.Model(&models.DataRecord{}).
+				Where("id = ? AND checksum = ?", existing.ID, item.IfChecksum).
+				Update("checksum", item.Record.Checksum)
+			if err := swapped.Error; err != nil {
+				return fmt.Errorf("error checking record checksum: %w", err)
+			}
+			if swapped.RowsAffected == 0 {
+				return ErrRecordChanged
+			}
+		}
+
+		if err := tx.Save(item.Record).Error; err != nil {
+			return folderError(err)
+		}
+	case models.BatchDelete:
+		result := tx.
+			Where("user_id = ? AND folder_id = ? AND name = ?", userID, item.FolderID, item.Name).
+			Delete(&models.DataRecord{})
+		if err := result.Error; err != nil {
+			return fmt.Errorf("error deleting data: %w", err)
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+	default:
+		return fmt.Errorf("unknown batch operation: %s", item.Op)
+	}
+
+	return nil
+}
+
+// PurgeAppliedOperations forgets idempotency keys of operations applied before the time.
+func (db *DBStore) PurgeAppliedOperations(before time.Time) (int64, error) {
+	result := db.conn.Where("created_at < ?", before.UTC()).Delete(&models.AppliedOperation{})
+	if err := result.Error; err != nil {
+		return 0, fmt.Errorf("error purging applied operations: %w", err)
+	}
+
+	return result.RowsAffected, nil
+}
+
 func (db *DBStore) Ping() error {
 	sqlDB, err := db.conn.DB()
 	if err != nil {
@@ -0,0 +1,884 @@
+// Package storetest is the conformance suite which every store.Store implementation has to pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/audit"
+	"github.com/rawen554/goph-keeper/internal/events"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+)
+
+// eventTimeout limits waiting for events of stores which poll their revision log.
+const eventTimeout = 5 * time.Second
+
+// Factory creates an empty store publishing events to publisher. The store is closed by the suite.
+type Factory func(t *testing.T, publisher events.Publisher) store.Store
+
+// Run checks the behaviour the app relies on against stores made by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Store)
+	}{
+		{"Health", testHealth},
+		{"Users", testUsers},
+		{"PasswordReset", testPasswordReset},
+		{"Records", testRecords},
+		{"RecordsPaging", testRecordsPaging},
+		{"Search", testSearch},
+		{"Folders", testFolders},
+		{"Trash", testTrash},
+		{"Batch", testBatch},
+		{"DataKeys", testDataKeys},
+		{"Reseal", testReseal},
+		{"RecordStats", testRecordStats},
+		{"Audit", testAudit},
+		{"AuditPurge", testAuditPurge},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := factory(t, nil)
+			defer s.Close()
+			tt.fn(t, s)
+		})
+	}
+
+	t.Run("Events", func(t *testing.T) {
+		broker := events.NewBroker()
+		s := factory(t, broker)
+		defer s.Close()
+		testEvents(t, s, broker)
+	})
+}
+
+func newUser(t *testing.T, s store.Store, login string) uint64 {
+	t.Helper()
+
+	u := &models.User{Login: login, Password: "hash"}
+	if _, err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser(%s): %v", login, err)
+	}
+	if u.ID == 0 {
+		t.Fatalf("CreateUser(%s) did not set the id", login)
+	}
+
+	return u.ID
+}
+
+func newRecord(name string, folderID uint64, meta models.Metadata) *models.DataRecord {
+	return &models.DataRecord{
+		Type:     models.TEXT,
+		Name:     name,
+		Data:     "data of " + name,
+		Checksum: "sum",
+		Metadata: meta,
+		FolderID: folderID,
+	}
+}
+
+func putRecord(t *testing.T, s store.Store, userID uint64, r *models.DataRecord) *models.DataRecord {
+	t.Helper()
+
+	r.UserID = userID
+	if err := s.PutDataRecord(r, userID); err != nil {
+		t.Fatalf("PutDataRecord(%s): %v", r.Name, err)
+	}
+	if r.ID == 0 {
+		t.Fatalf("PutDataRecord(%s) did not set the id", r.Name)
+	}
+
+	return r
+}
+
+func newFolder(t *testing.T, s store.Store, userID uint64, name string, parentID uint64) uint64 {
+	t.Helper()
+
+	f := &models.Folder{Name: name, ParentID: parentID, UserID: userID}
+	if err := s.CreateFolder(f); err != nil {
+		t.Fatalf("CreateFolder(%s): %v", name, err)
+	}
+
+	return f.ID
+}
+
+func names(records []models.DataRecord) []string {
+	res := make([]string, len(records))
+	for i, r := range records {
+		res[i] = r.Name
+	}
+
+	return res
+}
+
+func expectNames(t *testing.T, what string, records []models.DataRecord, want ...string) {
+	t.Helper()
+
+	got := names(records)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+func expectErr(t *testing.T, what string, err error, target error) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Errorf("%s: got error %v, want %v", what, err, target)
+	}
+}
+
+func uptr(v uint64) *uint64 {
+	return &v
+}
+
+func testHealth(t *testing.T, s store.Store) {
+	if err := s.Ping(); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+	if err := s.CheckMigrations(); err != nil {
+		t.Errorf("CheckMigrations of a new store: %v", err)
+	}
+}
+
+func testUsers(t *testing.T, s store.Store) {
+	id := newUser(t, s, "alice")
+
+	_, err := s.CreateUser(&models.User{Login: "alice", Password: "other"})
+	expectErr(t, "duplicate login", err, store.ErrDuplicateLogin)
+
+	u, err := s.GetUser(&models.User{Login: "alice"})
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if u.ID != id || u.Password != "hash" {
+		t.Errorf("GetUser: got %+v", u)
+	}
+
+	_, err = s.GetUser(&models.User{Login: "bob"})
+	expectErr(t, "unknown login", err, store.ErrLoginNotFound)
+}
+
+func testPasswordReset(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	bob := newUser(t, s, "bob")
+	now := time.Now()
+
+	users, err := s.GetUsers(0, 10)
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != 2 || users[0].ID != alice || users[1].ID != bob {
+		t.Fatalf("GetUsers: got %+v", users)
+	}
+	if users, err := s.GetUsers(alice, 10); err != nil || len(users) != 1 || users[0].ID != bob {
+		t.Errorf("GetUsers after alice: got %+v, %v", users, err)
+	}
+
+	expectErr(t, "reset without a code", s.ResetPassword("alice", "", now, "new"), store.ErrResetCodeInvalid)
+	expectErr(t, "code of an unknown user", s.SetPasswordReset(bob+1, "code", now), store.ErrLoginNotFound)
+
+	if err := s.SetPasswordReset(alice, "code", now.Add(time.Hour)); err != nil {
+		t.Fatalf("SetPasswordReset: %v", err)
+	}
+	if err := s.SetPasswordReset(bob, "expired", now.Add(-time.Second)); err != nil {
+		t.Fatalf("SetPasswordReset: %v", err)
+	}
+
+	expectErr(t, "code of another login", s.ResetPassword("bob", "code", now, "new"), store.ErrResetCodeInvalid)
+	expectErr(t, "expired code", s.ResetPassword("bob", "expired", now, "new"), store.ErrResetCodeInvalid)
+	expectErr(t, "wrong code", s.ResetPassword("alice", "other", now, "new"), store.ErrResetCodeInvalid)
+	if err := s.ResetPassword("alice", "code", now, "new"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	expectErr(t, "used code", s.ResetPassword("alice", "code", now, "again"), store.ErrResetCodeInvalid)
+
+	u, err := s.GetUser(&models.User{Login: "alice"})
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if u.Password != "new" || u.ResetCodeHash != "" || u.ResetExpiresAt != nil {
+		t.Errorf("GetUser after reset: got %+v", u)
+	}
+	if u, err := s.GetUser(&models.User{Login: "bob"}); err != nil || u.Password != "hash" {
+		t.Errorf("GetUser of bob: got %+v, %v", u, err)
+	}
+}
+
+func testRecords(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	bob := newUser(t, s, "bob")
+	work := newFolder(t, s, alice, "work", models.RootFolderID)
+
+	first := putRecord(t, s, alice, newRecord("mail", models.RootFolderID, models.Metadata{models.MetaTags: "home, private"}))
+	putRecord(t, s, alice, newRecord("mail", work, models.Metadata{models.MetaTags: "work"}))
+	putRecord(t, s, alice, &models.DataRecord{Type: models.PASS, Name: "bank", Data: "me:secret", FolderID: work})
+	putRecord(t, s, bob, newRecord("mail", models.RootFolderID, nil))
+
+	err := s.PutDataRecord(&models.DataRecord{Type: models.TEXT, Name: "lost", UserID: alice, FolderID: work + 100}, alice)
+	expectErr(t, "unknown folder", err, store.ErrFolderNotFound)
+	err = s.PutDataRecord(&models.DataRecord{Type: models.TEXT, Name: "mail", UserID: alice, FolderID: work}, alice)
+	expectErr(t, "duplicate name", err, store.ErrDuplicateName)
+
+	r, err := s.GetUserRecord("mail", nil, alice)
+	if err != nil {
+		t.Fatalf("GetUserRecord: %v", err)
+	}
+	if r.ID != first.ID || r.Data != first.Data || r.Metadata[models.MetaTags] != "home, private" {
+		t.Errorf("GetUserRecord: the root folder record is expected first, got %+v", r)
+	}
+	if r, err = s.GetUserRecord("mail", uptr(work), alice); err != nil || r.FolderID != work {
+		t.Errorf("GetUserRecord in folder: got %+v, %v", r, err)
+	}
+	_, err = s.GetUserRecord("bank", uptr(models.RootFolderID), alice)
+	expectErr(t, "record of other folder", err, gorm.ErrRecordNotFound)
+	_, err = s.GetUserRecord("bank", nil, bob)
+	expectErr(t, "record of other user", err, gorm.ErrRecordNotFound)
+
+	since := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	updated := newRecord("mail", models.RootFolderID, models.Metadata{models.MetaURL: "https://mail.example.com"})
+	updated.ID = first.ID
+	updated.Data = "new data"
+	putRecord(t, s, alice, updated)
+	if r, err = s.GetUserRecord("mail", uptr(models.RootFolderID), alice); err != nil || r.Data != "new data" {
+		t.Errorf("GetUserRecord after update: got %+v, %v", r, err)
+	}
+
+	list := func(q models.RecordsQuery) []models.DataRecord {
+		t.Helper()
+		page, err := s.GetUserRecords(alice, q)
+		if err != nil {
+			t.Fatalf("GetUserRecords(%+v): %v", q, err)
+		}
+		return page.Records
+	}
+	expectNames(t, "all", list(models.RecordsQuery{}), "bank", "mail", "mail")
+	expectNames(t, "by type", list(models.RecordsQuery{Type: models.PASS}), "bank")
+	expectNames(t, "by folder", list(models.RecordsQuery{FolderID: uptr(work)}), "bank", "mail")
+	expectNames(t, "by prefix", list(models.RecordsQuery{NamePrefix: "ma"}), "mail", "mail")
+	expectNames(t, "by tag", list(models.RecordsQuery{Tag: "work"}), "mail")
+	expectNames(t, "by missing tag", list(models.RecordsQuery{Tag: "priv"}))
+	expectNames(t, "updated since", list(models.RecordsQuery{UpdatedSince: since}), "mail")
+}
+
+func testRecordsPaging(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	for _, name := range []string{"c", "a", "e", "b", "d"} {
+		putRecord(t, s, alice, newRecord(name, models.RootFolderID, nil))
+		// updated_at has to differ for the order by it to be defined by the insert order
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	cases := []struct {
+		sort models.RecordsSort
+		want string
+	}{
+		{models.SortByName, "[a b c d e]"},
+		{models.SortByNameDesc, "[e d c b a]"},
+		{models.SortByUpdatedAt, "[c a e b d]"},
+		{models.SortByUpdatedAtDesc, "[d b e a c]"},
+	}
+	for _, c := range cases {
+		var (
+			got    []string
+			cursor string
+		)
+		for i := 0; i < 5; i++ {
+			page, err := s.GetUserRecords(alice, models.RecordsQuery{Sort: c.sort, Limit: 2, Cursor: cursor})
+			if err != nil {
+				t.Fatalf("GetUserRecords(%s): %v", c.sort, err)
+			}
+			got = append(got, names(page.Records)...)
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+		if fmt.Sprint(got) != c.want {
+			t.Errorf("paging by %s: got %v, want %s", c.sort, got, c.want)
+		}
+	}
+
+	_, err := s.GetUserRecords(alice, models.RecordsQuery{Cursor: "%%%"})
+	expectErr(t, "bad cursor", err, models.ErrBadCursor)
+}
+
+func testSearch(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	putRecord(t, s, alice, newRecord("GitHub", models.RootFolderID, nil))
+	putRecord(t, s, alice, newRecord("mail", models.RootFolderID, models.Metadata{models.MetaURL: "https://github.com/login"}))
+	putRecord(t, s, alice, newRecord("bank", models.RootFolderID, models.Metadata{models.MetaTags: "money"}))
+
+	found, err := s.SearchUserRecords(alice, "github", 10)
+	if err != nil {
+		t.Fatalf("SearchUserRecords: %v", err)
+	}
+	if len(found) != 2 {
+		t.Errorf("search by name and url: got %v", names(found))
+	}
+	for _, r := range found {
+		if r.Data != "" {
+			t.Errorf("search results must not carry data, got %q in %s", r.Data, r.Name)
+		}
+	}
+
+	found, err = s.SearchUserRecords(alice, "MONEY", 10)
+	if err != nil {
+		t.Fatalf("SearchUserRecords: %v", err)
+	}
+	expectNames(t, "search by tag", found, "bank")
+}
+
+func testFolders(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	bob := newUser(t, s, "bob")
+	work := newFolder(t, s, alice, "work", models.RootFolderID)
+	aws := newFolder(t, s, alice, "aws", work)
+	newFolder(t, s, bob, "work", models.RootFolderID)
+
+	err := s.CreateFolder(&models.Folder{Name: "work", UserID: alice})
+	expectErr(t, "duplicate folder", err, store.ErrDuplicateName)
+	err = s.CreateFolder(&models.Folder{Name: "x", ParentID: aws + 100, UserID: alice})
+	expectErr(t, "unknown parent", err, store.ErrFolderNotFound)
+	err = s.UpdateFolder(&models.Folder{ID: work, Name: "work", ParentID: aws, UserID: alice})
+	expectErr(t, "folder cycle", err, store.ErrFolderCycle)
+
+	folders, err := s.GetUserFolders(alice)
+	if err != nil {
+		t.Fatalf("GetUserFolders: %v", err)
+	}
+	if len(folders) != 2 || folders[1].Path != "work/aws" {
+		t.Errorf("GetUserFolders: got %+v", folders)
+	}
+
+	root := putRecord(t, s, alice, newRecord("root", models.RootFolderID, nil))
+	putRecord(t, s, alice, newRecord("key", aws, nil))
+	if err := s.MoveRecords([]uint64{root.ID}, work, alice); err != nil {
+		t.Fatalf("MoveRecords: %v", err)
+	}
+	if err := s.MoveRecords([]uint64{root.ID, root.ID}, work, alice); err != nil {
+		t.Errorf("MoveRecords with a repeated id: %v", err)
+	}
+	err = s.MoveRecords([]uint64{root.ID + 100}, work, alice)
+	expectErr(t, "move unknown record", err, gorm.ErrRecordNotFound)
+
+	if err := s.DeleteFolder(aws, alice); err != nil {
+		t.Fatalf("DeleteFolder: %v", err)
+	}
+	page, err := s.GetUserRecords(alice, models.RecordsQuery{FolderID: uptr(work)})
+	if err != nil {
+		t.Fatalf("GetUserRecords: %v", err)
+	}
+	expectNames(t, "records of the deleted folder are moved up", page.Records, "key", "root")
+
+	err = s.DeleteFolder(aws, alice)
+	expectErr(t, "delete deleted folder", err, store.ErrFolderNotFound)
+
+	if err := s.UpdateFolder(&models.Folder{ID: work, Name: "job", UserID: alice}); err != nil {
+		t.Fatalf("UpdateFolder: %v", err)
+	}
+	if folders, _ = s.GetUserFolders(alice); len(folders) != 1 || folders[0].Path != "job" {
+		t.Errorf("GetUserFolders after rename: got %+v", folders)
+	}
+}
+
+func testTrash(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	work := newFolder(t, s, alice, "work", models.RootFolderID)
+	putRecord(t, s, alice, newRecord("note", work, nil))
+
+	if err := s.DeleteUserRecord("note", uptr(work), alice); err != nil {
+		t.Fatalf("DeleteUserRecord: %v", err)
+	}
+	_, err := s.GetUserRecord("note", nil, alice)
+	expectErr(t, "deleted record", err, gorm.ErrRecordNotFound)
+	err = s.DeleteUserRecord("note", nil, alice)
+	expectErr(t, "delete twice", err, gorm.ErrRecordNotFound)
+
+	// the name is free again while the record is in the trash
+	putRecord(t, s, alice, newRecord("note", work, nil))
+	_, err = s.RestoreUserRecord("note", nil, alice)
+	expectErr(t, "restore over alive record", err, store.ErrDuplicateName)
+	if err := s.DeleteUserRecord("note", nil, alice); err != nil {
+		t.Fatalf("DeleteUserRecord: %v", err)
+	}
+
+	trash, err := s.GetDeletedRecords(alice)
+	if err != nil {
+		t.Fatalf("GetDeletedRecords: %v", err)
+	}
+	if len(trash) != 2 || !trash[0].DeletedAt.Time.After(trash[1].DeletedAt.Time) {
+		t.Errorf("GetDeletedRecords: the latest deleted record is expected first, got %+v", trash)
+	}
+
+	if err := s.DeleteFolder(work, alice); err != nil {
+		t.Fatalf("DeleteFolder: %v", err)
+	}
+	r, err := s.RestoreUserRecord("note", nil, alice)
+	if err != nil {
+		t.Fatalf("RestoreUserRecord: %v", err)
+	}
+	if r.ID != trash[0].ID || r.FolderID != models.RootFolderID {
+		t.Errorf("RestoreUserRecord: the latest record is expected in the root folder, got %+v", r)
+	}
+
+	purged, err := s.PurgeExpiredRecords(time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Errorf("PurgeExpiredRecords of fresh trash: got %d, %v", purged, err)
+	}
+	purged, err = s.PurgeDeletedRecords(alice)
+	if err != nil || purged != 1 {
+		t.Errorf("PurgeDeletedRecords: got %d, %v", purged, err)
+	}
+	if trash, _ = s.GetDeletedRecords(alice); len(trash) != 0 {
+		t.Errorf("trash is not empty after purge: %+v", trash)
+	}
+}
+
+func testBatch(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	putRecord(t, s, alice, newRecord("old", models.RootFolderID, nil))
+
+	upsert := func(name string, folderID uint64, key string) store.BatchItem {
+		r := newRecord(name, folderID, nil)
+		r.UserID = alice
+		return store.BatchItem{Op: models.BatchUpsert, Record: r, IdempotencyKey: key}
+	}
+
+	errs, err := s.ApplyBatch([]store.BatchItem{
+		upsert("a", models.RootFolderID, ""),
+		upsert("b", 100, ""),
+	}, alice, true)
+	if err != nil {
+		t.Fatalf("ApplyBatch atomic: %v", err)
+	}
+	expectErr(t, "aborted item", errs[0], store.ErrBatchAborted)
+	expectErr(t, "failed item", errs[1], store.ErrFolderNotFound)
+	if _, err := s.GetUserRecord("a", nil, alice); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("atomic batch is not rolled back: %v", err)
+	}
+
+	errs, err = s.ApplyBatch([]store.BatchItem{
+		upsert("a", models.RootFolderID, "key-a"),
+		upsert("b", 100, ""),
+		upsert("old", models.RootFolderID, ""),
+		{Op: models.BatchDelete, Name: "missing"},
+	}, alice, false)
+	if err != nil {
+		t.Fatalf("ApplyBatch best effort: %v", err)
+	}
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("best effort batch: got errors %v", errs)
+	}
+	expectErr(t, "failed item", errs[1], store.ErrFolderNotFound)
+	expectErr(t, "missing record", errs[3], gorm.ErrRecordNotFound)
+
+	page, err := s.GetUserRecords(alice, models.RecordsQuery{})
+	if err != nil {
+		t.Fatalf("GetUserRecords: %v", err)
+	}
+	expectNames(t, "best effort batch applies the rest, upsert replaces by name", page.Records, "a", "old")
+
+	errs, err = s.ApplyBatch([]store.BatchItem{
+		upsert("a", models.RootFolderID, "key-a"),
+		{Op: models.BatchDelete, Name: "old", IdempotencyKey: "key-old"},
+	}, alice, false)
+	if err != nil {
+		t.Fatalf("ApplyBatch replay: %v", err)
+	}
+	expectErr(t, "replayed item", errs[0], store.ErrAlreadyApplied)
+	if errs[1] != nil {
+		t.Errorf("delete: %v", errs[1])
+	}
+	if _, err := s.GetUserRecord("old", nil, alice); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("batch delete: %v", err)
+	}
+
+	if _, err := s.PurgeAppliedOperations(time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("PurgeAppliedOperations: %v", err)
+	}
+	errs, err = s.ApplyBatch([]store.BatchItem{upsert("a", models.RootFolderID, "key-a")}, alice, false)
+	if err != nil || errs[0] != nil {
+		t.Errorf("purged key is not forgotten: %v, %v", errs, err)
+	}
+
+	read, err := s.GetUserRecord("a", nil, alice)
+	if err != nil {
+		t.Fatalf("GetUserRecord: %v", err)
+	}
+	swap := func(name string, checksum string) error {
+		item := upsert(name, models.RootFolderID, "")
+		item.Record.Checksum = "next " + checksum
+		item.IfChecksum = checksum
+		errs, err := s.ApplyBatch([]store.BatchItem{item}, alice, true)
+		if err != nil {
+			t.Fatalf("ApplyBatch: %v", err)
+		}
+		return errs[0]
+	}
+	if err := swap("a", read.Checksum); err != nil {
+		t.Errorf("upsert with the read checksum: %v", err)
+	}
+	expectErr(t, "upsert with a stale checksum", swap("a", read.Checksum), store.ErrRecordChanged)
+	expectErr(t, "upsert of a missing record with a checksum", swap("missing", read.Checksum), store.ErrRecordChanged)
+}
+
+func testDataKeys(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	bob := newUser(t, s, "bob")
+
+	_, err := s.GetDataKey(alice)
+	expectErr(t, "missing key", err, gorm.ErrRecordNotFound)
+
+	for _, k := range []models.DataKey{
+		{UserID: alice, Wrapped: []byte("alice v1"), MasterVersion: 1},
+		{UserID: bob, Wrapped: []byte("bob v2"), MasterVersion: 2},
+	} {
+		k := k
+		if err := s.CreateDataKey(&k); err != nil {
+			t.Fatalf("CreateDataKey: %v", err)
+		}
+	}
+	err = s.CreateDataKey(&models.DataKey{UserID: alice, Wrapped: []byte("other"), MasterVersion: 2})
+	expectErr(t, "second key", err, store.ErrDataKeyExists)
+
+	key, err := s.GetDataKey(alice)
+	if err != nil {
+		t.Fatalf("GetDataKey: %v", err)
+	}
+	if string(key.Wrapped) != "alice v1" || key.MasterVersion != 1 {
+		t.Errorf("GetDataKey: got %+v", key)
+	}
+
+	stale, err := s.GetStaleDataKeys(2, 10)
+	if err != nil {
+		t.Fatalf("GetStaleDataKeys: %v", err)
+	}
+	if len(stale) != 1 || stale[0].UserID != alice {
+		t.Fatalf("GetStaleDataKeys: got %+v", stale)
+	}
+
+	// a key rewrapped concurrently is not overwritten
+	if err := s.RewrapDataKey(&models.DataKey{UserID: alice, Wrapped: []byte("alice v3"), MasterVersion: 3}, 2); err != nil {
+		t.Fatalf("RewrapDataKey: %v", err)
+	}
+	if err := s.RewrapDataKey(&models.DataKey{UserID: alice, Wrapped: []byte("alice v2"), MasterVersion: 2}, 1); err != nil {
+		t.Fatalf("RewrapDataKey: %v", err)
+	}
+	if key, err = s.GetDataKey(alice); err != nil || string(key.Wrapped) != "alice v2" || key.MasterVersion != 2 {
+		t.Errorf("GetDataKey after rewrap: got %+v, %v", key, err)
+	}
+	if stale, _ = s.GetStaleDataKeys(2, 10); len(stale) != 0 {
+		t.Errorf("GetStaleDataKeys after rewrap: got %+v", stale)
+	}
+}
+
+func testReseal(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	bob := newUser(t, s, "bob")
+	a := putRecord(t, s, alice, newRecord("a", models.RootFolderID, nil))
+	putRecord(t, s, bob, newRecord("b", models.RootFolderID, nil))
+	putRecord(t, s, alice, newRecord("trashed", models.RootFolderID, nil))
+	if err := s.DeleteUserRecord("trashed", nil, alice); err != nil {
+		t.Fatalf("DeleteUserRecord: %v", err)
+	}
+
+	// the prefix matches neither plain nor sealed data, every record is found
+	unsealed, err := s.GetUnsealedRecords("x:", 0, 10)
+	if err != nil {
+		t.Fatalf("GetUnsealedRecords: %v", err)
+	}
+	expectNames(t, "GetUnsealedRecords", unsealed, "a", "b", "trashed")
+	page, err := s.GetUnsealedRecords("x:", a.ID, 1)
+	if err != nil {
+		t.Fatalf("GetUnsealedRecords after a: %v", err)
+	}
+	expectNames(t, "GetUnsealedRecords after a", page, "b")
+
+	for _, r := range unsealed {
+		oldData := r.Data
+		if r.Name == "b" {
+			// b is changed since it was read, the reseal is skipped
+			oldData = "changed"
+		}
+		r.Data, r.Checksum = "x:"+r.Name, "resealed"
+		if err := s.ResealRecord(&r, oldData); err != nil {
+			t.Fatalf("ResealRecord(%s): %v", r.Name, err)
+		}
+	}
+
+	unsealed, err = s.GetUnsealedRecords("x:", 0, 10)
+	if err != nil {
+		t.Fatalf("GetUnsealedRecords after reseal: %v", err)
+	}
+	expectNames(t, "GetUnsealedRecords after reseal", unsealed, "b")
+}
+
+func testRecordStats(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	bob := newUser(t, s, "bob")
+
+	blob := newRecord("blob", models.RootFolderID, nil)
+	blob.Type = models.BIN
+	blob.Data = "данные"
+	putRecord(t, s, alice, blob)
+	putRecord(t, s, alice, newRecord("note", models.RootFolderID, nil))
+	putRecord(t, s, bob, newRecord("note", models.RootFolderID, nil))
+	putRecord(t, s, bob, newRecord("old", models.RootFolderID, nil))
+	if err := s.DeleteUserRecord("old", nil, bob); err != nil {
+		t.Fatalf("DeleteUserRecord: %v", err)
+	}
+
+	stats, err := s.RecordStats()
+	if err != nil {
+		t.Fatalf("RecordStats: %v", err)
+	}
+	got := make(map[models.DataType]models.RecordStats)
+	for _, st := range stats {
+		got[st.Type] = st
+	}
+
+	if len(got) != 2 || got[models.TEXT].Count != 2 || got[models.BIN].Count != 1 {
+		t.Errorf("RecordStats: records in the trash are not expected, got %+v", stats)
+	}
+	// stores may keep data encrypted, which is only larger
+	if got[models.BIN].Bytes < int64(len(blob.Data)) {
+		t.Errorf("RecordStats: %d bytes of %s are expected at least, got %d", len(blob.Data), blob.Name, got[models.BIN].Bytes)
+	}
+}
+
+// auditKey hashes audit events of the suite.
+var auditKey = []byte("storetest audit key")
+
+func testAudit(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	bob := newUser(t, s, "bob")
+
+	for _, e := range []models.AuditEvent{
+		{UserID: alice, Action: models.AuditLogin, Target: "login", IP: "10.0.0.1", UserAgent: "gclient"},
+		{UserID: bob, Action: models.AuditLoginFailed, Target: "login"},
+		{UserID: alice, Action: models.AuditRecordRead, Target: "note", RecordID: 1},
+		{UserID: bob, Action: models.AuditLogin, Target: "login"},
+		{UserID: alice, Action: models.AuditRecordWrite, Target: "note", RecordID: 1},
+		{UserID: alice, Action: models.AuditRecordRead, Target: "card", RecordID: 2},
+	} {
+		e := e
+		if err := s.AppendAuditEvent(&e, auditKey); err != nil {
+			t.Fatalf("AppendAuditEvent: %v", err)
+		}
+	}
+
+	page, err := s.GetAuditEvents(alice, models.AuditQuery{})
+	if err != nil {
+		t.Fatalf("GetAuditEvents: %v", err)
+	}
+	if len(page.Events) != 4 || page.NextBefore != 0 {
+		t.Fatalf("GetAuditEvents: 4 events of alice are expected, got %+v", page)
+	}
+	for i, e := range page.Events {
+		if want := uint64(len(page.Events) - i); e.Seq != want {
+			t.Errorf("GetAuditEvents: event %d is expected at %d, got %d", want, i, e.Seq)
+		}
+		if e.Hash != e.ComputeHash(auditKey) {
+			t.Errorf("GetAuditEvents: event %d does not hash the same after it is read back", e.Seq)
+		}
+		if i+1 < len(page.Events) && e.PrevHash != page.Events[i+1].Hash {
+			t.Errorf("GetAuditEvents: event %d is not chained to the previous one", e.Seq)
+		}
+	}
+	if first := page.Events[len(page.Events)-1]; first.PrevHash != "" || first.IP != "10.0.0.1" || first.UserAgent != "gclient" {
+		t.Errorf("GetAuditEvents: first event got %+v", first)
+	}
+
+	page, err = s.GetAuditEvents(alice, models.AuditQuery{Actions: []models.AuditAction{models.AuditRecordRead}, Limit: 1})
+	if err != nil {
+		t.Fatalf("GetAuditEvents by action: %v", err)
+	}
+	if len(page.Events) != 1 || page.Events[0].Target != "card" || page.NextBefore != page.Events[0].Seq {
+		t.Fatalf("GetAuditEvents by action: first page got %+v", page)
+	}
+	page, err = s.GetAuditEvents(alice, models.AuditQuery{
+		Actions: []models.AuditAction{models.AuditRecordRead},
+		Before:  page.NextBefore,
+		Limit:   1,
+	})
+	if err != nil || len(page.Events) != 1 || page.Events[0].Target != "note" || page.NextBefore != 0 {
+		t.Errorf("GetAuditEvents by action: last page got %+v, %v", page, err)
+	}
+
+	page, err = s.GetAuditEvents(alice, models.AuditQuery{Target: "note"})
+	if err != nil || len(page.Events) != 2 {
+		t.Errorf("GetAuditEvents by target: got %+v, %v", page, err)
+	}
+	page, err = s.GetAuditEvents(alice, models.AuditQuery{Since: time.Now().Add(time.Hour)})
+	if err != nil || len(page.Events) != 0 {
+		t.Errorf("GetAuditEvents since the future: got %+v, %v", page, err)
+	}
+	page, err = s.GetAuditEvents(alice, models.AuditQuery{Until: time.Now().Add(-time.Hour)})
+	if err != nil || len(page.Events) != 0 {
+		t.Errorf("GetAuditEvents until the past: got %+v, %v", page, err)
+	}
+
+	scanned, err := s.ScanAuditLog(0, 0, 10)
+	if err != nil {
+		t.Fatalf("ScanAuditLog: %v", err)
+	}
+	if len(scanned) != 6 || scanned[0].UserID != alice || scanned[3].Seq != 4 || scanned[4].UserID != bob {
+		t.Errorf("ScanAuditLog: every event ordered by user is expected, got %+v", scanned)
+	}
+	if scanned, err = s.ScanAuditLog(alice, 3, 10); err != nil || len(scanned) != 3 || scanned[0].Seq != 4 {
+		t.Errorf("ScanAuditLog after event 3 of alice: got %+v, %v", scanned, err)
+	}
+
+	report, err := audit.Verify(s, auditKey)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(report.Chains) != 2 || len(report.Problems) != 0 {
+		t.Errorf("Verify: 2 intact chains are expected, got %+v", report)
+	}
+
+	report, err = audit.Verify(s, []byte("another key"))
+	if err != nil || len(report.Problems) != 6 {
+		t.Errorf("Verify with another key: every event is expected to fail, got %+v, %v", report, err)
+	}
+}
+
+func testAuditPurge(t *testing.T, s store.Store) {
+	alice := newUser(t, s, "alice")
+	bob := newUser(t, s, "bob")
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, e := range []models.AuditEvent{
+		{UserID: alice, Action: models.AuditLogin, CreatedAt: old},
+		{UserID: alice, Action: models.AuditRecordRead, CreatedAt: old},
+		{UserID: bob, Action: models.AuditLogin, CreatedAt: old},
+		{UserID: alice, Action: models.AuditRecordWrite, CreatedAt: old},
+		{UserID: alice, Action: models.AuditRecordRead},
+	} {
+		e := e
+		if err := s.AppendAuditEvent(&e, auditKey); err != nil {
+			t.Fatalf("AppendAuditEvent: %v", err)
+		}
+	}
+
+	purged, err := s.PurgeAuditLog(time.Now().Add(-time.Hour), auditKey)
+	if err != nil {
+		t.Fatalf("PurgeAuditLog: %v", err)
+	}
+	if purged != 3 {
+		t.Errorf("PurgeAuditLog: 3 old events of alice are expected to be purged, got %d", purged)
+	}
+
+	watermarks, err := s.GetAuditWatermarks()
+	if err != nil {
+		t.Fatalf("GetAuditWatermarks: %v", err)
+	}
+	if len(watermarks) != 1 || watermarks[0].UserID != alice || watermarks[0].Seq != 4 ||
+		watermarks[0].Hash != watermarks[0].ComputeHash(auditKey) {
+		t.Errorf("GetAuditWatermarks: a watermark of alice at event 4 is expected, got %+v", watermarks)
+	}
+
+	e := models.AuditEvent{UserID: bob, Action: models.AuditRecordRead}
+	if err := s.AppendAuditEvent(&e, auditKey); err != nil {
+		t.Fatalf("AppendAuditEvent after purge: %v", err)
+	}
+	if e.Seq != 2 {
+		t.Errorf("AppendAuditEvent after purge: the chain of bob is expected to go on, got seq %d", e.Seq)
+	}
+
+	// an old event after a new one, e.g. of a replica with a late clock, is kept with the new one
+	for _, e := range []models.AuditEvent{
+		{UserID: alice, Action: models.AuditLogin, CreatedAt: old},
+		{UserID: alice, Action: models.AuditRecordRead},
+	} {
+		e := e
+		if err := s.AppendAuditEvent(&e, auditKey); err != nil {
+			t.Fatalf("AppendAuditEvent: %v", err)
+		}
+	}
+	// the old event of bob is no longer the last one
+	if purged, err := s.PurgeAuditLog(time.Now().Add(-time.Hour), auditKey); err != nil || purged != 1 {
+		t.Errorf("PurgeAuditLog again: only the old event of bob is expected to be purged, got %d, %v", purged, err)
+	}
+
+	report, err := audit.Verify(s, auditKey)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(report.Problems) != 0 || len(report.Chains) != 2 || report.Chains[0].First != 4 || report.Chains[1].First != 2 {
+		t.Errorf("Verify after purge: intact chains from events 4 and 2 are expected, got %+v", report)
+	}
+}
+
+func testEvents(t *testing.T, s store.Store, broker *events.Broker) {
+	alice := newUser(t, s, "alice")
+	bob := newUser(t, s, "bob")
+
+	received, cancel := broker.Subscribe(alice)
+	defer cancel()
+
+	ctx, stop := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := s.ListenChanges(ctx); err != nil {
+			t.Errorf("ListenChanges: %v", err)
+		}
+	}()
+	defer func() {
+		stop()
+		wg.Wait()
+	}()
+	// a listener backfills from the moment it starts, give it the time to start
+	time.Sleep(100 * time.Millisecond)
+
+	// a changed record is published in its state at the time of publishing, so every change
+	// is awaited before the next one
+	expect := func(typ models.EventType, folderID uint64) *models.Event {
+		t.Helper()
+		select {
+		case e := <-received:
+			if e.Type != typ || e.UserID != alice {
+				t.Fatalf("got %s of user %d, want %s", e.Type, e.UserID, typ)
+			}
+			if typ != models.EventFoldersChanged && (e.Name != "note" || e.FolderID != folderID) {
+				t.Errorf("%s: got %s in %d, want note in %d", typ, e.Name, e.FolderID, folderID)
+			}
+			return &e
+		case <-time.After(eventTimeout):
+			t.Fatalf("%s is not received", typ)
+		}
+		return nil
+	}
+
+	work := newFolder(t, s, alice, "work", models.RootFolderID)
+	expect(models.EventFoldersChanged, work)
+
+	putRecord(t, s, bob, newRecord("note", models.RootFolderID, nil))
+	r := putRecord(t, s, alice, newRecord("note", models.RootFolderID, nil))
+	if e := expect(models.EventRecordChanged, models.RootFolderID); e.Record == nil || e.Record.ID != r.ID || e.Record.Data != r.Data {
+		t.Errorf("got record %+v, want %d with %q", e.Record, r.ID, r.Data)
+	}
+
+	if err := s.MoveRecords([]uint64{r.ID}, work, alice); err != nil {
+		t.Fatalf("MoveRecords: %v", err)
+	}
+	expect(models.EventRecordChanged, work)
+
+	if err := s.DeleteUserRecord("note", nil, alice); err != nil {
+		t.Fatalf("DeleteUserRecord: %v", err)
+	}
+	expect(models.EventRecordDeleted, work)
+}
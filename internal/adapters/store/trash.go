@@ -0,0 +1,112 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeleteUserRecord moves a record into the trash.
+func (db *DBStore) DeleteUserRecord(recordName string, folderID *uint64, userID uint64) error {
+	record, err := db.GetUserRecord(recordName, folderID, userID)
+	if err != nil {
+		return err
+	}
+
+	return db.conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(record).Error; err != nil {
+			return fmt.Errorf("error deleting record: %w", err)
+		}
+
+		return db.logChanges(tx, models.RecordDeletedEvent(userID, record.Name, record.FolderID, record.DeletedAt.Time))
+	})
+}
+
+func (db *DBStore) GetDeletedRecords(userID uint64) ([]models.DataRecord, error) {
+	records := make([]models.DataRecord, 0)
+	result := db.conn.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").
+		Find(&records)
+	if err := result.Error; err != nil {
+		return nil, fmt.Errorf("error getting deleted records: %w", err)
+	}
+
+	return records, nil
+}
+
+// RestoreUserRecord brings the most recently deleted record with the name back from the trash.
+func (db *DBStore) RestoreUserRecord(recordName string, folderID *uint64, userID uint64) (*models.DataRecord, error) {
+	record := models.DataRecord{}
+	err := db.conn.Transaction(func(tx *gorm.DB) error {
+		q := tx.Unscoped().Where("user_id = ? AND name = ? AND deleted_at IS NOT NULL", userID, recordName)
+		if folderID != nil {
+			q = q.Where("folder_id = ?", *folderID)
+		}
+		if err := q.Order("deleted_at DESC").First(&record).Error; err != nil {
+			return fmt.Errorf("error getting deleted record: %w", err)
+		}
+
+		// the folder could have been deleted while the record was in the trash
+		if err := checkFolderOwner(tx, record.FolderID, userID); err != nil {
+			if !errors.Is(err, ErrFolderNotFound) {
+				return err
+			}
+			record.FolderID = models.RootFolderID
+		}
+
+		record.DeletedAt = gorm.DeletedAt{}
+		record.UpdatedAt = tx.NowFunc()
+		result := tx.Unscoped().Model(&record).Select("deleted_at", "folder_id", "updated_at").Updates(&record)
+		if err := result.Error; err != nil {
+			return folderError(err)
+		}
+
+		return db.logChanges(tx, models.RecordChangedEvent(&record))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// PurgeDeletedRecords removes every record in the user's trash.
+func (db *DBStore) PurgeDeletedRecords(userID uint64) (int64, error) {
+	return db.purge(db.conn.Where("user_id = ?", userID))
+}
+
+// PurgeExpiredRecords removes records of all users which were deleted before the moment.
+func (db *DBStore) PurgeExpiredRecords(before time.Time) (int64, error) {
+	return db.purge(db.conn.Where("deleted_at < ?", before.UTC()))
+}
+
+func (db *DBStore) purge(scope *gorm.DB) (int64, error) {
+	records := make([]models.DataRecord, 0)
+	result := scope.Unscoped().Where("deleted_at IS NOT NULL").Clauses(clause.Returning{}).Delete(&records)
+	if err := result.Error; err != nil {
+		return 0, fmt.Errorf("error purging deleted records: %w", err)
+	}
+
+	removeRecordFiles(records)
+
+	return result.RowsAffected, nil
+}
+
+// removeRecordFiles removes files of purged records.
+func removeRecordFiles(records []models.DataRecord) {
+	for _, r := range records {
+		if r.FilePath == "" {
+			continue
+		}
+		if err := os.Remove(r.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("error removing purged record file %s: %v", r.FilePath, err)
+		}
+	}
+}
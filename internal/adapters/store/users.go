@@ -0,0 +1,53 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+func (db *DBStore) GetUsers(afterID uint64, limit int) ([]models.User, error) {
+	users := make([]models.User, 0, limit)
+	if err := db.conn.Where("id > ?", afterID).Order("id").Limit(limit).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("error getting users: %w", err)
+	}
+
+	return users, nil
+}
+
+func (db *DBStore) SetPasswordReset(userID uint64, codeHash string, expiresAt time.Time) error {
+	result := db.conn.Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"reset_code_hash":  codeHash,
+			"reset_expires_at": expiresAt.UTC(),
+		})
+	if err := result.Error; err != nil {
+		return fmt.Errorf("error saving password reset: %w", err)
+	}
+	if result.RowsAffected == 0 {
+		return ErrLoginNotFound
+	}
+
+	return nil
+}
+
+// ResetPassword checks and clears the code in one update, a code cannot be used twice.
+func (db *DBStore) ResetPassword(login string, codeHash string, now time.Time, passwordHash string) error {
+	result := db.conn.Model(&models.User{}).
+		Where("login = ? AND reset_code_hash = ? AND reset_expires_at > ?", login, codeHash, now.UTC()).
+		Updates(map[string]interface{}{
+			"password":         passwordHash,
+			"reset_code_hash":  nil,
+			"reset_expires_at": nil,
+		})
+	if err := result.Error; err != nil {
+		return fmt.Errorf("error resetting password: %w", err)
+	}
+	if result.RowsAffected == 0 {
+		return ErrResetCodeInvalid
+	}
+
+	return nil
+}
@@ -2,28 +2,47 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/rawen554/goph-keeper/internal/adapters/store"
 	"github.com/rawen554/goph-keeper/internal/config"
+	"github.com/rawen554/goph-keeper/internal/events"
+	"github.com/rawen554/goph-keeper/internal/metrics"
 	"github.com/rawen554/goph-keeper/internal/middleware/auth"
 	"github.com/rawen554/goph-keeper/internal/models"
+	"github.com/rawen554/goph-keeper/internal/otp"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
 	"gorm.io/gorm"
 )
 
 type App struct {
-	config *config.ServerConfig
-	store  store.Store
-	logger *zap.SugaredLogger
+	config      *config.ServerConfig
+	store       store.Store
+	broker      *events.Broker
+	ca          *CA
+	certs       *CertManager
+	auditKey    []byte
+	failed      map[uint64]*failedLogins
+	logger      *zap.SugaredLogger
+	streams     context.Context
+	stopStreams context.CancelFunc
+	failedMu    sync.Mutex
+	draining    atomic.Bool
 }
 
 const (
@@ -31,11 +50,39 @@ const (
 	maxCookieAge = 3600 * 24 * 30
 )
 
-func NewApp(config *config.ServerConfig, store store.Store, logger *zap.SugaredLogger) *App {
+var (
+	errEmptyName         = errors.New("record name is empty")
+	errNameWithSeparator = errors.New("name cannot contain " + models.PathSeparator)
+	errBadPassFormat     = errors.New("PASS data must match %LOGIN%:%PASSWORD%")
+	errWrongChecksum     = errors.New("wrong checksum from request, corrupted data")
+)
+
+// NewApp creates the app. Devices are enrolled with client certificates issued by ca, it is nil without mTLS.
+// certs serves the server certificate, it is nil without https. Both are checked by readiness probes.
+// Audit events are hashed with auditKey.
+func NewApp(
+	config *config.ServerConfig,
+	store store.Store,
+	broker *events.Broker,
+	ca *CA,
+	certs *CertManager,
+	auditKey []byte,
+	logger *zap.SugaredLogger,
+) *App {
+	// streams is canceled on shutdown, event streams never end by themselves
+	streams, stopStreams := context.WithCancel(context.Background())
+
 	return &App{
-		config: config,
-		store:  store,
-		logger: logger,
+		config:      config,
+		store:       store,
+		broker:      broker,
+		ca:          ca,
+		certs:       certs,
+		auditKey:    auditKey,
+		failed:      make(map[uint64]*failedLogins),
+		logger:      logger,
+		streams:     streams,
+		stopStreams: stopStreams,
 	}
 }
 
@@ -45,22 +92,38 @@ func (a *App) NewServer() (*http.Server, error) {
 		return nil, fmt.Errorf("error init router: %w", err)
 	}
 
-	return &http.Server{
+	srv := &http.Server{
 		Addr:    a.config.RunAddr,
 		Handler: r,
-	}, nil
+	}
+	// Shutdown waits for active requests, event streams would hold it until its timeout.
+	srv.RegisterOnShutdown(a.stopStreams)
+
+	return srv, nil
 }
 
 func (a *App) Login(c *gin.Context) {
 	req := c.Request
 	res := c.Writer
 
-	userCreds := models.User{}
+	userCreds := models.LoginRequest{}
 	if err := json.NewDecoder(req.Body).Decode(&userCreds); err != nil {
 		a.logger.Errorf("user credentials cannot be decoded: %v", err)
 		res.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if userCreds.Login == "" || userCreds.Password == "" {
+		a.logger.Errorf("login of %q without a login or password", userCreds.Login)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// With mTLS every token is bound to a device certificate, an unbound one would work without it.
+	if a.ca != nil && userCreds.CSR == "" {
+		a.logger.Errorf("login of %s without a device certificate request", userCreds.Login)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	userReq := models.User{
 		Login:    userCreds.Login,
@@ -70,7 +133,9 @@ func (a *App) Login(c *gin.Context) {
 	u, err := a.store.GetUser(&models.User{Login: userReq.Login})
 	if err != nil {
 		if errors.Is(err, store.ErrLoginNotFound) {
-			a.logger.Errorf("login not found: %v", err)
+			// there is no audit log of unknown users, anyone could fill it
+			a.logger.Warnf("failed login of unknown user %q from %s", userReq.Login, c.ClientIP())
+			metrics.AuthFailed(metrics.ReasonBadCredentials)
 			res.WriteHeader(http.StatusUnauthorized)
 			return
 		} else {
@@ -81,21 +146,42 @@ func (a *App) Login(c *gin.Context) {
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(userReq.Password)); err != nil {
+		metrics.AuthFailed(metrics.ReasonBadCredentials)
+		a.auditLoginFailed(c, u.ID)
+		if HasEmptyPassword(u) {
+			a.logger.Warnf("login of %s with an empty password, a reset code has to be issued", u.Login)
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
 		res.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 	userReq.ID = u.ID
 
-	jwt, err := auth.BuildJWTString(userReq.ID)
+	// Devices of servers without mTLS get plain tokens, the request is ignored.
+	var certPEM, fingerprint string
+	if a.ca != nil {
+		certPEM, fingerprint, err = a.ca.IssueDeviceCert(userCreds.CSR, userReq.ID)
+		if err != nil {
+			a.logger.Errorf("cannot issue device certificate: %v", err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	jwt, err := auth.BuildJWTString(userReq.ID, fingerprint)
 	if err != nil {
 		a.logger.Errorf("cannot build jwt string for authorized user: %v", err)
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	a.audit(c, models.AuditEvent{UserID: userReq.ID, Action: models.AuditLogin, Target: auditTargetLogin})
+
 	c.JSON(http.StatusOK, models.TokenResponse{
-		Token:     jwt,
-		ExpiresIn: maxCookieAge,
+		Token:       jwt,
+		ExpiresIn:   maxCookieAge,
+		Certificate: certPEM,
 	})
 }
 
@@ -103,12 +189,27 @@ func (a *App) Register(c *gin.Context) {
 	req := c.Request
 	res := c.Writer
 
-	userCreds := models.User{}
+	userCreds := models.LoginRequest{}
 	if err := json.NewDecoder(req.Body).Decode(&userCreds); err != nil {
 		a.logger.Errorf("body cannot be decoded: %v", err)
 		res.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if userCreds.Login == "" || userCreds.Password == "" {
+		a.logger.Errorf("registration of %q without a login or password", userCreds.Login)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// The device of a new account is enrolled like on login, the request is checked before
+	// the account is created.
+	if a.ca != nil {
+		if _, err := parseCSR(userCreds.CSR); err != nil {
+			a.logger.Errorf("registration of %s without a valid device certificate request: %v", userCreds.Login, err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 
 	userReq := models.User{
 		Login:    userCreds.Login,
@@ -135,13 +236,23 @@ func (a *App) Register(c *gin.Context) {
 		}
 	}
 
-	if err := os.MkdirAll(fmt.Sprintf("./userdata/%s-%d/", userReq.Login, userReq.ID), 0700); err != nil {
+	if err := os.MkdirAll(fmt.Sprintf("%s/%s-%d/", models.UserDataDir, userReq.Login, userReq.ID), 0700); err != nil {
 		a.logger.Errorf("cannot create user folder: %v", err)
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	jwt, err := auth.BuildJWTString(userReq.ID)
+	var certPEM, fingerprint string
+	if a.ca != nil {
+		certPEM, fingerprint, err = a.ca.IssueDeviceCert(userCreds.CSR, userReq.ID)
+		if err != nil {
+			a.logger.Errorf("cannot issue device certificate: %v", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	jwt, err := auth.BuildJWTString(userReq.ID, fingerprint)
 	if err != nil {
 		a.logger.Errorf("cannot build jwt string: %v", err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -149,8 +260,9 @@ func (a *App) Register(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusCreated, models.TokenResponse{
-		Token:     jwt,
-		ExpiresIn: maxCookieAge,
+		Token:       jwt,
+		ExpiresIn:   maxCookieAge,
+		Certificate: certPEM,
 	})
 }
 
@@ -170,43 +282,95 @@ func (a *App) PutDataRecord(c *gin.Context) {
 		return
 	}
 
-	parts := bytes.Split([]byte(record.Data), []byte(":"))
-	if len(parts) <= 1 {
+	data, err := newDataRecord(&record, userID)
+	if err != nil {
+		a.logger.Errorf("bad record %s: %v", record.Name, err)
 		res.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if record.Type == models.PASS || record.Type == models.TEXT {
+	if err := a.store.PutDataRecord(data, userID); err != nil {
+		if errors.Is(err, store.ErrFolderNotFound) {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, store.ErrDuplicateName) {
+			res.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		a.logger.Errorf("unhandled error: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditRecordWrite, Target: data.Name, RecordID: data.ID})
+
+	c.JSON(http.StatusCreated, data)
+}
+
+// newDataRecord validates a record received from a client and builds the model to store.
+func newDataRecord(record *models.DataRecordRequest, userID uint64) (*models.DataRecord, error) {
+	if record.Name == "" {
+		return nil, errEmptyName
+	}
+
+	if strings.Contains(record.Name, models.PathSeparator) {
+		return nil, errNameWithSeparator
+	}
+
+	if record.Type == models.PASS {
+		parts := bytes.Split([]byte(record.Data), []byte(":"))
+		if len(parts) <= 1 {
+			return nil, errBadPassFormat
+		}
+	}
+
+	if record.Type == models.OTP {
+		if _, err := otp.ParseURI(record.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if record.Type == models.SSHKEY {
+		key, err := models.ParseSSHKey(record.Data)
+		if err != nil {
+			return nil, err
+		}
+		if key.PublicKey != "" {
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey)); err != nil {
+				return nil, fmt.Errorf("error parsing ssh public key: %w", err)
+			}
+		}
+	}
+
+	if record.Type == models.PASS || record.Type == models.TEXT || record.Type == models.OTP {
 		checksum := fmt.Sprintf("%x", md5.Sum([]byte(record.Data)))
 
 		if record.Checksum != checksum {
-			a.logger.Errorf("wrong checksum from request, corrupted data")
-			res.WriteHeader(http.StatusBadRequest)
-			return
+			return nil, errWrongChecksum
 		}
 	}
 
 	data := &models.DataRecord{
-		Type:    record.Type,
-		Name:    record.Name,
-		Blocked: false,
+		Type:     record.Type,
+		Name:     record.Name,
+		Metadata: record.Metadata,
+		FolderID: record.FolderID,
+		Blocked:  false,
 	}
 
 	if record.ID != 0 {
 		data.ID = record.ID
 	}
 
+	// The store keeps the checksum of the sealed data instead, the checksum of the plaintext
+	// would let anyone with the database confirm a guess.
 	data.Checksum = fmt.Sprintf("%x", md5.Sum([]byte(record.Data)))
-	data.Data = string(record.Data)
+	data.Data = record.Data
 	data.UserID = userID
 
-	if err := a.store.PutDataRecord(data, userID); err != nil {
-		a.logger.Errorf("unhandled error: %v", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	c.JSON(http.StatusCreated, data)
+	return data, nil
 }
 
 func (a *App) GetDataRecords(c *gin.Context) {
@@ -217,10 +381,17 @@ func (a *App) GetDataRecords(c *gin.Context) {
 		return
 	}
 
-	records, err := a.store.GetUserRecords(userID)
+	query, err := parseRecordsQuery(c)
+	if err != nil {
+		a.logger.Errorf("bad records query: %v", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	page, err := a.store.GetUserRecords(userID, query)
 	if err != nil {
-		if errors.Is(err, models.ErrNoData) {
-			res.WriteHeader(http.StatusNoContent)
+		if errors.Is(err, models.ErrBadCursor) {
+			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
@@ -229,7 +400,53 @@ func (a *App) GetDataRecords(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, records)
+	a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditRecordsList, Target: auditTargetRecords})
+
+	if len(page.Records) == 0 && query.Cursor == "" {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// parseRecordsQuery reads limit, cursor, type, tag, name, updated_since and sort query parameters.
+func parseRecordsQuery(c *gin.Context) (models.RecordsQuery, error) {
+	query := models.RecordsQuery{
+		Type:       models.DataType(strings.ToUpper(c.Query("type"))),
+		Tag:        c.Query("tag"),
+		NamePrefix: c.Query("name"),
+		Cursor:     c.Query("cursor"),
+		Sort:       models.RecordsSort(c.DefaultQuery("sort", string(models.SortByName))),
+	}
+
+	folderID, err := parseFolderID(c)
+	if err != nil {
+		return query, err
+	}
+	query.FolderID = folderID
+
+	if !query.Sort.Valid() {
+		return query, fmt.Errorf("unknown sort order: %s", query.Sort)
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > models.MaxRecordsLimit {
+			return query, fmt.Errorf("limit must be in range 1..%d", models.MaxRecordsLimit)
+		}
+		query.Limit = n
+	}
+
+	if since := c.Query("updated_since"); since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return query, fmt.Errorf("error parsing updated_since: %w", err)
+		}
+		query.UpdatedSince = t
+	}
+
+	return query, nil
 }
 
 func (a *App) GetDataRecord(c *gin.Context) {
@@ -242,19 +459,42 @@ func (a *App) GetDataRecord(c *gin.Context) {
 		return
 	}
 
-	orders, err := a.store.GetUserRecord(recordName, userID)
+	folderID, err := parseFolderID(c)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	record, err := a.store.GetUserRecord(recordName, folderID, userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			res.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		a.logger.Errorf("error getting user orders: %v", err)
+		a.logger.Errorf("error getting user record: %v", err)
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	c.JSON(http.StatusOK, orders)
+	a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditRecordRead, Target: record.Name, RecordID: record.ID})
+
+	c.JSON(http.StatusOK, record)
+}
+
+// parseFolderID reads the optional folder_id query parameter.
+func parseFolderID(c *gin.Context) (*uint64, error) {
+	raw, ok := c.GetQuery("folder_id")
+	if !ok {
+		return nil, nil
+	}
+
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing folder_id: %w", err)
+	}
+
+	return &id, nil
 }
 
 func (a *App) Ping(c *gin.Context) {
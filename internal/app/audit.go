@@ -0,0 +1,200 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rawen554/goph-keeper/internal/middleware/auth"
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// auditFailedLoginInterval is the least time between failed login events of a user, so
+// guessing passwords does not grow the audit log with the rate of requests. Failures in
+// between are summed up by one event at the end of the interval.
+const auditFailedLoginInterval = time.Minute
+
+// failedLogins are the failures of a user since the last login.failed event, IP and
+// UserAgent are of the latest one.
+type failedLogins struct {
+	timer     *time.Timer
+	ip        string
+	userAgent string
+	count     uint64
+}
+
+// Targets of events about many records.
+const (
+	auditTargetLogin   = "login"
+	auditTargetRecords = "records"
+	auditTargetSearch  = "search"
+	auditTargetTrash   = "trash"
+)
+
+// audit appends an event to the user audit log. The request is served even if the event
+// cannot be stored, the error is logged.
+func (a *App) audit(c *gin.Context, e models.AuditEvent) {
+	e.IP = c.ClientIP()
+	e.UserAgent = c.Request.UserAgent()
+
+	a.appendAudit(e)
+}
+
+func (a *App) appendAudit(e models.AuditEvent) {
+	if err := a.store.AppendAuditEvent(&e, a.auditKey); err != nil {
+		a.logger.Errorf("error appending audit event %s of user %d: %v", e.Action, e.UserID, err)
+	}
+}
+
+// auditLoginFailed logs a failed login of the user at once unless one has been logged within
+// the interval. Then the failure is counted and the count is logged when the interval is over.
+func (a *App) auditLoginFailed(c *gin.Context, userID uint64) {
+	a.failedMu.Lock()
+	if f, ok := a.failed[userID]; ok {
+		f.count++
+		f.ip, f.userAgent = c.ClientIP(), c.Request.UserAgent()
+		a.failedMu.Unlock()
+		return
+	}
+	a.failed[userID] = &failedLogins{
+		timer: time.AfterFunc(auditFailedLoginInterval, func() { a.flushFailedLogins(userID) }),
+	}
+	a.failedMu.Unlock()
+
+	a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditLoginFailed, Target: auditTargetLogin, Count: 1})
+}
+
+// flushFailedLogins logs the failures counted within the interval as one event and starts
+// the next interval. Without failures the user is forgotten.
+func (a *App) flushFailedLogins(userID uint64) {
+	a.failedMu.Lock()
+	f, ok := a.failed[userID]
+	if !ok {
+		a.failedMu.Unlock()
+		return
+	}
+	if f.count == 0 {
+		delete(a.failed, userID)
+		a.failedMu.Unlock()
+		return
+	}
+	e := f.event(userID)
+	f.count = 0
+	f.timer.Reset(auditFailedLoginInterval)
+	a.failedMu.Unlock()
+
+	a.appendAudit(e)
+}
+
+// FlushAudit logs the failed logins counted so far. It is called after the server is shut down,
+// before the store is closed.
+func (a *App) FlushAudit() {
+	a.failedMu.Lock()
+	pending := make([]models.AuditEvent, 0)
+	for userID, f := range a.failed {
+		// a timer which has fired already finds the user gone
+		f.timer.Stop()
+		if f.count != 0 {
+			pending = append(pending, f.event(userID))
+		}
+		delete(a.failed, userID)
+	}
+	a.failedMu.Unlock()
+
+	for _, e := range pending {
+		a.appendAudit(e)
+	}
+}
+
+func (f *failedLogins) event(userID uint64) models.AuditEvent {
+	return models.AuditEvent{
+		UserID:    userID,
+		Action:    models.AuditLoginFailed,
+		Target:    auditTargetLogin,
+		IP:        f.ip,
+		UserAgent: f.userAgent,
+		Count:     f.count,
+	}
+}
+
+// GetAuditEvents lists the user audit log, the latest events first.
+func (a *App) GetAuditEvents(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	query, err := parseAuditQuery(c)
+	if err != nil {
+		a.logger.Errorf("bad audit query: %v", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	page, err := a.store.GetAuditEvents(userID, query)
+	if err != nil {
+		a.logger.Errorf("error getting audit events: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// parseAuditQuery reads action, since, until, target, before and limit query parameters.
+// Several actions are separated by commas.
+func parseAuditQuery(c *gin.Context) (models.AuditQuery, error) {
+	query := models.AuditQuery{
+		Target: c.Query("target"),
+		Limit:  models.DefaultAuditLimit,
+	}
+
+	if actions := c.Query("action"); actions != "" {
+		for _, action := range strings.Split(actions, ",") {
+			action := models.AuditAction(strings.TrimSpace(action))
+			if !action.Valid() {
+				return query, fmt.Errorf("unknown action: %s", action)
+			}
+			query.Actions = append(query.Actions, action)
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > models.MaxAuditLimit {
+			return query, fmt.Errorf("limit must be in range 1..%d", models.MaxAuditLimit)
+		}
+		query.Limit = n
+	}
+
+	if before := c.Query("before"); before != "" {
+		seq, err := strconv.ParseUint(before, 10, 64)
+		if err != nil {
+			return query, fmt.Errorf("error parsing before: %w", err)
+		}
+		query.Before = seq
+	}
+
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return query, fmt.Errorf("error parsing since: %w", err)
+		}
+		query.Since = t
+	}
+
+	if until := c.Query("until"); until != "" {
+		t, err := time.Parse(time.RFC3339Nano, until)
+		if err != nil {
+			return query, fmt.Errorf("error parsing until: %w", err)
+		}
+		query.Until = t
+	}
+
+	return query, nil
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/middleware/auth"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+)
+
+const maxBatchSize = 1000
+
+func (a *App) BatchDataRecords(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	req := c.Request
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var batch models.BatchRequest
+	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+		a.logger.Errorf("cannot decode body: %v", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if len(batch.Operations) == 0 || len(batch.Operations) > maxBatchSize {
+		a.logger.Errorf("batch size %d is out of range", len(batch.Operations))
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if batch.Mode == "" {
+		batch.Mode = models.BatchAtomic
+	}
+	if batch.Mode != models.BatchAtomic && batch.Mode != models.BatchBestEffort {
+		a.logger.Errorf("unknown batch mode: %s", batch.Mode)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	atomic := batch.Mode == models.BatchAtomic
+
+	results := make([]models.BatchItemResult, len(batch.Operations))
+	items := make([]store.BatchItem, 0, len(batch.Operations))
+	indexes := make([]int, 0, len(batch.Operations))
+	invalid := false
+	for i, op := range batch.Operations {
+		item, err := newBatchItem(op, userID)
+		results[i] = models.BatchItemResult{Index: i, Name: op.Name}
+		if op.Record != nil {
+			results[i].Name = op.Record.Name
+		}
+		if err != nil {
+			invalid = true
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = err.Error()
+			continue
+		}
+
+		items = append(items, item)
+		indexes = append(indexes, i)
+	}
+
+	if invalid && atomic {
+		for i := range results {
+			if results[i].Status == 0 {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Error = store.ErrBatchAborted.Error()
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, models.BatchResponse{Applied: false, Results: results})
+		return
+	}
+
+	errs, err := a.store.ApplyBatch(items, userID, atomic)
+	if err != nil {
+		a.logger.Errorf("unhandled error: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	applied := true
+	for j, err := range errs {
+		i := indexes[j]
+		if err == nil {
+			results[i].Status = http.StatusOK
+			results[i].Record = items[j].Record
+			a.audit(c, batchAuditEvent(items[j], userID))
+			continue
+		}
+		if errors.Is(err, store.ErrAlreadyApplied) {
+			results[i].Status = http.StatusOK
+			results[i].Replayed = true
+			continue
+		}
+
+		applied = false
+		results[i].Error = err.Error()
+		switch {
+		case errors.Is(err, store.ErrBatchAborted):
+			results[i].Status = http.StatusFailedDependency
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			results[i].Status = http.StatusNotFound
+		case errors.Is(err, store.ErrFolderNotFound):
+			results[i].Status = http.StatusBadRequest
+		case errors.Is(err, store.ErrDuplicateName):
+			results[i].Status = http.StatusConflict
+		case errors.Is(err, store.ErrRecordChanged):
+			results[i].Status = http.StatusPreconditionFailed
+		default:
+			a.logger.Errorf("batch item %d failed: %v", i, err)
+			results[i].Status = http.StatusInternalServerError
+		}
+	}
+
+	if atomic && !applied {
+		c.JSON(http.StatusUnprocessableEntity, models.BatchResponse{Applied: false, Results: results})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.BatchResponse{Applied: applied, Results: results})
+}
+
+// batchAuditEvent describes an applied batch item, replayed ones are not logged again.
+func batchAuditEvent(item store.BatchItem, userID uint64) models.AuditEvent {
+	if item.Op == models.BatchDelete {
+		return models.AuditEvent{UserID: userID, Action: models.AuditRecordDelete, Target: item.Name}
+	}
+
+	return models.AuditEvent{UserID: userID, Action: models.AuditRecordWrite, Target: item.Record.Name, RecordID: item.Record.ID}
+}
+
+func newBatchItem(op models.BatchOperation, userID uint64) (store.BatchItem, error) {
+	if len(op.IdempotencyKey) > models.MaxIdempotencyKeyLen {
+		return store.BatchItem{}, fmt.Errorf("idempotency key is longer than %d", models.MaxIdempotencyKeyLen)
+	}
+
+	switch op.Op {
+	case models.BatchUpsert:
+		if op.Record == nil {
+			return store.BatchItem{}, errors.New("upsert without record")
+		}
+
+		record, err := newDataRecord(op.Record, userID)
+		if err != nil {
+			return store.BatchItem{}, err
+		}
+		record.ID = 0
+
+		return store.BatchItem{Op: op.Op, Record: record, IdempotencyKey: op.IdempotencyKey, IfChecksum: op.IfChecksum}, nil
+	case models.BatchDelete:
+		if op.Name == "" {
+			return store.BatchItem{}, errEmptyName
+		}
+
+		return store.BatchItem{Op: op.Op, Name: op.Name, FolderID: op.FolderID, IdempotencyKey: op.IdempotencyKey}, nil
+	default:
+		return store.BatchItem{}, fmt.Errorf("unknown operation: %q", op.Op)
+	}
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/middleware/auth"
+	"go.uber.org/zap"
+)
+
+const (
+	caYearsGrant     = 10
+	deviceYearsGrant = 1
+	serialBits       = 128
+	// clockSkew lets certificates be used right away by devices with clocks slightly behind.
+	clockSkew = 5 * time.Minute
+)
+
+var errBadCSR = errors.New("malformed certificate request")
+
+// CA is the internal certificate authority issuing client certificates of user devices.
+type CA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	pool *x509.CertPool
+}
+
+// LoadOrCreateCA reads the CA certificate and key, they are created when both are missing.
+func LoadOrCreateCA(certPath string, keyPath string, logger *zap.SugaredLogger) (*CA, error) {
+	certPEM, errCert := os.ReadFile(certPath)
+	keyPEM, errKey := os.ReadFile(keyPath)
+	if errors.Is(errCert, os.ErrNotExist) && errors.Is(errKey, os.ErrNotExist) {
+		logger.Infof("creating device CA %s", certPath)
+		return createCA(certPath, keyPath)
+	}
+	if errCert != nil {
+		return nil, fmt.Errorf("error reading CA cert: %w", errCert)
+	}
+	if errKey != nil {
+		return nil, fmt.Errorf("error reading CA key: %w", errKey)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, errors.New("no PEM data in CA cert")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA cert: %w", err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("no PEM data in CA key")
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA key: %w", err)
+	}
+
+	return newCA(cert, key), nil
+}
+
+func createCA(certPath string, keyPath string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CA key: %w", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"GophKeeper"},
+			CommonName:   "GophKeeper device CA",
+		},
+		NotBefore:             time.Now().Add(-clockSkew),
+		NotAfter:              time.Now().AddDate(caYearsGrant, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CA cert: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA cert: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding CA key: %w", err)
+	}
+
+	if err := writePEM(certPath, "CERTIFICATE", der); err != nil {
+		return nil, err
+	}
+	if err := writePEM(keyPath, "EC PRIVATE KEY", keyDER); err != nil {
+		return nil, err
+	}
+
+	return newCA(cert, key), nil
+}
+
+func newCA(cert *x509.Certificate, key crypto.Signer) *CA {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &CA{cert: cert, key: key, pool: pool}
+}
+
+// Pool holds the CA certificate to verify client certificates with.
+func (ca *CA) Pool() *x509.CertPool {
+	return ca.pool
+}
+
+// Check fails when the CA certificate is not valid now, certificates issued by it are not accepted then.
+func (ca *CA) Check() error {
+	return checkValidity(ca.cert)
+}
+
+// IssueDeviceCert signs a client certificate of the user for the key of the PEM certificate request.
+// The subject of the request is ignored, the certificate names the user.
+func (ca *CA) IssueDeviceCert(csrPEM string, userID uint64) (certPEM string, fingerprint string, err error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return "", "", err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return "", "", err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"GophKeeper"},
+			CommonName:   fmt.Sprintf("user %d device", userID),
+		},
+		NotBefore:   time.Now().Add(-clockSkew),
+		NotAfter:    time.Now().AddDate(deviceYearsGrant, 0, 0),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return "", "", fmt.Errorf("error creating device cert: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), auth.CertFingerprint(der), nil
+}
+
+// parseCSR reads a PEM certificate request signed by the key it carries.
+func parseCSR(csrPEM string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errBadCSR
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadCSR, err)
+	}
+
+	return csr, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialBits))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %w", err)
+	}
+
+	return serial, nil
+}
+
+func writePEM(path string, blockType string, der []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating dir of %s: %w", path, err)
+	}
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), CertsPerm); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+
+	return nil
+}
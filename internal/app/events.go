@@ -0,0 +1,70 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rawen554/goph-keeper/internal/middleware/auth"
+)
+
+// eventsHeartbeat keeps idle event streams from being closed by proxies.
+const eventsHeartbeat = 30 * time.Second
+
+// StreamEvents pushes changes of user data as server-sent events until the client disconnects
+// or the server shuts down.
+// Every event is a json models.Event named by its type. The stream ends when the client falls
+// behind, so it has to resync from its cursor and reconnect.
+func (a *App) StreamEvents(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	events, cancel := a.broker.Subscribe(userID)
+	defer cancel()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(res, ": connected\n\n"); err != nil {
+		return
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-a.streams.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				a.logger.Infof("dropped lagging event stream of user %d", userID)
+				return
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				a.logger.Errorf("error encoding event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+		}
+		res.Flush()
+	}
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/middleware/auth"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+)
+
+func (a *App) GetFolders(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	folders, err := a.store.GetUserFolders(userID)
+	if err != nil {
+		a.logger.Errorf("error getting user folders: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, folders)
+}
+
+func (a *App) CreateFolder(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	folder, ok := a.decodeFolder(c, userID)
+	if !ok {
+		return
+	}
+
+	if err := a.store.CreateFolder(folder); err != nil {
+		a.writeFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, folder)
+}
+
+// UpdateFolder renames a folder or moves it to another parent.
+func (a *App) UpdateFolder(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	folder, ok := a.decodeFolder(c, userID)
+	if !ok {
+		return
+	}
+	folder.ID = id
+
+	if err := a.store.UpdateFolder(folder); err != nil {
+		a.writeFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, folder)
+}
+
+// DeleteFolder removes a folder, moving its content into the parent folder.
+func (a *App) DeleteFolder(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := a.store.DeleteFolder(id, userID); err != nil {
+		a.writeFolderError(c, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// MoveDataRecords places records into a folder.
+func (a *App) MoveDataRecords(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req models.MoveRecordsRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		a.logger.Errorf("cannot decode body: %v", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := a.store.MoveRecords(req.IDs, req.FolderID, userID); err != nil {
+		a.writeFolderError(c, err)
+		return
+	}
+
+	for _, id := range req.IDs {
+		a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditRecordWrite, RecordID: id})
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) decodeFolder(c *gin.Context, userID uint64) (*models.Folder, bool) {
+	var req models.FolderRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		a.logger.Errorf("cannot decode body: %v", err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || strings.Contains(req.Name, models.PathSeparator) {
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &models.Folder{Name: req.Name, ParentID: req.ParentID, UserID: userID}, true
+}
+
+func (a *App) writeFolderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrFolderNotFound), errors.Is(err, gorm.ErrRecordNotFound):
+		c.Writer.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, store.ErrDuplicateName):
+		c.Writer.WriteHeader(http.StatusConflict)
+	case errors.Is(err, store.ErrFolderCycle):
+		c.Writer.WriteHeader(http.StatusBadRequest)
+	default:
+		a.logger.Errorf("unhandled error: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+	}
+}
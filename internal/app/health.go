@@ -0,0 +1,132 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// readinessTimeout limits the checks of a readiness probe, a check still running is failed.
+const readinessTimeout = 2 * time.Second
+
+var errCheckTimeout = errors.New("check has timed out")
+
+// Healthz tells that the process is alive, dependencies are not checked.
+func (a *App) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, models.HealthResponse{Status: models.HealthOK})
+}
+
+// Readyz checks the dependencies needed to serve requests. It fails once the server
+// is draining, so load balancers stop sending requests before the server stops.
+func (a *App) Readyz(c *gin.Context) {
+	if a.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, models.HealthResponse{
+			Status: models.HealthFail,
+			Checks: map[string]models.CheckResult{
+				"shutdown": {Status: models.HealthFail},
+			},
+		})
+		return
+	}
+
+	res := models.HealthResponse{Status: models.HealthOK, Checks: make(map[string]models.CheckResult)}
+	for name, err := range a.runChecks() {
+		if err != nil {
+			a.logger.Warnf("readiness check %s has failed: %v", name, err)
+			res.Status = models.HealthFail
+			res.Checks[name] = models.CheckResult{Status: models.HealthFail}
+			continue
+		}
+		res.Checks[name] = models.CheckResult{Status: models.HealthOK}
+	}
+
+	if res.Status != models.HealthOK {
+		c.JSON(http.StatusServiceUnavailable, res)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// Drain fails readiness from now on, it is called when the server starts shutting down.
+func (a *App) Drain() {
+	a.draining.Store(true)
+}
+
+func (a *App) readinessChecks() map[string]func() error {
+	checks := map[string]func() error{
+		"db":         a.store.Ping,
+		"migrations": a.store.CheckMigrations,
+		"userdata":   func() error { return checkWritable(models.UserDataDir) },
+	}
+	if a.certs != nil {
+		checks["tls_certificate"] = a.certs.Check
+	}
+	if a.ca != nil {
+		checks["device_ca"] = a.ca.Check
+	}
+
+	return checks
+}
+
+// runChecks runs the readiness checks at once and returns their errors by name.
+func (a *App) runChecks() map[string]error {
+	type result struct {
+		err  error
+		name string
+	}
+
+	checks := a.readinessChecks()
+	results := make(chan result, len(checks))
+	for name, check := range checks {
+		name, check := name, check
+		go func() {
+			results <- result{name: name, err: check()}
+		}()
+	}
+
+	errs := make(map[string]error, len(checks))
+	for name := range checks {
+		errs[name] = errCheckTimeout
+	}
+
+	timeout := time.NewTimer(readinessTimeout)
+	defer timeout.Stop()
+	for range checks {
+		select {
+		case r := <-results:
+			errs[r.name] = r.err
+		case <-timeout.C:
+			return errs
+		}
+	}
+
+	return errs
+}
+
+// checkWritable creates and removes a file in dir.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating %s: %w", dir, err)
+	}
+
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("error creating file in %s: %w", dir, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(models.HealthOK); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing file in %s: %w", dir, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing file in %s: %w", dir, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ResetCodeTTL is how long a reset code issued by an operator stays valid.
+const ResetCodeTTL = time.Hour * 72
+
+const resetCodeSize = 20
+
+// NewResetCode makes a one-time password reset code. Only its hash is stored.
+func NewResetCode() (code string, hash string, err error) {
+	b := make([]byte, resetCodeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("cannot generate reset code: %w", err)
+	}
+	code = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+
+	return code, HashResetCode(code), nil
+}
+
+// HashResetCode is the stored form of a reset code.
+func HashResetCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasEmptyPassword reports whether the account was registered while passwords of requests
+// were not decoded. Anyone could log in to it until an operator issues a reset code.
+func HasEmptyPassword(u *models.User) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), nil) == nil
+}
+
+// ResetPassword sets a new password with a code issued by the reset-password command.
+func (a *App) ResetPassword(c *gin.Context) {
+	req := c.Request
+	res := c.Writer
+
+	reset := models.PasswordResetRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&reset); err != nil {
+		a.logger.Errorf("password reset cannot be decoded: %v", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if reset.Login == "" || reset.Code == "" || reset.Password == "" {
+		a.logger.Errorf("password reset of %q without a code or password", reset.Login)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(reset.Password), bcryptCost)
+	if err != nil {
+		a.logger.Errorf("cannot hash pass: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := a.store.ResetPassword(reset.Login, HashResetCode(reset.Code), time.Now(), string(hash)); err != nil {
+		if errors.Is(err, store.ErrResetCodeInvalid) {
+			a.logger.Warnf("password reset of %q with an invalid code from %s", reset.Login, c.ClientIP())
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
+		a.logger.Errorf("cannot reset password: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if u, err := a.store.GetUser(&models.User{Login: reset.Login}); err == nil {
+		a.audit(c, models.AuditEvent{UserID: u.ID, Action: models.AuditPasswordReset, Target: auditTargetLogin})
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
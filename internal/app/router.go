@@ -2,40 +2,86 @@ package app
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rawen554/goph-keeper/internal/middleware/auth"
 	"github.com/rawen554/goph-keeper/internal/middleware/compress"
 	ginLogger "github.com/rawen554/goph-keeper/internal/middleware/logger"
+	ginMetrics "github.com/rawen554/goph-keeper/internal/middleware/metrics"
 )
 
 const (
 	rootRoute    = "/"
 	userAPIRoute = "/api/user"
+	pingRoute    = "/ping"
+	healthRoute  = "/healthz"
+	readyRoute   = "/readyz"
 )
 
 func (a *App) SetupRouter() (*gin.Engine, error) {
 	r := gin.New()
+	// client addresses of audit events come from X-Forwarded-For of trusted proxies only
+	if err := r.SetTrustedProxies(a.trustedProxies()); err != nil {
+		return nil, fmt.Errorf("error setting trusted proxies: %w", err)
+	}
 	ginLoggerMiddleware, err := ginLogger.Logger(a.logger)
 	if err != nil {
 		return nil, fmt.Errorf("error creating middleware logger func: %w", err)
 	}
 	r.Use(ginLoggerMiddleware)
+	r.Use(ginMetrics.Metrics())
 	r.Use(compress.Compress(a.logger.Named("gzip")))
 
+	r.GET(pingRoute, a.Ping)
+	r.GET(healthRoute, a.Healthz)
+	r.GET(readyRoute, a.Readyz)
+
 	userAPI := r.Group(userAPIRoute)
 	{
 		userAPI.POST("register", a.Register)
 		userAPI.POST("login", a.Login)
+		userAPI.POST("password/reset", a.ResetPassword)
 
 		recordsAPI := userAPI.Group("records")
 		recordsAPI.Use(auth.AuthMiddleware(a.logger))
 		{
 			recordsAPI.POST(rootRoute, a.PutDataRecord)
+			recordsAPI.POST("batch", a.BatchDataRecords)
 			recordsAPI.GET(rootRoute, a.GetDataRecords)
-			recordsAPI.GET(":id", a.GetDataRecord)
+			recordsAPI.GET("search", a.SearchDataRecords)
+			recordsAPI.POST("move", a.MoveDataRecords)
+			recordsAPI.GET("trash", a.GetTrash)
+			recordsAPI.DELETE("trash", a.PurgeTrash)
+			recordsAPI.GET(":name", a.GetDataRecord)
+			recordsAPI.DELETE(":name", a.DeleteDataRecord)
+			recordsAPI.POST(":name/restore", a.RestoreDataRecord)
+		}
+
+		userAPI.GET("events", auth.AuthMiddleware(a.logger), a.StreamEvents)
+		userAPI.GET("audit", auth.AuthMiddleware(a.logger), a.GetAuditEvents)
+
+		foldersAPI := userAPI.Group("folders")
+		foldersAPI.Use(auth.AuthMiddleware(a.logger))
+		{
+			foldersAPI.GET(rootRoute, a.GetFolders)
+			foldersAPI.POST(rootRoute, a.CreateFolder)
+			foldersAPI.PUT(":id", a.UpdateFolder)
+			foldersAPI.DELETE(":id", a.DeleteFolder)
 		}
 	}
 
 	return r, nil
 }
+
+// trustedProxies lists the configured proxies, nil trusts none.
+func (a *App) trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(a.config.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rawen554/goph-keeper/internal/middleware/auth"
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+const minSearchQueryLen = 2
+
+// SearchDataRecords looks up records by name, URL and tags. Secret fields are never searched
+// nor returned, clients fetch a found record to read its data.
+func (a *App) SearchDataRecords(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if len([]rune(query)) < minSearchQueryLen {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	limit := models.DefaultRecordsLimit
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > models.MaxRecordsLimit {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	records, err := a.store.SearchUserRecords(userID, query, limit)
+	if err != nil {
+		a.logger.Errorf("error searching user records: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditRecordsList, Target: auditTargetSearch})
+
+	c.JSON(http.StatusOK, records)
+}
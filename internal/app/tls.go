@@ -1,115 +1,277 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
-	"math/big"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/rawen554/goph-keeper/internal/certpin"
 	"go.uber.org/zap"
 )
 
 const (
-	serialNumber = 1
-	ip4GrayZone  = 127
-	yearsGrant   = 1
-	RSALen       = 4096
-	CertsPerm    = 0600
+	yearsGrant = 1
+	CertsPerm  = 0600
+	// renewBefore is how long before expiry a self-signed certificate is renewed.
+	renewBefore = 30 * 24 * time.Hour
+	// certCheckInterval is how often the certificate files are checked for renewal and changes.
+	certCheckInterval = time.Hour
 )
 
-func CreateCertificates(logger *zap.SugaredLogger) (privateKey *rsa.PrivateKey, certBytes []byte, err error) {
-	// создаём шаблон сертификата
+// CreateCertificates issues a self-signed server certificate for hosts, which are DNS names
+// or IP addresses. A nil key is generated, a renewed certificate keeps the key, and so its pin.
+func CreateCertificates(hosts []string, key crypto.Signer) (crypto.Signer, []byte, error) {
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, nil, fmt.Errorf("error creating ECDSA key: %w", err)
+		}
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	cert := &x509.Certificate{
-		// указываем уникальный номер сертификата
-		SerialNumber: big.NewInt(serialNumber),
+		SerialNumber: serial,
 		// заполняем базовую информацию о владельце сертификата
 		Subject: pkix.Name{
 			Organization: []string{"GophKeeper"},
 			Country:      []string{"RU"},
 		},
-		// разрешаем использование сертификата для 127.0.0.1 и ::1
-		IPAddresses: []net.IP{net.IPv4(ip4GrayZone, 0, 0, 1), net.IPv6loopback},
-		// сертификат верен, начиная со времени создания
-		NotBefore: time.Now(),
-		// время жизни сертификата — 10 лет
-		NotAfter:     time.Now().AddDate(yearsGrant, 0, 0),
-		SubjectKeyId: []byte{1, 2, 3, 4, 6},
-		// устанавливаем использование ключа для цифровой подписи,
-		// а также серверной авторизации
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:    x509.KeyUsageDigitalSignature,
-	}
-
-	// создаём новый приватный RSA-ключ длиной 4096 бит
-	// обратите внимание, что для генерации ключа и сертификата
-	// используется rand.Reader в качестве источника случайных данных
-	privateKey, err = rsa.GenerateKey(rand.Reader, RSALen)
+		NotBefore:             time.Now().Add(-clockSkew),
+		NotAfter:              time.Now().AddDate(yearsGrant, 0, 0),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			cert.IPAddresses = append(cert.IPAddresses, ip)
+		} else {
+			cert.DNSNames = append(cert.DNSNames, host)
+		}
+	}
+	if len(cert.DNSNames) != 0 {
+		cert.Subject.CommonName = cert.DNSNames[0]
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, cert, key.Public(), key)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error creating RSA key: %w", err)
+		return nil, nil, fmt.Errorf("error creating certificate: %w", err)
 	}
 
-	// создаём сертификат x.509
-	certBytes, err = x509.CreateCertificate(rand.Reader, cert, cert, &privateKey.PublicKey, privateKey)
+	return key, certBytes, nil
+}
+
+// WriteCertificates saves the certificate and the key in PEM. Each file is replaced
+// at once, so a reader never sees a half-written one.
+func WriteCertificates(tlsCert []byte, tlsCertPath string, privateKey crypto.Signer, tlsKeyPath string) error {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error creating certificat: %w", err)
+		return fmt.Errorf("error encoding private key: %w", err)
+	}
+
+	if err := replaceFile(tlsKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})); err != nil {
+		return err
 	}
 
-	return privateKey, certBytes, nil
+	return replaceFile(tlsCertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsCert}))
 }
 
-func WriteCertificates(
-	tlsCert []byte,
-	tlsCertPath string,
-	privateKey *rsa.PrivateKey,
-	tlsKeyPath string,
-	logger *zap.SugaredLogger,
-) error {
-	if err := os.Mkdir("certs", os.ModePerm); err != nil {
-		return fmt.Errorf("unhandled mkdir to certs: %w", err)
+func replaceFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating dir of %s: %w", path, err)
 	}
 
-	// кодируем сертификат и ключ в формате PEM, который
-	// используется для хранения и обмена криптографическими ключами
-	certFile, err := os.OpenFile(tlsCertPath, os.O_WRONLY|os.O_CREATE, CertsPerm)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*")
 	if err != nil {
-		return fmt.Errorf("error opening cert file: %w", err)
+		return fmt.Errorf("error creating temp file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := tmp.Chmod(CertsPerm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error setting permissions of %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
 	}
 
-	defer func() {
-		if err := certFile.Close(); err != nil {
-			logger.Error(err)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// CertManager serves the server certificate from files through tls.Config.GetCertificate.
+// A missing certificate is created self-signed, a self-signed one is renewed before it
+// expires or when hosts change, and files replaced on disk are picked up without restart.
+// Certificates issued by others are only reloaded, they are renewed by their issuer.
+type CertManager struct {
+	cert     atomic.Pointer[tls.Certificate]
+	logger   *zap.SugaredLogger
+	modTime  time.Time
+	certPath string
+	keyPath  string
+	hosts    []string
+}
+
+func NewCertManager(certPath string, keyPath string, hosts []string, logger *zap.SugaredLogger) (*CertManager, error) {
+	m := &CertManager{certPath: certPath, keyPath: keyPath, hosts: hosts, logger: logger}
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.cert.Load(), nil
+}
+
+// Check fails when the served certificate is not valid now.
+func (m *CertManager) Check() error {
+	return checkValidity(m.cert.Load().Leaf)
+}
+
+// Run checks the certificate until ctx is done. Failures are logged,
+// the current certificate is served until a check succeeds.
+func (m *CertManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-	}()
 
-	if err := pem.Encode(certFile, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: tlsCert,
-	}); err != nil {
-		return fmt.Errorf("error encoding cert file: %w", err)
+		if err := m.check(); err != nil {
+			m.logger.Errorf("error checking tls certificate: %v", err)
+		}
 	}
+}
 
-	rsaFile, err := os.OpenFile(tlsKeyPath, os.O_WRONLY|os.O_CREATE, CertsPerm)
+// check creates or renews the certificate when needed and loads it when the files change.
+func (m *CertManager) check() error {
+	cert, err := tls.LoadX509KeyPair(m.certPath, m.keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		m.logger.Infof("creating self-signed certificate for %s", strings.Join(m.hosts, ", "))
+		return m.issue(nil)
+	}
+	if err != nil {
+		return fmt.Errorf("error loading tls certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("error parsing tls certificate: %w", err)
+	}
+
+	if reason := m.renewalReason(leaf); reason != "" {
+		if isSelfSigned(leaf) {
+			m.logger.Infof("renewing self-signed certificate, it %s", reason)
+			// the key is kept, so pins of clients stay valid
+			key, _ := cert.PrivateKey.(crypto.Signer)
+			return m.issue(key)
+		}
+		m.logger.Warnf("tls certificate %s %s, it has to be renewed by its issuer", m.certPath, reason)
+	}
+
+	return m.load(false)
+}
+
+// issue writes a new self-signed certificate and loads it.
+func (m *CertManager) issue(key crypto.Signer) error {
+	key, certBytes, err := CreateCertificates(m.hosts, key)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteCertificates(certBytes, m.certPath, key, m.keyPath); err != nil {
+		return fmt.Errorf("error writing tls certs: %w", err)
+	}
+
+	return m.load(true)
+}
+
+// load reads the certificate files unless they are unchanged since the last load.
+func (m *CertManager) load(force bool) error {
+	info, err := os.Stat(m.certPath)
+	if err != nil {
+		return fmt.Errorf("error reading tls certificate: %w", err)
+	}
+	if !force && m.cert.Load() != nil && info.ModTime().Equal(m.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.certPath, m.keyPath)
 	if err != nil {
-		return fmt.Errorf("error opening key file: %w", err)
+		return fmt.Errorf("error loading tls certificate: %w", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return fmt.Errorf("error parsing tls certificate: %w", err)
+	}
+
+	m.cert.Store(&cert)
+	m.modTime = info.ModTime()
+	m.logger.Infof("loaded tls certificate valid until %s, public key pin: %s",
+		cert.Leaf.NotAfter.Format(time.RFC3339), certpin.Of(cert.Leaf))
+
+	return nil
+}
+
+// renewalReason explains why the certificate has to be renewed, it is empty when it does not.
+func (m *CertManager) renewalReason(leaf *x509.Certificate) string {
+	if time.Until(leaf.NotAfter) < renewBefore {
+		return "expires at " + leaf.NotAfter.Format(time.RFC3339)
 	}
 
-	defer func() {
-		if err := rsaFile.Close(); err != nil {
-			logger.Error(err)
+	for _, host := range m.hosts {
+		if err := leaf.VerifyHostname(host); err != nil {
+			return "is not valid for " + host
 		}
-	}()
+	}
 
-	if err := pem.Encode(rsaFile, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	}); err != nil {
-		return fmt.Errorf("error encoding RSA private key: %w", err)
+	return ""
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+func checkValidity(cert *x509.Certificate) error {
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("certificate is not valid until %s", cert.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("certificate has expired at %s", cert.NotAfter.Format(time.RFC3339))
 	}
 
 	return nil
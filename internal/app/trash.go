@@ -0,0 +1,117 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rawen554/goph-keeper/internal/adapters/store"
+	"github.com/rawen554/goph-keeper/internal/middleware/auth"
+	"github.com/rawen554/goph-keeper/internal/models"
+	"gorm.io/gorm"
+)
+
+// DeleteDataRecord moves a record into the trash.
+func (a *App) DeleteDataRecord(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	folderID, err := parseFolderID(c)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	name := c.Param("name")
+	if err := a.store.DeleteUserRecord(name, folderID, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		a.logger.Errorf("error deleting user record: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditRecordDelete, Target: name})
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) GetTrash(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	records, err := a.store.GetDeletedRecords(userID)
+	if err != nil {
+		a.logger.Errorf("error getting deleted records: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditRecordsList, Target: auditTargetTrash})
+
+	c.JSON(http.StatusOK, records)
+}
+
+func (a *App) RestoreDataRecord(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	folderID, err := parseFolderID(c)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	record, err := a.store.RestoreUserRecord(c.Param("name"), folderID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			res.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, store.ErrDuplicateName):
+			res.WriteHeader(http.StatusConflict)
+		default:
+			a.logger.Errorf("error restoring user record: %v", err)
+			res.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditRecordRestore, Target: record.Name, RecordID: record.ID})
+
+	c.JSON(http.StatusOK, record)
+}
+
+// PurgeTrash permanently removes every record in the user's trash.
+func (a *App) PurgeTrash(c *gin.Context) {
+	userID := c.GetUint64(auth.UserIDKey.ToString())
+	res := c.Writer
+	if userID == 0 {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := a.store.PurgeDeletedRecords(userID); err != nil {
+		a.logger.Errorf("error purging trash: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.audit(c, models.AuditEvent{UserID: userID, Action: models.AuditTrashPurge, Target: auditTargetTrash})
+
+	res.WriteHeader(http.StatusNoContent)
+}
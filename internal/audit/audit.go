@@ -0,0 +1,141 @@
+// Package audit verifies the hash chains of the audit log.
+package audit
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// scanBatch is the number of events read at once.
+const scanBatch = 1000
+
+var ErrBrokenChain = errors.New("audit chain is broken")
+
+// Log is the audit log read chain by chain, with the watermarks of purged chains.
+type Log interface {
+	ScanAuditLog(afterUserID uint64, afterSeq uint64, limit int) ([]models.AuditEvent, error)
+	GetAuditWatermarks() ([]models.AuditWatermark, error)
+}
+
+// Chain sums up the audit events of a user. Head is the hash of the last event, it may be
+// kept elsewhere to detect events removed from the end later. First is above 1 when older
+// events have been purged, the watermark of the chain has to point at it.
+type Chain struct {
+	Head   string
+	UserID uint64
+	Events uint64
+	First  uint64
+	Seq    uint64
+}
+
+// Report is the outcome of a verification. Problems are wrapped ErrBrokenChain.
+type Report struct {
+	Chains     []Chain
+	Problems   []error
+	key        []byte
+	watermarks map[uint64]models.AuditWatermark
+}
+
+// Verify checks every chain of the log against hashes made with key. A broken link is reported
+// and the chain is checked on from the event, so every change is reported once. A chain may
+// start after its first event only where its watermark, left by the purge, says so.
+func Verify(log Log, key []byte) (*Report, error) {
+	watermarks, err := log.GetAuditWatermarks()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{key: key, watermarks: make(map[uint64]models.AuditWatermark, len(watermarks))}
+	for _, w := range watermarks {
+		if w.ComputeHash(key) != w.Hash {
+			report.Problems = append(report.Problems, fmt.Errorf("%w: user %d: watermark has been changed",
+				ErrBrokenChain, w.UserID))
+			continue
+		}
+		report.watermarks[w.UserID] = w
+	}
+
+	var afterUserID, afterSeq uint64
+	for {
+		events, err := log.ScanAuditLog(afterUserID, afterSeq, scanBatch)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range events {
+			report.add(e)
+		}
+
+		if len(events) < scanBatch {
+			report.checkRemovedChains(watermarks)
+			return report, nil
+		}
+		last := events[len(events)-1]
+		afterUserID, afterSeq = last.UserID, last.Seq
+	}
+}
+
+func (r *Report) add(e models.AuditEvent) {
+	if len(r.Chains) == 0 || r.Chains[len(r.Chains)-1].UserID != e.UserID {
+		r.Chains = append(r.Chains, Chain{UserID: e.UserID, First: e.Seq})
+	}
+	c := &r.Chains[len(r.Chains)-1]
+
+	switch {
+	case c.Events == 0:
+		r.checkStart(e)
+	case e.Seq <= c.Seq:
+		r.problem(e, "event is out of order after event %d", c.Seq)
+	case e.Seq > c.Seq+1:
+		r.problem(e, "events missing before it: %d", e.Seq-c.Seq-1)
+	case e.PrevHash != c.Head:
+		r.problem(e, "previous event has been changed")
+	}
+	if e.ComputeHash(r.key) != e.Hash {
+		r.problem(e, "event has been changed")
+	}
+
+	c.Head = e.Hash
+	c.Seq = e.Seq
+	c.Events++
+}
+
+// checkStart checks the first event left of a chain against its watermark. Without one the
+// chain has to start at 1, events before the watermark should have been purged with it.
+func (r *Report) checkStart(e models.AuditEvent) {
+	w, ok := r.watermarks[e.UserID]
+	switch {
+	case !ok && e.Seq > 1:
+		r.problem(e, "events missing before it: %d", e.Seq-1)
+	case !ok && e.PrevHash != "":
+		r.problem(e, "previous event has been changed")
+	case !ok:
+	case e.Seq < w.Seq:
+		r.problem(e, "event is before the purge watermark %d", w.Seq)
+	case e.Seq > w.Seq:
+		r.problem(e, "events missing before it: %d", e.Seq-w.Seq)
+	case e.PrevHash != w.PrevHash:
+		r.problem(e, "previous event has been changed")
+	}
+}
+
+// checkRemovedChains reports watermarks without events, the last event of a chain is never purged.
+func (r *Report) checkRemovedChains(watermarks []models.AuditWatermark) {
+	chains := make(map[uint64]bool, len(r.Chains))
+	for _, c := range r.Chains {
+		chains[c.UserID] = true
+	}
+	for _, w := range watermarks {
+		if _, valid := r.watermarks[w.UserID]; valid && !chains[w.UserID] {
+			r.Problems = append(r.Problems, fmt.Errorf("%w: user %d: every event after the watermark %d has been removed",
+				ErrBrokenChain, w.UserID, w.Seq))
+		}
+	}
+}
+
+func (r *Report) problem(e models.AuditEvent, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Errorf("%w: user %d, event %d: %s",
+		ErrBrokenChain, e.UserID, e.Seq, fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,254 @@
+package audit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+var testKey = []byte("audit test key")
+
+// testLog is an audit log kept in order of users and events.
+type testLog struct {
+	events     []models.AuditEvent
+	watermarks []models.AuditWatermark
+}
+
+func (l *testLog) ScanAuditLog(afterUserID uint64, afterSeq uint64, limit int) ([]models.AuditEvent, error) {
+	events := make([]models.AuditEvent, 0)
+	for _, e := range l.events {
+		if (e.UserID > afterUserID || (e.UserID == afterUserID && e.Seq > afterSeq)) && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+func (l *testLog) GetAuditWatermarks() ([]models.AuditWatermark, error) {
+	return l.watermarks, nil
+}
+
+// chain makes n events of the user hashed with key, like the store appends them.
+func chain(userID uint64, n int) []models.AuditEvent {
+	events := make([]models.AuditEvent, 0, n)
+	created := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		e := models.AuditEvent{
+			UserID:    userID,
+			Seq:       uint64(i + 1),
+			CreatedAt: created.Add(time.Duration(i) * time.Minute),
+			Action:    models.AuditRecordRead,
+			Target:    "note",
+			IP:        "10.0.0.1",
+		}
+		if i != 0 {
+			e.PrevHash = events[i-1].Hash
+		}
+		e.Hash = e.ComputeHash(testKey)
+		events = append(events, e)
+	}
+
+	return events
+}
+
+// purge removes the first n events of the chain of the user and leaves a watermark.
+func (l *testLog) purge(userID uint64, n uint64) {
+	kept := make([]models.AuditEvent, 0, len(l.events))
+	var last models.AuditEvent
+	for _, e := range l.events {
+		if e.UserID == userID && e.Seq <= n {
+			last = e
+			continue
+		}
+		kept = append(kept, e)
+	}
+	l.events = kept
+
+	w := models.AuditWatermark{UserID: userID, Seq: n + 1, PrevHash: last.Hash}
+	w.Hash = w.ComputeHash(testKey)
+	l.watermarks = append(l.watermarks, w)
+}
+
+func TestComputeHash(t *testing.T) {
+	e := chain(1, 1)[0]
+	hash := e.ComputeHash(testKey)
+	if hash != e.ComputeHash(testKey) || len(hash) != 64 {
+		t.Fatalf("ComputeHash: a stable hex sha-256 is expected, got %q", hash)
+	}
+	if e.ComputeHash([]byte("another key")) == hash {
+		t.Error("ComputeHash: another key is expected to change the hash")
+	}
+
+	changes := map[string]func(e *models.AuditEvent){
+		"user":       func(e *models.AuditEvent) { e.UserID++ },
+		"seq":        func(e *models.AuditEvent) { e.Seq++ },
+		"time":       func(e *models.AuditEvent) { e.CreatedAt = e.CreatedAt.Add(time.Microsecond) },
+		"action":     func(e *models.AuditEvent) { e.Action = models.AuditRecordWrite },
+		"target":     func(e *models.AuditEvent) { e.Target = "card" },
+		"record":     func(e *models.AuditEvent) { e.RecordID = 7 },
+		"count":      func(e *models.AuditEvent) { e.Count = 3 },
+		"ip":         func(e *models.AuditEvent) { e.IP = "10.0.0.2" },
+		"user agent": func(e *models.AuditEvent) { e.UserAgent = "curl" },
+		"prev hash":  func(e *models.AuditEvent) { e.PrevHash = "00" },
+		// text moved from one field to the next one
+		"moved text": func(e *models.AuditEvent) { e.Target, e.IP = "note1", "0.0.0.1" },
+	}
+	for name, change := range changes {
+		changed := e
+		change(&changed)
+		if changed.ComputeHash(testKey) == hash {
+			t.Errorf("ComputeHash: a changed %s is expected to change the hash", name)
+		}
+	}
+
+	// the time zone of the stored time does not matter
+	local := e
+	local.CreatedAt = e.CreatedAt.In(time.FixedZone("UTC+3", 3*60*60))
+	if local.ComputeHash(testKey) != hash {
+		t.Error("ComputeHash: the same moment in another zone is expected to hash the same")
+	}
+
+	w := models.AuditWatermark{UserID: e.UserID, Seq: e.Seq, PrevHash: e.PrevHash}
+	if w.ComputeHash(testKey) == hash {
+		t.Error("ComputeHash: a watermark is expected to hash unlike an event")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(l *testLog)
+		problems []string
+		first    uint64
+	}{
+		{name: "intact", tamper: func(l *testLog) {}, first: 1},
+		{
+			name:     "changed event",
+			tamper:   func(l *testLog) { l.events[2].Target = "card" },
+			problems: []string{"user 1, event 3: event has been changed"},
+			first:    1,
+		},
+		{
+			name: "rehashed event",
+			tamper: func(l *testLog) {
+				l.events[2].Target = "card"
+				l.events[2].Hash = l.events[2].ComputeHash(testKey)
+			},
+			problems: []string{"user 1, event 4: previous event has been changed"},
+			first:    1,
+		},
+		{
+			name:     "removed event",
+			tamper:   func(l *testLog) { l.events = append(l.events[:2:2], l.events[3:]...) },
+			problems: []string{"user 1, event 4: events missing before it: 1"},
+			first:    1,
+		},
+		{
+			name: "reordered events",
+			tamper: func(l *testLog) {
+				l.events[1], l.events[2] = l.events[2], l.events[1]
+			},
+			problems: []string{
+				"user 1, event 3: events missing before it: 1",
+				"user 1, event 2: event is out of order after event 3",
+				"user 1, event 4: events missing before it: 1",
+			},
+			first: 1,
+		},
+		{
+			name:     "removed start",
+			tamper:   func(l *testLog) { l.events = l.events[2:] },
+			problems: []string{"user 1, event 3: events missing before it: 2"},
+			first:    3,
+		},
+		{name: "purged start", tamper: func(l *testLog) { l.purge(1, 2) }, first: 3},
+		{
+			name: "removed after purge",
+			tamper: func(l *testLog) {
+				l.purge(1, 2)
+				l.events = l.events[1:]
+			},
+			problems: []string{"user 1, event 4: events missing before it: 1"},
+			first:    4,
+		},
+		{
+			name: "forged watermark",
+			tamper: func(l *testLog) {
+				l.purge(1, 2)
+				l.events = l.events[1:]
+				l.watermarks[0].Seq, l.watermarks[0].PrevHash = 4, l.events[0].PrevHash
+			},
+			problems: []string{
+				"user 1: watermark has been changed",
+				"user 1, event 4: events missing before it: 3",
+			},
+			first: 4,
+		},
+		{
+			name:     "removed chain",
+			tamper:   func(l *testLog) { l.purge(1, 2); l.events = l.events[3:] },
+			problems: []string{"user 1: every event after the watermark 3 has been removed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &testLog{events: append(chain(1, 5), chain(2, 2)...)}
+			tt.tamper(l)
+
+			report, err := Verify(l, testKey)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			if len(report.Problems) != len(tt.problems) {
+				t.Fatalf("Verify: problems %v are expected, got %v", tt.problems, report.Problems)
+			}
+			for i, problem := range report.Problems {
+				if !errors.Is(problem, ErrBrokenChain) || !strings.HasSuffix(problem.Error(), tt.problems[i]) {
+					t.Errorf("Verify: problem %q is expected, got %v", tt.problems[i], problem)
+				}
+			}
+
+			// the chain of the second user is never touched
+			last := report.Chains[len(report.Chains)-1]
+			if last.UserID != 2 || last.First != 1 || last.Events != 2 || last.Head != l.events[len(l.events)-1].Hash {
+				t.Errorf("Verify: an intact chain of user 2 is expected, got %+v", last)
+			}
+			if tt.first != 0 && (report.Chains[0].UserID != 1 || report.Chains[0].First != tt.first) {
+				t.Errorf("Verify: the chain of user 1 is expected from event %d, got %+v", tt.first, report.Chains[0])
+			}
+		})
+	}
+}
+
+func TestVerifyKey(t *testing.T) {
+	l := &testLog{events: chain(1, 3)}
+	l.purge(1, 1)
+
+	report, err := Verify(l, []byte("another key"))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	// the watermark and every event fail, without the watermark the purged start is missing too
+	if len(report.Problems) != 4 {
+		t.Errorf("Verify with another key: 4 problems are expected, got %v", report.Problems)
+	}
+}
+
+func TestVerifyBatches(t *testing.T) {
+	l := &testLog{events: append(chain(1, scanBatch+1), chain(2, scanBatch-1)...)}
+
+	report, err := Verify(l, testKey)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(report.Problems) != 0 || len(report.Chains) != 2 ||
+		report.Chains[0].Events != scanBatch+1 || report.Chains[1].Events != scanBatch-1 {
+		t.Errorf("Verify across batches: 2 intact chains are expected, got %d chains, %v", len(report.Chains), report.Problems)
+	}
+}
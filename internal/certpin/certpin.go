@@ -0,0 +1,28 @@
+// Package certpin computes public key pins of certificates in the `sha256/<base64>` form,
+// a pin stays the same while a certificate is renewed with the same key.
+package certpin
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+)
+
+const prefix = "sha256/"
+
+// Of is the pin of the certificate public key.
+func Of(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return prefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Matches checks the certificate against a pin, the sha256/ prefix of the pin is optional.
+func Matches(cert *x509.Certificate, pin string) bool {
+	pin = strings.TrimSpace(pin)
+	if !strings.HasPrefix(pin, prefix) {
+		pin = prefix + pin
+	}
+
+	return Of(cert) == pin
+}
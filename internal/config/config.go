@@ -6,31 +6,65 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"dario.cat/mergo"
 	"github.com/caarlos0/env/v6"
 )
 
 type ServerConfig struct {
-	RunAddr     string `json:"server_address" env:"SERVER_ADDRESS"`
-	DatabaseDSN string `json:"database_dsn" env:"DATABASE_DSN"`
-	Config      string `json:"-" env:"CONFIG"`
-	TLSCertPath string `json:"tls_cert_path" env:"TLS_CERT_PATH"`
-	TLSKeyPath  string `json:"tls_key_path" env:"TLS_KEY_PATH"`
-	LogLevel    string `env:"LOG_LEVEL" envDefault:"debug"`
-	EnableHTTPS bool   `json:"enable_https" env:"ENABLE_HTTPS"`
+	RunAddr        string        `json:"server_address" env:"SERVER_ADDRESS"`
+	MetricsAddr    string        `json:"metrics_address" env:"METRICS_ADDRESS"`
+	DatabaseDSN    string        `json:"database_dsn" env:"DATABASE_DSN"`
+	Storage        string        `json:"storage" env:"STORAGE"`
+	MasterKeyFile  string        `json:"master_key_file" env:"MASTER_KEY_FILE"`
+	MasterKey      string        `json:"-" env:"MASTER_KEY"`
+	Config         string        `json:"-" env:"CONFIG"`
+	TLSCertPath    string        `json:"tls_cert_path" env:"TLS_CERT_PATH"`
+	TLSKeyPath     string        `json:"tls_key_path" env:"TLS_KEY_PATH"`
+	TLSHosts       string        `json:"tls_hosts" env:"TLS_HOSTS"`
+	CACertPath     string        `json:"ca_cert_path" env:"CA_CERT_PATH"`
+	CAKeyPath      string        `json:"ca_key_path" env:"CA_KEY_PATH"`
+	TrustedProxies string        `json:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	LogLevel       string        `env:"LOG_LEVEL" envDefault:"debug"`
+	TrashRetention time.Duration `json:"trash_retention" env:"TRASH_RETENTION"`
+	AuditRetention time.Duration `json:"audit_retention" env:"AUDIT_RETENTION"`
+	IdempotencyTTL time.Duration `json:"idempotency_ttl" env:"IDEMPOTENCY_TTL"`
+	EnableHTTPS    bool          `json:"enable_https" env:"ENABLE_HTTPS"`
+	EnableMTLS     bool          `json:"enable_mtls" env:"ENABLE_MTLS"`
 }
 
+const (
+	defaultTrashRetention = 30 * 24 * time.Hour
+	defaultAuditRetention = 365 * 24 * time.Hour
+	// defaultIdempotencyTTL covers clients which replay offline operations after a month away.
+	defaultIdempotencyTTL = 30 * 24 * time.Hour
+)
+
 var config ServerConfig
 
 func ParseFlags() (*ServerConfig, error) {
 	flag.StringVar(&config.RunAddr, "a", ":8080", "address and port to run server")
+	flag.StringVar(&config.MetricsAddr, "metrics-addr", "", "address and port to serve prometheus metrics, disabled when empty")
 	flag.BoolVar(&config.EnableHTTPS, "s", true, "enable https")
 	flag.StringVar(&config.DatabaseDSN, "d", "", "Data Source Name (DSN)")
+	flag.StringVar(&config.Storage, "b", "postgres", "storage backend: postgres, sqlite or memory")
+	flag.StringVar(&config.MasterKeyFile, "m", "./keys/master.key", "path to master key file, created when missing")
 	flag.StringVar(&config.Config, "c", "", "Config json file path")
 	flag.StringVar(&config.TLSCertPath, "l", "./certs/cert.pem", "path to tls cert file")
 	flag.StringVar(&config.TLSKeyPath, "k", "./certs/private.pem", "path to tls key file")
+	flag.StringVar(&config.TLSHosts, "n", "localhost,127.0.0.1,::1",
+		"comma separated DNS names and IP addresses of the self-signed tls cert")
+	flag.BoolVar(&config.EnableMTLS, "e", false, "enroll devices with client certificates and bind tokens to them")
+	flag.StringVar(&config.CACertPath, "ca-cert", "./certs/ca.pem", "path to device CA cert file")
+	flag.StringVar(&config.CAKeyPath, "ca-key", "./certs/ca-key.pem", "path to device CA key file")
 	flag.StringVar(&config.LogLevel, "g", "", "log level")
+	flag.DurationVar(&config.TrashRetention, "t", defaultTrashRetention, "how long deleted records are kept in trash")
+	flag.DurationVar(&config.AuditRetention, "audit-retention", defaultAuditRetention, "how long audit events are kept, forever when zero")
+	flag.DurationVar(&config.IdempotencyTTL, "idempotency-ttl", defaultIdempotencyTTL,
+		"how long idempotency keys of applied operations are kept, the default is used when not positive")
+	flag.StringVar(&config.TrustedProxies, "trusted-proxies", "",
+		"comma separated addresses or CIDRs of proxies whose X-Forwarded-For is trusted, none when empty")
 	flag.Parse()
 
 	if err := env.Parse(&config); err != nil {
@@ -53,5 +87,10 @@ func ParseFlags() (*ServerConfig, error) {
 		}
 	}
 
+	// keys are purged unconditionally, otherwise the table would grow forever
+	if config.IdempotencyTTL <= 0 {
+		config.IdempotencyTTL = defaultIdempotencyTTL
+	}
+
 	return &config, nil
 }
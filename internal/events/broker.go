@@ -0,0 +1,74 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// subscriberBuffer is the number of events a subscriber may lag behind before it is dropped.
+const subscriberBuffer = 64
+
+// Publisher receives events of committed changes.
+type Publisher interface {
+	Publish(e models.Event)
+}
+
+// Broker fans events out to the subscribers of the event user within the process.
+// A subscriber which does not keep up is dropped by closing its channel,
+// it is expected to resync and subscribe again.
+type Broker struct {
+	subs map[uint64]map[chan models.Event]struct{}
+	mu   sync.Mutex
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[uint64]map[chan models.Event]struct{})}
+}
+
+// Publish delivers the event without blocking the publisher.
+func (b *Broker) Publish(e models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[e.UserID] {
+		select {
+		case ch <- e:
+		default:
+			b.remove(e.UserID, ch)
+		}
+	}
+}
+
+// Subscribe returns events of the user until cancel is called or the subscriber is dropped.
+func (b *Broker) Subscribe(userID uint64) (<-chan models.Event, func()) {
+	ch := make(chan models.Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan models.Event]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		b.remove(userID, ch)
+	}
+}
+
+// remove closes the subscriber channel, must be called with mu held.
+func (b *Broker) remove(userID uint64, ch chan models.Event) {
+	subs := b.subs[userID]
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(b.subs, userID)
+	}
+}
@@ -0,0 +1,287 @@
+// Package keyring implements envelope encryption of user data: data is sealed with
+// a per-user data key, and data keys are wrapped with a versioned master key.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeySize is the size of master and data keys, AES-256 is used for both.
+const KeySize = 32
+
+// SealedPrefix marks sealed values bound to their additional data. Values of legacyPrefix
+// were sealed without it, values with neither prefix were stored before encryption.
+const (
+	SealedPrefix = "enc:v2:"
+	legacyPrefix = "enc:v1:"
+)
+
+var (
+	ErrNoMasterKey      = errors.New("no master key")
+	ErrUnknownVersion   = errors.New("unknown master key version")
+	ErrBadMasterKey     = errors.New("malformed master key")
+	ErrDecryptionFailed = errors.New("decryption failed")
+	ErrNotSealed        = errors.New("value is not sealed")
+)
+
+// Keyring holds master keys by version. The latest version wraps new data keys,
+// the older ones only unwrap data keys which are not rewrapped yet.
+type Keyring struct {
+	keys    map[uint32]cipher.AEAD
+	current uint32
+}
+
+// Parse reads master keys in the `version:base64` form separated by commas or new lines.
+// A single key may omit the version, it is version 1. Lines starting with # are comments.
+func Parse(s string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[uint32]cipher.AEAD)}
+
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		for _, entry := range strings.Split(line, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			if err := k.add(entry); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(k.keys) == 0 {
+		return nil, ErrNoMasterKey
+	}
+
+	return k, nil
+}
+
+func (k *Keyring) add(entry string) error {
+	version := uint64(1)
+	encoded := entry
+	if v, key, ok := strings.Cut(entry, ":"); ok {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || n == 0 {
+			return fmt.Errorf("%w: bad version %q", ErrBadMasterKey, v)
+		}
+		version, encoded = n, key
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: version %d is not base64: %v", ErrBadMasterKey, version, err)
+	}
+	if len(key) != KeySize {
+		return fmt.Errorf("%w: version %d must be %d bytes", ErrBadMasterKey, version, KeySize)
+	}
+	if _, ok := k.keys[uint32(version)]; ok {
+		return fmt.Errorf("%w: duplicate version %d", ErrBadMasterKey, version)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	k.keys[uint32(version)] = aead
+	if uint32(version) > k.current {
+		k.current = uint32(version)
+	}
+
+	return nil
+}
+
+// Load reads master keys from env when it is set, otherwise from file. A missing file
+// is an error wrapping os.ErrNotExist, Create makes the file of a fresh installation.
+func Load(file string, env string) (*Keyring, error) {
+	if env != "" {
+		return Parse(env)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading master key file: %w", err)
+	}
+
+	return Parse(string(data))
+}
+
+// Create writes file with a new master key, an existing file is never replaced.
+func Create(file string) (*Keyring, error) {
+	key, err := NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	content := "1:" + base64.StdEncoding.EncodeToString(key) + "\n"
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, fmt.Errorf("error creating master key dir: %w", err)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error creating master key file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		return nil, fmt.Errorf("error writing master key file: %w", err)
+	}
+
+	return Parse(content)
+}
+
+// Current is the version of the master key which wraps new data keys.
+func (k *Keyring) Current() uint32 {
+	return k.current
+}
+
+// Versions lists the loaded master key versions in ascending order.
+func (k *Keyring) Versions() []uint32 {
+	res := make([]uint32, 0, len(k.keys))
+	for v := range k.keys {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+
+	return res
+}
+
+// Wrap encrypts a data key with the current master key.
+func (k *Keyring) Wrap(dataKey []byte) ([]byte, uint32, error) {
+	wrapped, err := seal(k.keys[k.current], dataKey, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return wrapped, k.current, nil
+}
+
+// Unwrap decrypts a data key wrapped with the master key of version.
+func (k *Keyring) Unwrap(wrapped []byte, version uint32) ([]byte, error) {
+	aead, ok := k.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return open(aead, wrapped, nil)
+}
+
+// NewDataKey generates a random key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("error generating key: %w", err)
+	}
+
+	return key, nil
+}
+
+// Cipher seals and opens values with a data key.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(dataKey []byte) (*Cipher, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts a text value into a text form which is safe to store in a text column.
+// The value opens only with the same aad, which binds it to its owner.
+func (c *Cipher) Seal(plaintext string, aad []byte) (string, error) {
+	sealed, err := seal(c.aead, []byte(plaintext), aad)
+	if err != nil {
+		return "", err
+	}
+
+	return SealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value made by Seal with the same aad. Legacy values sealed without aad
+// are opened too, values which are not sealed at all are ErrNotSealed.
+func (c *Cipher) Open(value string, aad []byte) (string, error) {
+	encoded, ok := strings.CutPrefix(value, SealedPrefix)
+	if !ok {
+		if encoded, ok = strings.CutPrefix(value, legacyPrefix); !ok {
+			return "", ErrNotSealed
+		}
+		aad = nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+
+	plaintext, err := open(c.aead, sealed, aad)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether value is made by Seal. Legacy values are not.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, SealedPrefix)
+}
+
+// IsLegacy reports whether value is sealed without aad.
+func IsLegacy(value string) bool {
+	return strings.HasPrefix(value, legacyPrefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gcm: %w", err)
+	}
+
+	return aead, nil
+}
+
+// seal prepends a random nonce to the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,210 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, KeySize))
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		versions []uint32
+		current  uint32
+		err      error
+	}{
+		{name: "bare key", input: testKey(1), versions: []uint32{1}, current: 1},
+		{
+			name:     "lines and commas",
+			input:    "# rotated in may\n1:" + testKey(1) + "\n\n3:" + testKey(3) + ", 2:" + testKey(2) + "\n",
+			versions: []uint32{1, 2, 3},
+			current:  3,
+		},
+		{name: "empty", input: "# nothing\n", err: ErrNoMasterKey},
+		{name: "zero version", input: "0:" + testKey(1), err: ErrBadMasterKey},
+		{name: "bad version", input: "v1:" + testKey(1), err: ErrBadMasterKey},
+		{name: "not base64", input: "1:not base64!", err: ErrBadMasterKey},
+		{name: "short key", input: "1:" + base64.StdEncoding.EncodeToString([]byte("short")), err: ErrBadMasterKey},
+		{name: "duplicate version", input: "1:" + testKey(1) + ",1:" + testKey(2), err: ErrBadMasterKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := Parse(tt.input)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Parse: error %v is expected, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			if !reflect.DeepEqual(k.Versions(), tt.versions) || k.Current() != tt.current {
+				t.Errorf("Parse: versions %v, current %d, want %v, %d", k.Versions(), k.Current(), tt.versions, tt.current)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys", "master.key")
+
+	if _, err := Load(file, ""); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Load of a missing file: os.ErrNotExist is expected, got %v", err)
+	}
+
+	created, err := Create(file)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if fi, err := os.Stat(file); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Create: a file with mode 0600 is expected, got %v, %v", fi, err)
+	}
+	if _, err := Create(file); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Create: an existing file is expected to be kept, got %v", err)
+	}
+
+	loaded, err := Load(file, "")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	wrapped, version, err := created.Wrap([]byte("data key"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if key, err := loaded.Unwrap(wrapped, version); err != nil || string(key) != "data key" {
+		t.Errorf("Unwrap with the loaded file: got %q, %v", key, err)
+	}
+
+	// the environment takes precedence over the file
+	fromEnv, err := Load(file, "7:"+testKey(7))
+	if err != nil {
+		t.Fatalf("Load from env: %v", err)
+	}
+	if fromEnv.Current() != 7 {
+		t.Errorf("Load from env: version 7 is expected, got %d", fromEnv.Current())
+	}
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	old, err := Parse("1:" + testKey(1))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	rotated, err := Parse("1:" + testKey(1) + "\n2:" + testKey(2))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey: %v", err)
+	}
+
+	wrapped, version, err := old.Wrap(dataKey)
+	if err != nil || version != 1 {
+		t.Fatalf("Wrap: version %d, %v", version, err)
+	}
+
+	// a key wrapped before the rotation is unwrapped with the old version and rewrapped with the new one
+	unwrapped, err := rotated.Unwrap(wrapped, version)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("Unwrap after rotation: %v", err)
+	}
+	rewrapped, version, err := rotated.Wrap(unwrapped)
+	if err != nil || version != 2 {
+		t.Fatalf("Wrap after rotation: version %d, %v", version, err)
+	}
+	if _, err := old.Unwrap(rewrapped, version); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Unwrap of an unknown version: ErrUnknownVersion is expected, got %v", err)
+	}
+	if _, err := rotated.Unwrap(rewrapped, 1); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Unwrap with another version: ErrDecryptionFailed is expected, got %v", err)
+	}
+
+	rewrapped[len(rewrapped)-1] ^= 1
+	if _, err := rotated.Unwrap(rewrapped, 2); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Unwrap of a changed key: ErrDecryptionFailed is expected, got %v", err)
+	}
+}
+
+func TestCipher(t *testing.T) {
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey: %v", err)
+	}
+	c, err := NewCipher(dataKey)
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	aad := []byte("record:1:mail")
+
+	sealed, err := c.Seal("secret", aad)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !IsSealed(sealed) || IsLegacy(sealed) || strings.Contains(sealed, "secret") {
+		t.Fatalf("Seal: got %q", sealed)
+	}
+	if again, _ := c.Seal("secret", aad); again == sealed {
+		t.Error("Seal: a fresh nonce is expected for every value")
+	}
+
+	if plaintext, err := c.Open(sealed, aad); err != nil || plaintext != "secret" {
+		t.Errorf("Open: got %q, %v", plaintext, err)
+	}
+	if _, err := c.Open(sealed, []byte("record:1:bank")); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Open with other aad: ErrDecryptionFailed is expected, got %v", err)
+	}
+	if _, err := c.Open("secret", aad); !errors.Is(err, ErrNotSealed) {
+		t.Errorf("Open of plaintext: ErrNotSealed is expected, got %v", err)
+	}
+
+	other, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey: %v", err)
+	}
+	oc, err := NewCipher(other)
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	if _, err := oc.Open(sealed, aad); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Open with another key: ErrDecryptionFailed is expected, got %v", err)
+	}
+}
+
+func TestCipherLegacy(t *testing.T) {
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey: %v", err)
+	}
+	c, err := NewCipher(dataKey)
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+
+	// values of the first format were sealed without aad
+	raw, err := seal(c.aead, []byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	legacy := legacyPrefix + base64.StdEncoding.EncodeToString(raw)
+	if !IsLegacy(legacy) || IsSealed(legacy) {
+		t.Fatalf("IsLegacy: got %t, IsSealed %t", IsLegacy(legacy), IsSealed(legacy))
+	}
+
+	if plaintext, err := c.Open(legacy, []byte("record:1:mail")); err != nil || plaintext != "secret" {
+		t.Errorf("Open of a legacy value: got %q, %v", plaintext, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Package metrics keeps the Prometheus metrics of the server.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rawen554/goph-keeper/internal/events"
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+const namespace = "gophkeeper"
+
+// Reasons of auth failures.
+const (
+	ReasonMissingToken   = "missing_token"
+	ReasonInvalidToken   = "invalid_token"
+	ReasonMissingCert    = "missing_certificate"
+	ReasonBadCredentials = "bad_credentials"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	requests = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of API requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of API requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	authFailures = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Number of rejected logins and requests by reason.",
+	}, []string{"reason"})
+
+	syncLag = promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_lag_seconds",
+		Help:      "Time from a committed change to publishing its event to clients of this replica.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// ObserveRequest counts the served request, route is the route pattern, not the requested path.
+func ObserveRequest(route string, method string, code int, duration time.Duration) {
+	requests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	requestDuration.WithLabelValues(route, method).Observe(duration.Seconds())
+}
+
+// AuthFailed counts a rejected login or request.
+func AuthFailed(reason string) {
+	authFailures.WithLabelValues(reason).Inc()
+}
+
+// lagPublisher measures the sync lag of events passed to the next publisher.
+type lagPublisher struct {
+	next events.Publisher
+}
+
+// NewLagPublisher observes how long ago the changes of events were committed.
+func NewLagPublisher(next events.Publisher) events.Publisher {
+	return &lagPublisher{next: next}
+}
+
+func (p *lagPublisher) Publish(e models.Event) {
+	if !e.Revision.IsZero() {
+		syncLag.Observe(time.Since(e.Revision).Seconds())
+	}
+
+	p.next.Publish(e)
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rawen554/goph-keeper/internal/models"
+)
+
+// recordStatsTTL is how long record counts are reused, they take a scan of all records,
+// while the pool stats are read on every scrape.
+const recordStatsTTL = time.Minute
+
+// StoreStats is the part of the store the metrics are read from.
+type StoreStats interface {
+	RecordStats() ([]models.RecordStats, error)
+	DBStats() (stats sql.DBStats, ok bool)
+}
+
+// recordTypes are always reported, so a type without records is a zero instead of a gap.
+var recordTypes = []models.DataType{models.PASS, models.TEXT, models.BIN, models.CARD, models.OTP, models.SSHKEY}
+
+var (
+	recordsDesc = prometheus.NewDesc(namespace+"_records",
+		"Number of records not in the trash by type.", []string{"type"}, nil)
+	blobBytesDesc = prometheus.NewDesc(namespace+"_blob_bytes",
+		"Stored size of binary records not in the trash.", nil, nil)
+
+	dbMaxOpenDesc = prometheus.NewDesc(namespace+"_db_max_open_connections",
+		"Maximum number of open connections to the database.", nil, nil)
+	dbOpenDesc = prometheus.NewDesc(namespace+"_db_open_connections",
+		"Number of established connections to the database.", nil, nil)
+	dbInUseDesc = prometheus.NewDesc(namespace+"_db_in_use_connections",
+		"Number of connections currently in use.", nil, nil)
+	dbIdleDesc = prometheus.NewDesc(namespace+"_db_idle_connections",
+		"Number of idle connections.", nil, nil)
+	dbWaitCountDesc = prometheus.NewDesc(namespace+"_db_wait_count_total",
+		"Number of connections waited for.", nil, nil)
+	dbWaitDurationDesc = prometheus.NewDesc(namespace+"_db_wait_duration_seconds_total",
+		"Time blocked waiting for a new connection.", nil, nil)
+	dbMaxIdleClosedDesc = prometheus.NewDesc(namespace+"_db_max_idle_closed_total",
+		"Number of connections closed due to the idle connections limit.", nil, nil)
+	dbMaxIdleTimeClosedDesc = prometheus.NewDesc(namespace+"_db_max_idle_time_closed_total",
+		"Number of connections closed due to the idle time limit.", nil, nil)
+	dbMaxLifetimeClosedDesc = prometheus.NewDesc(namespace+"_db_max_lifetime_closed_total",
+		"Number of connections closed due to the lifetime limit.", nil, nil)
+)
+
+type storeCollector struct {
+	store   StoreStats
+	stats   []models.RecordStats
+	statsAt time.Time
+	mu      sync.Mutex
+}
+
+// RegisterStore reports records and the connection pool of the store.
+func RegisterStore(store StoreStats) error {
+	return registry.Register(&storeCollector{store: store})
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		recordsDesc, blobBytesDesc,
+		dbMaxOpenDesc, dbOpenDesc, dbInUseDesc, dbIdleDesc, dbWaitCountDesc, dbWaitDurationDesc,
+		dbMaxIdleClosedDesc, dbMaxIdleTimeClosedDesc, dbMaxLifetimeClosedDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectRecords(ch)
+
+	stats, ok := c.store.DBStats()
+	if !ok {
+		return
+	}
+
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+
+	gauge(dbMaxOpenDesc, float64(stats.MaxOpenConnections))
+	gauge(dbOpenDesc, float64(stats.OpenConnections))
+	gauge(dbInUseDesc, float64(stats.InUse))
+	gauge(dbIdleDesc, float64(stats.Idle))
+	counter(dbWaitCountDesc, float64(stats.WaitCount))
+	counter(dbWaitDurationDesc, stats.WaitDuration.Seconds())
+	counter(dbMaxIdleClosedDesc, float64(stats.MaxIdleClosed))
+	counter(dbMaxIdleTimeClosedDesc, float64(stats.MaxIdleTimeClosed))
+	counter(dbMaxLifetimeClosedDesc, float64(stats.MaxLifetimeClosed))
+}
+
+// recordStats returns record counts no older than recordStatsTTL. Scrapes arriving while
+// the counts are read wait for them instead of starting another scan.
+func (c *storeCollector) recordStats() ([]models.RecordStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats != nil && time.Since(c.statsAt) < recordStatsTTL {
+		return c.stats, nil
+	}
+
+	stats, err := c.store.RecordStats()
+	if err != nil {
+		return nil, err
+	}
+	c.stats, c.statsAt = stats, time.Now()
+
+	return stats, nil
+}
+
+func (c *storeCollector) collectRecords(ch chan<- prometheus.Metric) {
+	stats, err := c.recordStats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(recordsDesc, err)
+		return
+	}
+
+	counts := make(map[models.DataType]int64, len(recordTypes))
+	for _, t := range recordTypes {
+		counts[t] = 0
+	}
+
+	var blobBytes int64
+	for _, s := range stats {
+		counts[s.Type] += s.Count
+		if s.Type == models.BIN {
+			blobBytes += s.Bytes
+		}
+	}
+
+	for t, count := range counts {
+		ch <- prometheus.MustNewConstMetric(recordsDesc, prometheus.GaugeValue, float64(count), string(t))
+	}
+	ch <- prometheus.MustNewConstMetric(blobBytesDesc, prometheus.GaugeValue, float64(blobBytes))
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,12 +11,15 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/rawen554/goph-keeper/internal/metrics"
 	"go.uber.org/zap"
 )
 
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID uint64
+	// CertFingerprint binds the token to the device certificate it was issued with.
+	CertFingerprint string `json:"cnf,omitempty"`
+	UserID          uint64
 }
 
 type key int
@@ -34,12 +39,15 @@ const UserIDKey key = iota
 var ErrTokenNotValid = errors.New("token is not valid")
 var ErrNoUserInToken = errors.New("no user data in token")
 
-func BuildJWTString(userID uint64) (string, error) {
+// BuildJWTString issues a token of the user. A token with certFingerprint is accepted
+// only over connections presenting that client certificate.
+func BuildJWTString(userID uint64, certFingerprint string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
 		},
-		UserID: userID,
+		CertFingerprint: certFingerprint,
+		UserID:          userID,
 	})
 
 	tokenString, err := token.SignedString([]byte(tokenKey))
@@ -51,24 +59,49 @@ func BuildJWTString(userID uint64) (string, error) {
 }
 
 func GetUserID(tokenString string) (uint64, error) {
+	claims, err := GetClaims(tokenString)
+	if err != nil {
+		return 0, err
+	}
+
+	return claims.UserID, nil
+}
+
+func GetClaims(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims,
 		func(t *jwt.Token) (interface{}, error) {
 			return []byte(tokenKey), nil
 		})
 	if err != nil {
-		if !token.Valid {
-			return 0, ErrTokenNotValid
+		// malformed tokens are not returned at all
+		if token == nil || !token.Valid {
+			return nil, ErrTokenNotValid
 		} else {
-			return 0, errors.New("parsing error")
+			return nil, errors.New("parsing error")
 		}
 	}
 
 	if claims.UserID == 0 {
-		return 0, ErrNoUserInToken
+		return nil, ErrNoUserInToken
 	}
 
-	return claims.UserID, nil
+	return claims, nil
+}
+
+// CertFingerprint is the hex SHA-256 of a DER certificate.
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// presentsCert checks the client certificate of the connection, its chain is verified by the TLS server.
+func presentsCert(r *http.Request, fingerprint string) bool {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+
+	return CertFingerprint(r.TLS.PeerCertificates[0].Raw) == fingerprint
 }
 
 func AuthMiddleware(logger *zap.SugaredLogger) gin.HandlerFunc {
@@ -76,15 +109,17 @@ func AuthMiddleware(logger *zap.SugaredLogger) gin.HandlerFunc {
 		token := c.GetHeader(AuthorizationHeader)
 		if token == "" {
 			logger.Errorf("Error reading header[%v]: %v", AuthorizationHeader, token)
+			metrics.AuthFailed(metrics.ReasonMissingToken)
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 		splitToken := strings.Split(token, "Bearer ")
 		token = splitToken[1]
 
-		userID, err := GetUserID(token)
+		claims, err := GetClaims(token)
 		if err != nil {
 			if errors.Is(err, ErrNoUserInToken) || errors.Is(err, ErrTokenNotValid) {
+				metrics.AuthFailed(metrics.ReasonInvalidToken)
 				c.AbortWithStatus(http.StatusUnauthorized)
 				return
 			} else {
@@ -93,7 +128,14 @@ func AuthMiddleware(logger *zap.SugaredLogger) gin.HandlerFunc {
 			}
 		}
 
-		c.Set(fmt.Sprint(UserIDKey), userID)
+		if claims.CertFingerprint != "" && !presentsCert(c.Request, claims.CertFingerprint) {
+			logger.Errorf("token of user %d is presented without its device certificate", claims.UserID)
+			metrics.AuthFailed(metrics.ReasonMissingCert)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set(fmt.Sprint(UserIDKey), claims.UserID)
 		c.Next()
 	}
 }
@@ -44,6 +44,14 @@ func (c *compressWriter) WriteHeader(statusCode int) {
 	c.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Flush досылает сжатые данные, чтобы потоковые ответы доходили до клиента сразу.
+func (c *compressWriter) Flush() {
+	if err := c.zw.Flush(); err != nil {
+		return
+	}
+	c.ResponseWriter.Flush()
+}
+
 // Close закрывает gzip.Writer и досылает все данные из буфера.
 func (c *compressWriter) Close() error {
 	if err := c.zw.Close(); err != nil {
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rawen554/goph-keeper/internal/metrics"
+)
+
+// unmatchedRoute labels requests no route matched, so scanned paths do not create series.
+const unmatchedRoute = "unmatched"
+
+// Metrics counts requests and observes their duration per route.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		metrics.ObserveRequest(route, c.Request.Method, c.Writer.Status(), time.Since(t))
+	}
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditLogin         AuditAction = "login"
+	AuditLoginFailed   AuditAction = "login.failed"
+	AuditRecordRead    AuditAction = "record.read"
+	AuditRecordsList   AuditAction = "records.list"
+	AuditRecordWrite   AuditAction = "record.write"
+	AuditRecordDelete  AuditAction = "record.delete"
+	AuditRecordRestore AuditAction = "record.restore"
+	AuditTrashPurge    AuditAction = "trash.purge"
+	AuditPasswordReset AuditAction = "password.reset"
+)
+
+// Valid reports whether a is a known action.
+func (a AuditAction) Valid() bool {
+	switch a {
+	case AuditLogin, AuditLoginFailed, AuditRecordRead, AuditRecordsList,
+		AuditRecordWrite, AuditRecordDelete, AuditRecordRestore, AuditTrashPurge, AuditPasswordReset:
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// AuditEvent is an entry of the append-only audit log. Events of a user form a chain:
+// Seq numbers them from 1 and Hash covers the event together with PrevHash, the hash
+// of the previous event, so a changed, removed or reordered event breaks the chain.
+// Events older than the retention are removed from the start of chains, see AuditWatermark.
+type AuditEvent struct {
+	CreatedAt time.Time   `gorm:"not null" json:"created_at"`
+	Action    AuditAction `gorm:"not null" json:"action"`
+	Target    string      `json:"target,omitempty"`
+	IP        string      `json:"ip,omitempty"`
+	UserAgent string      `json:"user_agent,omitempty"`
+	PrevHash  string      `gorm:"not null" json:"prev_hash"`
+	Hash      string      `gorm:"not null" json:"hash"`
+	ID        uint64      `gorm:"primaryKey" json:"-"`
+	UserID    uint64      `gorm:"not null;uniqueIndex:idx_audit_events_user_seq,priority:1" json:"-"`
+	Seq       uint64      `gorm:"not null;uniqueIndex:idx_audit_events_user_seq,priority:2" json:"seq"`
+	RecordID  uint64      `json:"record_id,omitempty"`
+	// Count is the number of failed logins summed up by a login.failed event.
+	Count uint64 `gorm:"not null;default:0" json:"count,omitempty"`
+}
+
+// ComputeHash is the HMAC-SHA-256 of the event fields and the previous hash under the audit
+// key, so chains cannot be rebuilt without the key. Fields are length-prefixed, so text moved
+// from one field to another changes the hash.
+func (e *AuditEvent) ComputeHash(key []byte) string {
+	h := hmac.New(sha256.New, key)
+	for _, f := range []string{
+		strconv.FormatUint(e.UserID, 10),
+		strconv.FormatUint(e.Seq, 10),
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		string(e.Action),
+		e.Target,
+		strconv.FormatUint(e.RecordID, 10),
+		strconv.FormatUint(e.Count, 10),
+		e.IP,
+		e.UserAgent,
+		e.PrevHash,
+	} {
+		fmt.Fprintf(h, "%d:%s", len(f), f)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditWatermark marks where the chain of a user starts after a purge: Seq is the first event
+// kept and PrevHash the hash of the last one purged. It is hashed with the audit key, so events
+// removed from the start of a chain without the key are told apart from purged ones.
+type AuditWatermark struct {
+	PrevHash string `gorm:"not null"`
+	Hash     string `gorm:"not null"`
+	UserID   uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Seq      uint64 `gorm:"not null"`
+}
+
+// ComputeHash is the HMAC-SHA-256 of the watermark under the audit key. The fields are
+// prefixed with a tag of their own, so an event never hashes like a watermark.
+func (w *AuditWatermark) ComputeHash(key []byte) string {
+	h := hmac.New(sha256.New, key)
+	for _, f := range []string{
+		"watermark",
+		strconv.FormatUint(w.UserID, 10),
+		strconv.FormatUint(w.Seq, 10),
+		w.PrevHash,
+	} {
+		fmt.Fprintf(h, "%d:%s", len(f), f)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditQuery selects a page of the user audit log, the latest events first.
+// Zero values disable the corresponding filter.
+type AuditQuery struct {
+	Since   time.Time
+	Until   time.Time
+	Target  string
+	Actions []AuditAction
+	// Before continues the listing from the event with the Seq, exclusive.
+	Before uint64
+	Limit  int
+}
+
+// AuditPage is a page of the audit log. NextBefore is zero on the last page.
+type AuditPage struct {
+	Events     []AuditEvent `json:"events"`
+	NextBefore uint64       `json:"next_before,omitempty"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// MaxIdempotencyKeyLen limits keys of batch operations.
+const MaxIdempotencyKeyLen = 128
+
+type BatchOp string
+
+const (
+	BatchUpsert BatchOp = "upsert"
+	BatchDelete BatchOp = "delete"
+)
+
+type BatchMode string
+
+const (
+	// BatchAtomic applies either every operation of a batch or none of them.
+	BatchAtomic BatchMode = "atomic"
+	// BatchBestEffort applies every operation it can and reports the rest.
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+type BatchOperation struct {
+	Record *DataRecordRequest `json:"record,omitempty"`
+	Op     BatchOp            `json:"op"`
+	Name   string             `json:"name,omitempty"`
+	// IdempotencyKey makes a retried operation a no-op once it has been applied.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// IfChecksum applies an upsert only while the stored record has the checksum, so
+	// concurrent read-modify-write cycles of a record do not overwrite each other.
+	IfChecksum string `json:"if_checksum,omitempty"`
+	FolderID   uint64 `json:"folder_id,omitempty"`
+}
+
+type BatchRequest struct {
+	Mode       BatchMode        `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchItemResult struct {
+	Record *DataRecord `json:"record,omitempty"`
+	Name   string      `json:"name"`
+	Error  string      `json:"error,omitempty"`
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	// Replayed is set when the operation had already been applied with the same idempotency key.
+	Replayed bool `json:"replayed,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchItemResult `json:"results"`
+	Applied bool              `json:"applied"`
+}
+
+// AppliedOperation remembers the idempotency key of an applied batch operation.
+type AppliedOperation struct {
+	CreatedAt time.Time `gorm:"not null;default:now();index"`
+	Key       string    `gorm:"primaryKey;size:128"`
+	UserID    uint64    `gorm:"primaryKey;autoIncrement:false"`
+}
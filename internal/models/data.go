@@ -2,9 +2,13 @@ package models
 
 import (
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type DataType string
@@ -14,6 +18,17 @@ const (
 	TEXT DataType = "TEXT"
 	BIN  DataType = "BIN"
 	CARD DataType = "CARD"
+	OTP  DataType = "OTP"
+	// SSHKEY holds an SSH key pair, see SSHKey.
+	SSHKEY DataType = "SSHKEY"
+)
+
+// Well-known metadata keys. Metadata is never encrypted, so secrets must not be put here.
+const (
+	MetaURL    = "url"
+	MetaNotes  = "notes"
+	MetaFolder = "folder"
+	MetaTags   = "tags"
 )
 
 func (s *DataType) Scan(value interface{}) error {
@@ -30,23 +45,86 @@ func (s DataType) Value() (driver.Value, error) {
 	return string(s), nil
 }
 
+// Metadata holds arbitrary text attributes of a record: site URL, notes, folder and so on.
+type Metadata map[string]string
+
+func (m *Metadata) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return errors.New(fmt.Sprint("Failed to unmarshal Metadata value: ", value))
+	}
+
+	if len(raw) == 0 {
+		*m = nil
+		return nil
+	}
+
+	return json.Unmarshal(raw, m)
+}
+
+// HasTag checks the comma separated tags.
+func (m Metadata) HasTag(tag string) bool {
+	for _, t := range strings.Split(m[MetaTags], ",") {
+		if strings.TrimSpace(t) == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling metadata: %w", err)
+	}
+
+	return string(b), nil
+}
+
 type DataRecord struct {
-	UploadedAt time.Time `gorm:"default:now()" json:"uploaded_at"`
-	Type       DataType  `sql:"type:data_type" gorm:"not null;" json:"type"`
-	Checksum   string    `gorm:"checksum" json:"checksum"`
-	Data       string    `gorm:"data" json:"data"`
-	FilePath   string    `gorm:"filepath" json:"filepath"`
-	Name       string    `gorm:"uniqueIndex;not null;" json:"name"`
-	User       User      `gorm:"not null;" json:"-"`
-	ID         uint64    `gorm:"primaryKey" json:"id"`
-	UserID     uint64    `json:"-"`
-	Blocked    bool      `gorm:"blocked" json:"blocked"`
+	UploadedAt time.Time      `gorm:"default:now()" json:"uploaded_at"`
+	UpdatedAt  time.Time      `gorm:"not null;default:now();index" json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	Metadata   Metadata       `gorm:"type:jsonb;default:'{}'" json:"metadata,omitempty"`
+	Type       DataType       `sql:"type:data_type" gorm:"not null;" json:"type"`
+	Checksum   string         `gorm:"checksum" json:"checksum"`
+	Data       string         `gorm:"data" json:"data"`
+	FilePath   string         `gorm:"filepath" json:"filepath"`
+	Name       string         `gorm:"uniqueIndex:idx_records_user_folder_name_alive,priority:3,where:deleted_at IS NULL;not null;" json:"name"`
+	User       User           `gorm:"not null;" json:"-"`
+	ID         uint64         `gorm:"primaryKey" json:"id"`
+	UserID     uint64         `gorm:"uniqueIndex:idx_records_user_folder_name_alive,priority:1" json:"-"`
+	FolderID   uint64         `gorm:"not null;default:0;uniqueIndex:idx_records_user_folder_name_alive,priority:2" json:"folder_id"`
+	Blocked    bool           `gorm:"blocked" json:"blocked"`
 }
 
 type DataRecordRequest struct {
+	Metadata Metadata `json:"metadata,omitempty"`
 	Type     DataType `json:"type"`
 	Checksum string   `json:"checksum"`
 	Data     string   `json:"data"`
 	Name     string   `json:"name"`
 	ID       uint64   `json:"id"`
+	FolderID uint64   `json:"folder_id"`
+}
+
+// RecordStats sums up the records of a type which are not in the trash.
+// Bytes is the size of their data as stored, encrypted data included.
+type RecordStats struct {
+	Type  DataType
+	Count int64
+	Bytes int64
 }
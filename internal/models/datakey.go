@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// AuditKeyID is the id under which the key of audit log hashes is kept among data keys,
+// there is no user with the id.
+const AuditKeyID = 0
+
+// DataKey is the key encrypting data of a user. It is stored wrapped by the master key
+// of MasterVersion and is rewrapped when the master key rotates.
+type DataKey struct {
+	UpdatedAt     time.Time `gorm:"not null;default:now()"`
+	Wrapped       []byte    `gorm:"not null"`
+	UserID        uint64    `gorm:"primaryKey;autoIncrement:false"`
+	MasterVersion uint32    `gorm:"not null;index"`
+}
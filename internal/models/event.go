@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+type EventType string
+
+const (
+	EventRecordChanged  EventType = "record.changed"
+	EventRecordDeleted  EventType = "record.deleted"
+	EventFoldersChanged EventType = "folders.changed"
+)
+
+// Event notifies clients of the user about a committed change of their data.
+// Revision is the update time of the change, the same one records are synced by.
+type Event struct {
+	Revision time.Time   `json:"revision"`
+	Record   *DataRecord `json:"record,omitempty"`
+	Type     EventType   `json:"type"`
+	Name     string      `json:"name,omitempty"`
+	FolderID uint64      `json:"folder_id"`
+	UserID   uint64      `json:"-"`
+}
+
+// RecordChangedEvent carries the saved record.
+func RecordChangedEvent(record *DataRecord) Event {
+	return Event{
+		Type:     EventRecordChanged,
+		Revision: record.UpdatedAt,
+		Record:   record,
+		Name:     record.Name,
+		FolderID: record.FolderID,
+		UserID:   record.UserID,
+	}
+}
+
+// RecordDeletedEvent names the record moved into the trash.
+func RecordDeletedEvent(userID uint64, name string, folderID uint64, at time.Time) Event {
+	return Event{
+		Type:     EventRecordDeleted,
+		Revision: at,
+		Name:     name,
+		FolderID: folderID,
+		UserID:   userID,
+	}
+}
+
+// FoldersChangedEvent tells that the folder tree of the user has to be reloaded.
+func FoldersChangedEvent(userID uint64, folderID uint64, at time.Time) Event {
+	return Event{
+		Type:     EventFoldersChanged,
+		Revision: at,
+		FolderID: folderID,
+		UserID:   userID,
+	}
+}
+
+// Change is an entry of the revision log. Changes are written in the transaction of the change
+// and relayed as events by every server replica, RecordID refers to the changed record.
+type Change struct {
+	Revision  time.Time `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null;default:now();index"`
+	Type      EventType `gorm:"not null"`
+	Name      string
+	ID        uint64 `gorm:"primaryKey"`
+	UserID    uint64 `gorm:"not null"`
+	RecordID  uint64
+	FolderID  uint64
+}
+
+// NewChange builds the revision log entry of the event.
+func NewChange(e Event) Change {
+	c := Change{Type: e.Type, Revision: e.Revision, Name: e.Name, UserID: e.UserID, FolderID: e.FolderID}
+	if e.Record != nil {
+		c.RecordID = e.Record.ID
+	}
+
+	return c
+}
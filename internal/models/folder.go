@@ -0,0 +1,61 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// RootFolderID is the folder of records which are not placed into any folder.
+const RootFolderID uint64 = 0
+
+// PathSeparator separates folder names in folder and record paths.
+const PathSeparator = "/"
+
+type Folder struct {
+	UpdatedAt time.Time `gorm:"not null;default:now()" json:"updated_at"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_folders_user_parent_name,priority:3" json:"name"`
+	Path      string    `gorm:"-" json:"path"`
+	User      User      `gorm:"not null;" json:"-"`
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	ParentID  uint64    `gorm:"not null;default:0;uniqueIndex:idx_folders_user_parent_name,priority:2" json:"parent_id"`
+	UserID    uint64    `gorm:"uniqueIndex:idx_folders_user_parent_name,priority:1" json:"-"`
+}
+
+type FolderRequest struct {
+	Name     string `json:"name"`
+	ParentID uint64 `json:"parent_id"`
+}
+
+type MoveRecordsRequest struct {
+	IDs      []uint64 `json:"ids"`
+	FolderID uint64   `json:"folder_id"`
+}
+
+// FillFolderPaths sets Path of every folder to the slash separated names from the root.
+func FillFolderPaths(folders []Folder) {
+	byID := make(map[uint64]*Folder, len(folders))
+	for i := range folders {
+		byID[folders[i].ID] = &folders[i]
+	}
+
+	for i := range folders {
+		names := []string{}
+		seen := map[uint64]bool{}
+		for f := &folders[i]; f != nil && !seen[f.ID]; f = byID[f.ParentID] {
+			seen[f.ID] = true
+			names = append([]string{f.Name}, names...)
+		}
+		folders[i].Path = strings.Join(names, PathSeparator)
+	}
+}
+
+// SplitPath splits "work/aws/root" into the folder path "work/aws" and the name "root".
+func SplitPath(path string) (string, string) {
+	path = strings.Trim(path, PathSeparator)
+	i := strings.LastIndex(path, PathSeparator)
+	if i < 0 {
+		return "", path
+	}
+
+	return path[:i], path[i+1:]
+}
@@ -0,0 +1,19 @@
+package models
+
+// Statuses of the health of the server and its dependencies.
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// CheckResult is the outcome of a check of a dependency. Reasons of failures are only logged,
+// the probes are served to anyone.
+type CheckResult struct {
+	Status string `json:"status"`
+}
+
+// HealthResponse is the status of the server with the results of its checks.
+type HealthResponse struct {
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+	Status string                 `json:"status"`
+}
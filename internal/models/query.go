@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type RecordsSort string
+
+const (
+	SortByName          RecordsSort = "name"
+	SortByNameDesc      RecordsSort = "-name"
+	SortByUpdatedAt     RecordsSort = "updated_at"
+	SortByUpdatedAtDesc RecordsSort = "-updated_at"
+)
+
+const (
+	DefaultRecordsLimit = 100
+	MaxRecordsLimit     = 1000
+)
+
+var ErrBadCursor = errors.New("malformed cursor")
+
+// RecordsQuery selects a page of user records. Zero values disable the corresponding filter.
+type RecordsQuery struct {
+	UpdatedSince time.Time
+	FolderID     *uint64
+	Type         DataType
+	Tag          string
+	NamePrefix   string
+	Cursor       string
+	Sort         RecordsSort
+	Limit        int
+}
+
+// RecordsPage is a page of records. NextCursor is empty on the last page.
+type RecordsPage struct {
+	NextCursor string       `json:"next_cursor,omitempty"`
+	Records    []DataRecord `json:"records"`
+}
+
+// Cursor points right after the last record of a page in the keyset order.
+type Cursor struct {
+	UpdatedAt time.Time `json:"u,omitempty"`
+	Name      string    `json:"n,omitempty"`
+	ID        uint64    `json:"i"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCursor, err)
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCursor, err)
+	}
+
+	return &c, nil
+}
+
+// CursorAfter builds the cursor continuing the listing after r.
+func CursorAfter(r *DataRecord) Cursor {
+	return Cursor{UpdatedAt: r.UpdatedAt, Name: r.Name, ID: r.ID}
+}
+
+// Valid reports whether s is a known sort order.
+func (s RecordsSort) Valid() bool {
+	switch s {
+	case SortByName, SortByNameDesc, SortByUpdatedAt, SortByUpdatedAtDesc:
+		return true
+	default:
+		return false
+	}
+}
+
+// Column returns the sorted column and whether the order is descending.
+func (s RecordsSort) Column() (string, bool) {
+	switch s {
+	case SortByNameDesc:
+		return "name", true
+	case SortByUpdatedAt:
+		return "updated_at", false
+	case SortByUpdatedAtDesc:
+		return "updated_at", true
+	default:
+		return "name", false
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrEmptySSHKey = errors.New("ssh key record has no private key")
+
+// SSHKey is the data of an SSHKEY record. PrivateKey is an unencrypted PEM encoded key,
+// PublicKey is in the authorized_keys format.
+type SSHKey struct {
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+	Comment    string `json:"comment"`
+}
+
+// ParseSSHKey decodes the data of an SSHKEY record.
+func ParseSSHKey(data string) (*SSHKey, error) {
+	var key SSHKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return nil, fmt.Errorf("error decoding ssh key: %w", err)
+	}
+
+	if key.PrivateKey == "" {
+		return nil, ErrEmptySSHKey
+	}
+
+	return &key, nil
+}
+
+// Encode returns the record data of the key.
+func (k *SSHKey) Encode() (string, error) {
+	b, err := json.Marshal(k)
+	if err != nil {
+		return "", fmt.Errorf("error encoding ssh key: %w", err)
+	}
+
+	return string(b), nil
+}
@@ -5,16 +5,23 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"time"
 )
 
 var (
 	ErrNoData = errors.New("no data")
 )
 
+// UserDataDir holds the file storage of users, a folder per user.
+const UserDataDir = "./userdata"
+
 type User struct {
 	Login    string `gorm:"varchar(100);index:idx_login,unique" json:"login"`
 	Password string `gorm:"varchar(255);not null" json:"-"`
-	ID       uint64 `gorm:"primaryKey" json:"id,omitempty"`
+	// ResetCodeHash is the sha256 of a one-time code an operator issued to set a new password.
+	ResetCodeHash  string     `gorm:"type:varchar(64)" json:"-"`
+	ResetExpiresAt *time.Time `json:"-"`
+	ID             uint64     `gorm:"primaryKey" json:"id,omitempty"`
 }
 
 type UserCredentialsSchema struct {
@@ -22,11 +29,28 @@ type UserCredentialsSchema struct {
 	Password string `json:"password"`
 }
 
+// LoginRequest carries the credentials and, to enroll the device, a request of its client certificate.
+type LoginRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	// CSR is a PEM certificate request of the device key.
+	CSR string `json:"csr,omitempty"`
+}
+
+// PasswordResetRequest sets a new password with a one-time code issued by an operator.
+type PasswordResetRequest struct {
+	Login    string `json:"login"`
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
 type TokenResponse struct {
 	Token     string `json:"token"`
 	ExpiresIn int    `json:"expires_in"`
+	// Certificate is the PEM client certificate issued for the device, the token is bound to it.
+	Certificate string `json:"certificate,omitempty"`
 }
 
 func (u *User) GetUserFolder() ([]fs.DirEntry, error) {
-	return os.ReadDir(fmt.Sprintf("./userdata/%s-%d", u.Login, u.ID))
+	return os.ReadDir(fmt.Sprintf("%s/%s-%d", UserDataDir, u.Login, u.ID))
 }
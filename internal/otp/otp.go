@@ -0,0 +1,247 @@
+// Package otp implements HOTP (RFC 4226) and TOTP (RFC 6238) one-time passwords
+// and the otpauth:// key URI format.
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind string
+
+const (
+	TOTP Kind = "totp"
+	HOTP Kind = "hotp"
+)
+
+type Algorithm string
+
+const (
+	SHA1   Algorithm = "SHA1"
+	SHA256 Algorithm = "SHA256"
+	SHA512 Algorithm = "SHA512"
+)
+
+const (
+	Scheme        = "otpauth"
+	DefaultDigits = 6
+	DefaultPeriod = 30
+	maxDigits     = 10
+)
+
+var (
+	ErrBadURI    = errors.New("malformed otpauth uri")
+	ErrBadSecret = errors.New("secret is not valid base32")
+)
+
+// Key is a shared secret with its generation parameters.
+type Key struct {
+	Kind      Kind
+	Algorithm Algorithm
+	Issuer    string
+	Account   string
+	Secret    string
+	Digits    int
+	Period    int
+	Counter   uint64
+}
+
+// NewTOTP builds a key with default parameters from a base32 secret.
+func NewTOTP(secret string) (*Key, error) {
+	k := &Key{
+		Kind:      TOTP,
+		Algorithm: SHA1,
+		Secret:    normalizeSecret(secret),
+		Digits:    DefaultDigits,
+		Period:    DefaultPeriod,
+	}
+
+	if _, err := k.secretBytes(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// ParseKey accepts either an otpauth:// URI or a bare base32 TOTP secret.
+func ParseKey(s string) (*Key, error) {
+	if strings.HasPrefix(s, Scheme+"://") {
+		return ParseURI(s)
+	}
+
+	return NewTOTP(s)
+}
+
+// ParseURI parses a key URI like otpauth://totp/Issuer:account?secret=...&digits=6.
+func ParseURI(raw string) (*Key, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadURI, err)
+	}
+	if u.Scheme != Scheme {
+		return nil, fmt.Errorf("%w: unexpected scheme %q", ErrBadURI, u.Scheme)
+	}
+
+	k := &Key{
+		Kind:      Kind(strings.ToLower(u.Host)),
+		Algorithm: SHA1,
+		Digits:    DefaultDigits,
+		Period:    DefaultPeriod,
+	}
+	if k.Kind != TOTP && k.Kind != HOTP {
+		return nil, fmt.Errorf("%w: unknown type %q", ErrBadURI, u.Host)
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(label, ":"); i >= 0 {
+		k.Issuer, k.Account = label[:i], strings.TrimSpace(label[i+1:])
+	} else {
+		k.Account = label
+	}
+
+	q := u.Query()
+	if issuer := q.Get("issuer"); issuer != "" {
+		k.Issuer = issuer
+	}
+
+	k.Secret = normalizeSecret(q.Get("secret"))
+	if k.Secret == "" {
+		return nil, fmt.Errorf("%w: no secret", ErrBadURI)
+	}
+	if _, err := k.secretBytes(); err != nil {
+		return nil, err
+	}
+
+	if alg := q.Get("algorithm"); alg != "" {
+		k.Algorithm = Algorithm(strings.ToUpper(alg))
+		if _, err := k.hash(); err != nil {
+			return nil, err
+		}
+	}
+
+	if digits := q.Get("digits"); digits != "" {
+		if k.Digits, err = strconv.Atoi(digits); err != nil || k.Digits <= 0 || k.Digits > maxDigits {
+			return nil, fmt.Errorf("%w: bad digits %q", ErrBadURI, digits)
+		}
+	}
+
+	if period := q.Get("period"); period != "" {
+		if k.Period, err = strconv.Atoi(period); err != nil || k.Period <= 0 {
+			return nil, fmt.Errorf("%w: bad period %q", ErrBadURI, period)
+		}
+	}
+
+	if counter := q.Get("counter"); counter != "" {
+		if k.Counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: bad counter %q", ErrBadURI, counter)
+		}
+	} else if k.Kind == HOTP {
+		return nil, fmt.Errorf("%w: hotp requires counter", ErrBadURI)
+	}
+
+	return k, nil
+}
+
+// URI encodes the key back into the otpauth:// form.
+func (k *Key) URI() string {
+	label := k.Account
+	if k.Issuer != "" {
+		label = k.Issuer + ":" + k.Account
+	}
+
+	q := url.Values{}
+	q.Set("secret", k.Secret)
+	if k.Issuer != "" {
+		q.Set("issuer", k.Issuer)
+	}
+	q.Set("algorithm", string(k.Algorithm))
+	q.Set("digits", strconv.Itoa(k.Digits))
+	if k.Kind == HOTP {
+		q.Set("counter", strconv.FormatUint(k.Counter, 10))
+	} else {
+		q.Set("period", strconv.Itoa(k.Period))
+	}
+
+	u := url.URL{Scheme: Scheme, Host: string(k.Kind), Path: "/" + label, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// Code returns the password valid at t. For TOTP it also returns how long the code stays valid,
+// for HOTP the code of the current counter is returned with zero duration.
+func (k *Key) Code(t time.Time) (string, time.Duration, error) {
+	if k.Kind == HOTP {
+		code, err := k.generate(k.Counter)
+		return code, 0, err
+	}
+
+	period := int64(k.Period)
+	step := t.Unix() / period
+	remaining := time.Duration(period-t.Unix()%period) * time.Second
+
+	code, err := k.generate(uint64(step))
+	return code, remaining, err
+}
+
+// generate computes the HOTP value of counter as described in RFC 4226 section 5.3.
+func (k *Key) generate(counter uint64) (string, error) {
+	secret, err := k.secretBytes()
+	if err != nil {
+		return "", err
+	}
+
+	h, err := k.hash()
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(h, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	code := uint64(value) % uint64(math.Pow10(k.Digits))
+
+	return fmt.Sprintf("%0*d", k.Digits, code), nil
+}
+
+func (k *Key) hash() (func() hash.Hash, error) {
+	switch k.Algorithm {
+	case SHA1, "":
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrBadURI, k.Algorithm)
+	}
+}
+
+func (k *Key) secretBytes() ([]byte, error) {
+	b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(k.Secret, "="))
+	if err != nil || len(b) == 0 {
+		return nil, ErrBadSecret
+	}
+
+	return b, nil
+}
+
+func normalizeSecret(s string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(s), " ", ""))
+}
@@ -0,0 +1,159 @@
+package otp
+
+import (
+	"encoding/base32"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func base32Secret(s string) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(s))
+}
+
+// TestHOTP checks the test values of RFC 4226 Appendix D.
+func TestHOTP(t *testing.T) {
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for counter, code := range want {
+		k := &Key{
+			Kind:      HOTP,
+			Algorithm: SHA1,
+			Secret:    base32Secret("12345678901234567890"),
+			Digits:    6,
+			Counter:   uint64(counter),
+		}
+
+		got, remaining, err := k.Code(time.Now())
+		if err != nil {
+			t.Fatalf("Code of counter %d: %v", counter, err)
+		}
+		if got != code || remaining != 0 {
+			t.Errorf("Code of counter %d = %s, %s, want %s", counter, got, remaining, code)
+		}
+	}
+}
+
+// TestTOTP checks the test vectors of RFC 6238 Appendix B.
+func TestTOTP(t *testing.T) {
+	seeds := map[Algorithm]string{
+		SHA1:   "12345678901234567890",
+		SHA256: "12345678901234567890123456789012",
+		SHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+
+	tests := []struct {
+		unix int64
+		want map[Algorithm]string
+	}{
+		{59, map[Algorithm]string{SHA1: "94287082", SHA256: "46119246", SHA512: "90693936"}},
+		{1111111109, map[Algorithm]string{SHA1: "07081804", SHA256: "68084774", SHA512: "25091201"}},
+		{1111111111, map[Algorithm]string{SHA1: "14050471", SHA256: "67062674", SHA512: "99943326"}},
+		{1234567890, map[Algorithm]string{SHA1: "89005924", SHA256: "91819424", SHA512: "93441116"}},
+		{2000000000, map[Algorithm]string{SHA1: "69279037", SHA256: "90698825", SHA512: "38618901"}},
+		{20000000000, map[Algorithm]string{SHA1: "65353130", SHA256: "77737706", SHA512: "47863826"}},
+	}
+
+	for _, tt := range tests {
+		for alg, code := range tt.want {
+			k := &Key{
+				Kind:      TOTP,
+				Algorithm: alg,
+				Secret:    base32Secret(seeds[alg]),
+				Digits:    8,
+				Period:    DefaultPeriod,
+			}
+
+			got, remaining, err := k.Code(time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatalf("Code %s at %d: %v", alg, tt.unix, err)
+			}
+			if got != code {
+				t.Errorf("Code %s at %d = %s, want %s", alg, tt.unix, got, code)
+			}
+			if wantRemaining := time.Duration(30-tt.unix%30) * time.Second; remaining != wantRemaining {
+				t.Errorf("Code %s at %d is valid for %s, want %s", alg, tt.unix, remaining, wantRemaining)
+			}
+		}
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+		want *Key
+		err  error
+	}{
+		{
+			name: "defaults",
+			uri:  "otpauth://totp/alice@example.com?secret=JBSWY3DPEHPK3PXP",
+			want: &Key{Kind: TOTP, Algorithm: SHA1, Account: "alice@example.com", Secret: "JBSWY3DPEHPK3PXP",
+				Digits: 6, Period: 30},
+		},
+		{
+			name: "issuer in label and parameters",
+			uri: "otpauth://TOTP/Example:%20alice@example.com?secret=jbswy3dpehpk3pxp&issuer=Example%20Inc" +
+				"&algorithm=sha256&digits=8&period=60",
+			want: &Key{Kind: TOTP, Algorithm: SHA256, Issuer: "Example Inc", Account: "alice@example.com",
+				Secret: "JBSWY3DPEHPK3PXP", Digits: 8, Period: 60},
+		},
+		{
+			name: "hotp",
+			uri:  "otpauth://hotp/Example:bob?secret=JBSWY3DPEHPK3PXP&counter=42&algorithm=SHA512",
+			want: &Key{Kind: HOTP, Algorithm: SHA512, Issuer: "Example", Account: "bob", Secret: "JBSWY3DPEHPK3PXP",
+				Digits: 6, Period: 30, Counter: 42},
+		},
+		{name: "other scheme", uri: "https://totp/alice?secret=JBSWY3DPEHPK3PXP", err: ErrBadURI},
+		{name: "unknown type", uri: "otpauth://motp/alice?secret=JBSWY3DPEHPK3PXP", err: ErrBadURI},
+		{name: "no secret", uri: "otpauth://totp/alice", err: ErrBadURI},
+		{name: "bad secret", uri: "otpauth://totp/alice?secret=not-base32!", err: ErrBadSecret},
+		{name: "unsupported algorithm", uri: "otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&algorithm=MD5", err: ErrBadURI},
+		{name: "too many digits", uri: "otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&digits=11", err: ErrBadURI},
+		{name: "zero period", uri: "otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&period=0", err: ErrBadURI},
+		{name: "hotp without counter", uri: "otpauth://hotp/alice?secret=JBSWY3DPEHPK3PXP", err: ErrBadURI},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseURI(tt.uri)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("ParseURI(%q): error %v is expected, got %v", tt.uri, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseURI(%q): %v", tt.uri, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseURI(%q) = %+v, want %+v", tt.uri, got, tt.want)
+			}
+
+			again, err := ParseURI(got.URI())
+			if err != nil || !reflect.DeepEqual(again, got) {
+				t.Errorf("ParseURI(%q) = %+v, %v, want the same key back", got.URI(), again, err)
+			}
+		})
+	}
+}
+
+func TestParseKeyBareSecret(t *testing.T) {
+	k, err := ParseKey(" jbsw y3dp ehpk 3pxp ")
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+
+	want := &Key{Kind: TOTP, Algorithm: SHA1, Secret: "JBSWY3DPEHPK3PXP", Digits: DefaultDigits, Period: DefaultPeriod}
+	if !reflect.DeepEqual(k, want) {
+		t.Errorf("ParseKey = %+v, want %+v", k, want)
+	}
+
+	if _, err := ParseKey("0189"); !errors.Is(err, ErrBadSecret) {
+		t.Errorf("ParseKey of a non base32 secret: ErrBadSecret is expected, got %v", err)
+	}
+}